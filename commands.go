@@ -0,0 +1,150 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+)
+
+// commandEnv holds everything that subcommands need access to.
+type commandEnv struct {
+	backend  *GitBackend
+	exporter *StaticExporter
+}
+
+// commands are the subcommands that can be run instead of starting the server, keyed by name.
+var commands = map[string]func(env *commandEnv, args []string) error{
+	"export":           exportCommand,
+	"import":           importCommand,
+	"import-mediawiki": importMediaWikiCommand,
+}
+
+// runCommand runs the subcommand named by the first argument.
+func runCommand(env *commandEnv, args []string) error {
+	command, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+	return command(env, args[1:])
+}
+
+// exportCommand writes a static HTML export of the wiki to the given directory, or to a zip file if the name ends
+// in .zip.
+func exportCommand(env *commandEnv, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: export <directory or file.zip>")
+	}
+
+	view, err := env.backend.CurrentView()
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasSuffix(strings.ToLower(args[0]), ".zip") {
+		w := &dirExportWriter{dir: args[0]}
+		if err := env.exporter.Export(view, w); err != nil {
+			_ = w.Close()
+			return err
+		}
+		return w.Close()
+	}
+
+	file, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	zw := zip.NewWriter(file)
+	if err := env.exporter.Export(view, zw); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// importCommand imports a directory or zip file of markdown pages and attachments into the wiki, optionally inside
+// a directory.
+func importCommand(env *commandEnv, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	user := flags.String("user", "Importer", "Name of the user to attribute the import to")
+	message := flags.String("message", "", "Message describing the import")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return errors.New("usage: import [-user name] [-message message] <directory or file.zip> [directory in wiki]")
+	}
+
+	var files []*ImportedFile
+	source := flags.Arg(0)
+	if strings.HasSuffix(strings.ToLower(source), ".zip") {
+		zr, err := zip.OpenReader(source)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+
+		if files, err = readImportZip(&zr.Reader); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if files, err = readImportDir(source); err != nil {
+			return err
+		}
+	}
+
+	result, err := Import(env.backend, files, strings.Trim(flags.Arg(1), "/"), *user, *message)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Imported %d pages and %d files", len(result.Pages), len(result.Files))
+	return nil
+}
+
+// importMediaWikiCommand imports a MediaWiki XML export into the wiki, optionally inside a directory, replaying the
+// history of every page.
+func importMediaWikiCommand(env *commandEnv, args []string) error {
+	flags := flag.NewFlagSet("import-mediawiki", flag.ContinueOnError)
+	files := flags.String("files", "", "MediaWiki's images directory, to import uploaded files from")
+	user := flags.String("user", "Importer", "Name of the user to record as committing the imported history")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return errors.New("usage: import-mediawiki [-files images directory] [-user name] <export.xml> [directory in wiki]")
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var uploads fs.FS
+	if *files != "" {
+		uploads = os.DirFS(*files)
+	}
+
+	result, err := ImportMediaWiki(env.backend, file, uploads, strings.Trim(flags.Arg(1), "/"), *user)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Imported %d revisions of %d pages and %d files", result.Revisions, len(result.Pages), len(result.Files))
+	for _, name := range result.MissingFiles {
+		log.Printf("Unable to find the contents of %s", name)
+	}
+	return nil
+}
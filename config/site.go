@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"net/http"
+	"path"
 	"strings"
 )
 
@@ -13,6 +14,9 @@ type Site struct {
 	Favicon  []byte
 	MainLogo []byte
 	DarkLogo []byte
+	// ProtectedPaths are the prefixes of pages that only admins may change directly. Anyone else's changes to them
+	// are submitted as change requests.
+	ProtectedPaths []string
 
 	store Store
 }
@@ -65,6 +69,21 @@ func (s *Site) Update(config *Site, responsible string) error {
 
 		s.DarkLogo = config.DarkLogo
 	}
+	if config.ProtectedPaths != nil {
+		s.ProtectedPaths = config.ProtectedPaths
+	}
 
 	return s.store.PutSettings(siteSettingsName, responsible, "Updating site config", s)
 }
+
+// IsProtected determines whether the page with the given name falls under one of the protected path prefixes.
+func (s *Site) IsProtected(name string) bool {
+	name = path.Clean("/" + strings.ToLower(name))
+	for i := range s.ProtectedPaths {
+		prefix := path.Clean("/" + strings.ToLower(s.ProtectedPaths[i]))
+		if prefix == "/" || name == prefix || strings.HasPrefix(name, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
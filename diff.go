@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// defaultDiffContext is the number of unchanged lines shown around each change when viewing a diff.
+const defaultDiffContext = 3
+
+// DiffOptions controls how the differences between two texts are found and presented.
+type DiffOptions struct {
+	// Context is the number of unchanged lines to keep around each change. If negative, every line is kept.
+	Context int
+	// IgnoreWhitespace treats lines that differ only in whitespace as unchanged.
+	IgnoreWhitespace bool
+}
+
+// DiffLineType describes whether a line in a diff was unchanged, removed or added. The values double as CSS classes.
+type DiffLineType string
+
+const (
+	DiffLineContext DiffLineType = "context"
+	DiffLineRemoved DiffLineType = "removed"
+	DiffLineAdded   DiffLineType = "added"
+)
+
+// DiffSegment is part of a changed line. Changed segments are the words that differ from the line it replaced.
+type DiffSegment struct {
+	Text    string
+	Changed bool
+}
+
+// DiffLine is a single line of a diff. OldNumber and NewNumber are the line's 1-based position in each text, or 0
+// if it doesn't appear in that text. Segments is only populated for changed lines that were paired with a similar
+// line on the other side.
+type DiffLine struct {
+	Type      DiffLineType
+	OldNumber int
+	NewNumber int
+	Text      string
+	Segments  []DiffSegment
+
+	// oldPos and newPos are the number of lines that precede this one in each text.
+	oldPos, newPos int
+	noNewline      bool
+}
+
+// DiffHunk is a run of changed lines along with their surrounding context.
+type DiffHunk struct {
+	// Skipped is the number of unchanged lines omitted before the hunk.
+	Skipped int
+	Lines   []*DiffLine
+}
+
+// DiffRow is a pair of lines shown next to each other in a side-by-side diff. Either side may be nil.
+type DiffRow struct {
+	Left  *DiffLine
+	Right *DiffLine
+}
+
+// TextDiff is a line-based diff between two texts.
+type TextDiff struct {
+	// OldPath and NewPath are the names of the file being compared, or empty if it didn't exist on that side.
+	OldPath string
+	NewPath string
+	Hunks   []*DiffHunk
+	// Skipped is the number of unchanged lines omitted after the last hunk.
+	Skipped int
+}
+
+// diffText compares two texts line by line, highlighting the words that changed within modified lines.
+func diffText(old, new string, options DiffOptions) *TextDiff {
+	lines := diffLines(splitLines(old), splitLines(new), options.IgnoreWhitespace)
+	highlightWords(lines)
+	hunks, skipped := groupHunks(lines, options.Context)
+	return &TextDiff{Hunks: hunks, Skipped: skipped}
+}
+
+// diffLines finds the lines that were removed from or added to the old text. Lines are expected to keep their
+// line endings, as returned by splitLines.
+func diffLines(oldLines, newLines []string, ignoreWhitespace bool) []*DiffLine {
+	key := func(line string) string {
+		if ignoreWhitespace {
+			return strings.Join(strings.Fields(line), " ")
+		}
+		return line
+	}
+
+	symbols := make(map[string]rune)
+	dmp := diffmatchpatch.New()
+	diffs := dmp.DiffMainRunes(toSymbols(oldLines, symbols, key), toSymbols(newLines, symbols, key), false)
+
+	var result []*DiffLine
+	o, n := 0, 0
+	for _, diff := range diffs {
+		for count := len([]rune(diff.Text)); count > 0; count-- {
+			line := &DiffLine{oldPos: o, newPos: n}
+			switch diff.Type {
+			case diffmatchpatch.DiffEqual:
+				line.Type, line.OldNumber, line.NewNumber = DiffLineContext, o+1, n+1
+				line.setText(newLines[n])
+				o++
+				n++
+			case diffmatchpatch.DiffDelete:
+				line.Type, line.OldNumber = DiffLineRemoved, o+1
+				line.setText(oldLines[o])
+				o++
+			case diffmatchpatch.DiffInsert:
+				line.Type, line.NewNumber = DiffLineAdded, n+1
+				line.setText(newLines[n])
+				n++
+			}
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+func (l *DiffLine) setText(line string) {
+	l.Text = strings.TrimSuffix(line, "\n")
+	l.noNewline = !strings.HasSuffix(line, "\n")
+}
+
+// toSymbols maps each item to a rune, so that sequences of lines or words can be compared with the character-based
+// diff algorithm. Items with the same key share a rune. Surrogates are skipped, as they aren't valid runes.
+func toSymbols(items []string, symbols map[string]rune, key func(string) string) []rune {
+	result := make([]rune, len(items))
+	for i := range items {
+		k := key(items[i])
+		r, ok := symbols[k]
+		if !ok {
+			r = rune(len(symbols) + 1)
+			if r >= 0xD800 {
+				r += 0x800
+			}
+			symbols[k] = r
+		}
+		result[i] = r
+	}
+	return result
+}
+
+var wordPattern = regexp.MustCompile(`[\p{L}\p{N}_]+|\s+|.`)
+
+// highlightWords pairs up each block of removed lines with the block of added lines that replaced it, and marks
+// the words that differ between each pair.
+func highlightWords(lines []*DiffLine) {
+	for i := 0; i < len(lines); {
+		if lines[i].Type != DiffLineRemoved {
+			i++
+			continue
+		}
+
+		removedStart := i
+		for i < len(lines) && lines[i].Type == DiffLineRemoved {
+			i++
+		}
+		addedStart := i
+		for i < len(lines) && lines[i].Type == DiffLineAdded {
+			i++
+		}
+
+		for j := 0; removedStart+j < addedStart && addedStart+j < i; j++ {
+			highlightPair(lines[removedStart+j], lines[addedStart+j])
+		}
+	}
+}
+
+// highlightPair marks the words that differ between a removed line and the line that replaced it. Lines with
+// nothing in common aren't highlighted, as every word would be marked.
+func highlightPair(removed, added *DiffLine) {
+	oldWords := wordPattern.FindAllString(removed.Text, -1)
+	newWords := wordPattern.FindAllString(added.Text, -1)
+
+	symbols := make(map[string]rune)
+	identity := func(s string) string { return s }
+	dmp := diffmatchpatch.New()
+	diffs := dmp.DiffMainRunes(toSymbols(oldWords, symbols, identity), toSymbols(newWords, symbols, identity), false)
+
+	var oldSegments, newSegments []DiffSegment
+	var common bool
+	o, n := 0, 0
+	for _, diff := range diffs {
+		count := len([]rune(diff.Text))
+		switch diff.Type {
+		case diffmatchpatch.DiffEqual:
+			text := strings.Join(newWords[n:n+count], "")
+			common = common || strings.TrimSpace(text) != ""
+			oldSegments = appendSegment(oldSegments, text, false)
+			newSegments = appendSegment(newSegments, text, false)
+			o += count
+			n += count
+		case diffmatchpatch.DiffDelete:
+			oldSegments = appendSegment(oldSegments, strings.Join(oldWords[o:o+count], ""), true)
+			o += count
+		case diffmatchpatch.DiffInsert:
+			newSegments = appendSegment(newSegments, strings.Join(newWords[n:n+count], ""), true)
+			n += count
+		}
+	}
+
+	if common {
+		removed.Segments = oldSegments
+		added.Segments = newSegments
+	}
+}
+
+// appendSegment adds text to a list of segments, merging it with the last segment if they're both (un)changed.
+func appendSegment(segments []DiffSegment, text string, changed bool) []DiffSegment {
+	if len(segments) > 0 && segments[len(segments)-1].Changed == changed {
+		segments[len(segments)-1].Text += text
+		return segments
+	}
+	return append(segments, DiffSegment{Text: text, Changed: changed})
+}
+
+// groupHunks splits the lines of a diff into hunks of changes with the given number of lines of context, returning
+// them along with the number of unchanged lines that follow the last hunk.
+func groupHunks(lines []*DiffLine, context int) ([]*DiffHunk, int) {
+	keep := make([]bool, len(lines))
+	for i := range lines {
+		if lines[i].Type == DiffLineContext {
+			continue
+		}
+
+		if context < 0 {
+			for j := range keep {
+				keep[j] = true
+			}
+			break
+		}
+
+		for j := max(0, i-context); j <= min(len(lines)-1, i+context); j++ {
+			keep[j] = true
+		}
+	}
+
+	var hunks []*DiffHunk
+	var current *DiffHunk
+	skipped := 0
+	for i := range lines {
+		if !keep[i] {
+			current = nil
+			skipped++
+			continue
+		}
+
+		if current == nil {
+			current = &DiffHunk{Skipped: skipped}
+			hunks = append(hunks, current)
+			skipped = 0
+		}
+		current.Lines = append(current.Lines, lines[i])
+	}
+	return hunks, skipped
+}
+
+// Header returns the hunk's range line, in the format used by unified diffs.
+func (h *DiffHunk) Header() string {
+	var oldCount, newCount int
+	for i := range h.Lines {
+		if h.Lines[i].Type != DiffLineAdded {
+			oldCount++
+		}
+		if h.Lines[i].Type != DiffLineRemoved {
+			newCount++
+		}
+	}
+
+	oldStart, newStart := h.Lines[0].oldPos, h.Lines[0].newPos
+	if oldCount > 0 {
+		oldStart++
+	}
+	if newCount > 0 {
+		newStart++
+	}
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", oldStart, oldCount, newStart, newCount)
+}
+
+// Rows pairs up the hunk's lines for a side-by-side view. Unchanged lines appear on both sides, and removed lines
+// are shown next to the lines that replaced them.
+func (h *DiffHunk) Rows() []*DiffRow {
+	var rows []*DiffRow
+	for i := 0; i < len(h.Lines); {
+		if h.Lines[i].Type == DiffLineContext {
+			rows = append(rows, &DiffRow{Left: h.Lines[i], Right: h.Lines[i]})
+			i++
+			continue
+		}
+
+		var removed, added []*DiffLine
+		for i < len(h.Lines) && h.Lines[i].Type == DiffLineRemoved {
+			removed = append(removed, h.Lines[i])
+			i++
+		}
+		for i < len(h.Lines) && h.Lines[i].Type == DiffLineAdded {
+			added = append(added, h.Lines[i])
+			i++
+		}
+
+		for j := 0; j < max(len(removed), len(added)); j++ {
+			row := &DiffRow{}
+			if j < len(removed) {
+				row.Left = removed[j]
+			}
+			if j < len(added) {
+				row.Right = added[j]
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// Patch formats the diff as a unified diff, suitable for applying with git apply or patch. Diffs that ignore
+// whitespace changes won't apply cleanly, as their context lines are taken from the new text.
+func (d *TextDiff) Patch() string {
+	name := func(prefix, path string) string {
+		if path == "" {
+			return "/dev/null"
+		}
+		return prefix + path
+	}
+
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "--- %s\n+++ %s\n", name("a/", d.OldPath), name("b/", d.NewPath))
+	for _, hunk := range d.Hunks {
+		b.WriteString(hunk.Header())
+		b.WriteByte('\n')
+		for _, line := range hunk.Lines {
+			switch line.Type {
+			case DiffLineContext:
+				b.WriteByte(' ')
+			case DiffLineRemoved:
+				b.WriteByte('-')
+			case DiffLineAdded:
+				b.WriteByte('+')
+			}
+			b.WriteString(line.Text)
+			b.WriteByte('\n')
+			if line.noNewline {
+				b.WriteString("\\ No newline at end of file\n")
+			}
+		}
+	}
+	return b.String()
+}
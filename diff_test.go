@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffText(t *testing.T) {
+	old := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+	new := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine changed\nten\neleven"
+
+	diff := diffText(old, new, DiffOptions{Context: 1})
+	if len(diff.Hunks) != 1 || diff.Hunks[0].Skipped != 7 || diff.Skipped != 0 {
+		t.Fatalf("diffText() hunks = %v, skipped = %d", diff.Hunks, diff.Skipped)
+	}
+
+	var types []DiffLineType
+	for _, line := range diff.Hunks[0].Lines {
+		types = append(types, line.Type)
+	}
+	want := []DiffLineType{DiffLineContext, DiffLineRemoved, DiffLineAdded, DiffLineContext, DiffLineAdded}
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("diffText() line types = %v, want %v", types, want)
+	}
+
+	changed := diff.Hunks[0].Lines[2]
+	if changed.Text != "nine changed" || changed.NewNumber != 9 || changed.OldNumber != 0 {
+		t.Errorf("diffText() changed line = %+v", changed)
+	}
+	if !reflect.DeepEqual(changed.Segments, []DiffSegment{{Text: "nine", Changed: false}, {Text: " changed", Changed: true}}) {
+		t.Errorf("diffText() segments = %+v", changed.Segments)
+	}
+
+	if rows := diff.Hunks[0].Rows(); len(rows) != 4 || rows[1].Left.Text != "nine" || rows[1].Right.Text != "nine changed" || rows[3].Left != nil || rows[3].Right.Text != "eleven" {
+		t.Errorf("Rows() = %v", rows)
+	}
+
+	diff.OldPath, diff.NewPath = "page.md", "page.md"
+	wantPatch := "--- a/page.md\n+++ b/page.md\n@@ -8,3 +8,4 @@\n eight\n-nine\n+nine changed\n ten\n+eleven\n\\ No newline at end of file\n"
+	if patch := diff.Patch(); patch != wantPatch {
+		t.Errorf("Patch() = %q, want %q", patch, wantPatch)
+	}
+
+	if all := diffText(old, new, DiffOptions{Context: -1}); len(all.Hunks) != 1 || len(all.Hunks[0].Lines) != 12 {
+		t.Errorf("diffText() with full context = %v", all.Hunks)
+	}
+}
+
+func TestDiffText_IgnoreWhitespace(t *testing.T) {
+	old := "a  line\nanother\n"
+	new := "a line\n  another\n"
+
+	if diff := diffText(old, new, DiffOptions{IgnoreWhitespace: true}); len(diff.Hunks) != 0 {
+		t.Errorf("diffText() ignoring whitespace = %v, want no changes", diff.Hunks)
+	}
+	if diff := diffText(old, new, DiffOptions{}); len(diff.Hunks) != 1 {
+		t.Errorf("diffText() = %v, want one hunk", diff.Hunks)
+	}
+}
+
+func TestDiffText_NewFile(t *testing.T) {
+	diff := diffText("", "new\n", DiffOptions{Context: defaultDiffContext})
+	diff.NewPath = "page.md"
+	if patch := diff.Patch(); !strings.Contains(patch, "--- /dev/null\n+++ b/page.md\n@@ -0,0 +1,1 @@\n+new\n") {
+		t.Errorf("Patch() = %q", patch)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mdbot/wiki/config"
+)
+
+// exportLinkPattern matches root-relative links in rendered pages, which won't work once the pages are exported.
+var exportLinkPattern = regexp.MustCompile(`(href|src|srcset)="(/[^"]*)"`)
+
+// ExportSource provides the pages and files to include in a static export.
+type ExportSource interface {
+	PageExists(title string) bool
+	GetPage(title string) (*Page, error)
+	GetFile(name string) (io.ReadCloser, error)
+	ListPages() ([]string, error)
+	ListFiles() ([]File, error)
+}
+
+// exportWriter stores the files that make up a static export. Each file is complete once the next one is created.
+type exportWriter interface {
+	Create(name string) (io.Writer, error)
+}
+
+// StaticExporter renders every page in the wiki to HTML, along with everything needed to view them, so that the
+// wiki can be browsed without running the server.
+type StaticExporter struct {
+	templates  *Templates
+	renderer   SnapshotRenderer
+	siteConfig *config.Site
+	static     fs.FS
+}
+
+func NewStaticExporter(templates *Templates, renderer SnapshotRenderer, siteConfig *config.Site, static fs.FS) *StaticExporter {
+	return &StaticExporter{
+		templates:  templates,
+		renderer:   renderer,
+		siteConfig: siteConfig,
+		static:     static,
+	}
+}
+
+// Export writes the pages and files from the source to the writer. Pages are written to view/<title>.html, uploaded
+// files to files/<name>, and an index of everything to index.html. Links between them are made relative, so the
+// export can be served from anywhere or opened directly from disk.
+func (e *StaticExporter) Export(source ExportSource, w exportWriter) error {
+	pages, err := source.ListPages()
+	if err != nil {
+		return err
+	}
+
+	files, err := source.ListFiles()
+	if err != nil {
+		return err
+	}
+
+	for i := range pages {
+		if err := e.exportPage(source, w, pages[i]); err != nil {
+			return fmt.Errorf("unable to export page %s: %w", pages[i], err)
+		}
+	}
+
+	b := &bytes.Buffer{}
+	if err := e.templates.ExportIndex(b, pages, files); err != nil {
+		return err
+	}
+	if err := writeExportFile(w, "index.html", bytes.NewReader(relativeLinks(b.Bytes(), "index.html"))); err != nil {
+		return err
+	}
+
+	for i := range files {
+		if err := e.exportFile(source, w, files[i].Name); err != nil {
+			return fmt.Errorf("unable to export file %s: %w", files[i].Name, err)
+		}
+	}
+
+	return e.exportAssets(w)
+}
+
+func (e *StaticExporter) exportPage(source ExportSource, w exportWriter, title string) error {
+	page, err := source.GetPage(title)
+	if err != nil {
+		return err
+	}
+
+	// Redirects can't be followed without the server, so link to their target instead
+	content := page.Content
+	if target, ok := parseRedirect(content); ok {
+		content = []byte(fmt.Sprintf("This page has moved to [[%s]].", target))
+	}
+
+	html, err := e.renderer.RenderAt(content, source, "")
+	if err != nil {
+		return err
+	}
+
+	b := &bytes.Buffer{}
+	if err := e.templates.ExportPage(b, title, html, &LastModifiedDetails{
+		User: page.LastModified.User,
+		Time: page.LastModified.Time,
+	}); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("view/%s.html", title)
+	return writeExportFile(w, name, bytes.NewReader(relativeLinks(b.Bytes(), name)))
+}
+
+func (e *StaticExporter) exportFile(source ExportSource, w exportWriter, name string) error {
+	reader, err := source.GetFile(name)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return writeExportFile(w, fmt.Sprintf("files/%s", name), reader)
+}
+
+// exportAssets copies the static files used by the page templates, and the site's logos.
+func (e *StaticExporter) exportAssets(w exportWriter) error {
+	err := fs.WalkDir(e.static, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		file, err := e.static.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		return writeExportFile(w, fmt.Sprintf("static/%s", name), file)
+	})
+	if err != nil {
+		return err
+	}
+
+	logos := map[string][]byte{
+		"logo/favicon": e.siteConfig.Favicon,
+		"logo/main":    e.siteConfig.MainLogo,
+		"logo/dark":    e.siteConfig.DarkLogo,
+	}
+	for name, content := range logos {
+		if content == nil {
+			continue
+		}
+		if err := writeExportFile(w, name, bytes.NewReader(content)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeExportFile(w exportWriter, name string, content io.Reader) error {
+	out, err := w.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, content)
+	return err
+}
+
+// relativeLinks rewrites links to pages, files and static assets in the HTML of the named export file, so that they
+// point to the exported copies relative to the file. Links to anything that isn't exported are left alone.
+func relativeLinks(html []byte, name string) []byte {
+	root := strings.Repeat("../", strings.Count(name, "/"))
+	return exportLinkPattern.ReplaceAllFunc(html, func(match []byte) []byte {
+		parts := exportLinkPattern.FindSubmatch(match)
+		target, ok := exportPath(string(parts[2]))
+		if !ok {
+			return match
+		}
+		return []byte(fmt.Sprintf(`%s="%s%s"`, parts[1], root, target))
+	})
+}
+
+// exportPath returns the path within an export of the file that a link to the wiki refers to, if it is exported.
+// Query strings are dropped, as old revisions and other views of a page aren't exported. Names of pages and files
+// are lower cased to match the exported files, as the server would do when following the link.
+func exportPath(link string) (string, bool) {
+	link, fragment, hasFragment := strings.Cut(link, "#")
+	link, _, _ = strings.Cut(link, "?")
+	if hasFragment {
+		fragment = "#" + fragment
+	}
+
+	switch {
+	case link == "/" || link == "/wiki/index" || link == "/wiki/files":
+		return "index.html" + fragment, true
+	case strings.HasPrefix(link, "/view/"):
+		return fmt.Sprintf("view/%s.html%s", strings.ToLower(strings.TrimPrefix(link, "/view/")), fragment), true
+	case strings.HasPrefix(link, "/files/view/"):
+		return "files/" + strings.ToLower(strings.TrimPrefix(link, "/files/view/")), true
+	case strings.HasPrefix(link, "/static/"):
+		return "static/" + strings.TrimPrefix(link, "/static/"), true
+	case strings.HasPrefix(link, "/wiki/logo/"):
+		return "logo/" + strings.TrimPrefix(link, "/wiki/logo/"), true
+	default:
+		return "", false
+	}
+}
+
+// dirExportWriter writes an export to a directory on disk.
+type dirExportWriter struct {
+	dir     string
+	current *os.File
+}
+
+func (d *dirExportWriter) Create(name string) (io.Writer, error) {
+	if err := d.Close(); err != nil {
+		return nil, err
+	}
+
+	target := filepath.Join(d.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), os.FileMode(0755)); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(target)
+	if err != nil {
+		return nil, err
+	}
+	d.current = file
+	return file, nil
+}
+
+// Close closes the last file that was created.
+func (d *dirExportWriter) Close() error {
+	if d.current == nil {
+		return nil
+	}
+	err := d.current.Close()
+	d.current = nil
+	return err
+}
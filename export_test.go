@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mdbot/wiki/config"
+	"github.com/mdbot/wiki/markdown"
+)
+
+// memoryExportWriter collects an export in memory.
+type memoryExportWriter map[string]*bytes.Buffer
+
+func (m memoryExportWriter) Create(name string) (io.Writer, error) {
+	m[name] = &bytes.Buffer{}
+	return m[name], nil
+}
+
+func TestStaticExporter_Export(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("docs/guide", "", []byte("See [[home]] and ![[docs/image.png]]"), "user", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutPage("home", "", []byte("# Home"), "user", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutPage("old", "", []byte("#REDIRECT [[home]]"), "user", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutFile("docs/image.png", io.NopCloser(strings.NewReader("image")), "user", "upload"); err != nil {
+		t.Fatalf("PutFile() error = %v", err)
+	}
+
+	site := &config.Site{Name: "Test"}
+	templates := &Templates{
+		fs:         os.DirFS("resources/templates"),
+		siteConfig: site,
+		checker:    &PermissionChecker{},
+		sidebarProvider: func(string) string {
+			return `<a href="/view/home">Home</a>`
+		},
+	}
+	static := fstest.MapFS{"style.css": {Data: []byte("body {}")}}
+	exporter := NewStaticExporter(templates, markdown.NewRenderer(backend, false, ""), site, static)
+
+	view, err := backend.CurrentView()
+	if err != nil {
+		t.Fatalf("CurrentView() error = %v", err)
+	}
+	out := make(memoryExportWriter)
+	if err := exporter.Export(view, out); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	for _, name := range []string{"index.html", "view/home.html", "view/old.html", "files/docs/image.png", "static/style.css"} {
+		if _, ok := out[name]; !ok {
+			t.Errorf("Export() didn't write %s", name)
+		}
+	}
+
+	guide := out["view/docs/guide.html"].String()
+	for _, want := range []string{`href="../../view/home.html"`, `src="../../files/docs/image.png"`, `href="../../static/style.css"`, `href="../../index.html"`} {
+		if !strings.Contains(guide, want) {
+			t.Errorf("Exported page doesn't contain %s:\n%s", want, guide)
+		}
+	}
+	if strings.Contains(guide, "/wiki/login") || strings.Contains(guide, "/edit/") {
+		t.Errorf("Exported page contains links that need the server:\n%s", guide)
+	}
+
+	if old := out["view/old.html"].String(); !strings.Contains(old, `href="../view/home.html"`) {
+		t.Errorf("Exported redirect doesn't link to its target:\n%s", old)
+	}
+	if index := out["index.html"].String(); !strings.Contains(index, `href="view/docs/guide.html"`) || !strings.Contains(index, `href="files/docs/image.png"`) {
+		t.Errorf("Exported index doesn't link to pages and files:\n%s", index)
+	}
+}
+
+func TestRelativeLinks(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"index.html", `<a href="/view/Page#Top">`, `<a href="view/page.html#Top">`},
+		{"view/a/b.html", `<a href="/view/page?rev=abc">`, `<a href="../../view/page.html">`},
+		{"view/page.html", `<img src="/files/view/image.png">`, `<img src="../files/image.png">`},
+		{"view/page.html", `<a href="/wiki/changes">`, `<a href="/wiki/changes">`},
+		{"view/page.html", `<a href="https://example.com/view/page">`, `<a href="https://example.com/view/page">`},
+	}
+	for _, tt := range tests {
+		if got := string(relativeLinks([]byte(tt.html), tt.name)); got != tt.want {
+			t.Errorf("relativeLinks(%q, %q) = %q, want %q", tt.html, tt.name, got, tt.want)
+		}
+	}
+}
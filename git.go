@@ -3,13 +3,19 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"log"
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
 )
 
 type GitBackend struct {
@@ -18,35 +24,182 @@ type GitBackend struct {
 	mutex sync.RWMutex
 	dir   string
 	repo  *git.Repository
+	// bare indicates the repository has no working tree, and all content should be read from and written to git
+	// objects directly.
+	bare bool
+	// gitDir is the directory containing git's own files; the data directory itself in bare mode.
+	gitDir string
+	// head is the commit that the wiki last saw HEAD pointing at, used to spot changes made outside of the wiki.
+	head plumbing.Hash
+	lock *os.File
+	// closed is closed when the backend is, to stop any background work.
+	closed chan struct{}
+	// background tracks the goroutines doing background work, so Close can wait for them to finish.
+	background sync.WaitGroup
+
+	remote     string
+	syncStatus SyncStatus
+	// network serialises fetches from and pushes to the remote. They're made without holding mutex, so that
+	// the wiki can still be read and written while waiting on the network.
+	network sync.Mutex
+	// unpublished is signalled when there are local changes to push to the remote.
+	unpublished chan struct{}
+}
+
+type GitOptions struct {
+	// Bare determines whether a bare repository is created if the data directory doesn't already contain one.
+	// Existing repositories are used in whichever mode they were created.
+	Bare bool
+	// Remote is the URL of a git repository to synchronise with. If empty, no synchronisation takes place.
+	Remote string
+	// SyncInterval is how often to fetch and merge changes from the remote. If zero, changes are only fetched
+	// at start up and when local changes are pushed.
+	SyncInterval time.Duration
+	// WatchInterval is how often to check whether HEAD has been moved by something other than the wiki. If
+	// zero, HEAD is not watched.
+	WatchInterval time.Duration
 }
 
-func NewGitBackend(dataDirectory string) (*GitBackend, error) {
-	gitRepo, err := openOrInit(dataDirectory)
+func NewGitBackend(dataDirectory string, options GitOptions) (*GitBackend, error) {
+	gitRepo, err := openOrInit(dataDirectory, options.Bare)
 	if err != nil {
 		return nil, fmt.Errorf("unable to open working directory: %w", err)
 	}
 
-	return &GitBackend{
-		dir:  dataDirectory,
-		repo: gitRepo,
-	}, nil
+	_, err = gitRepo.Worktree()
+	if err != nil && err != git.ErrIsBareRepository {
+		return nil, fmt.Errorf("unable to open working tree: %w", err)
+	}
+
+	backend := &GitBackend{
+		dir:         dataDirectory,
+		repo:        gitRepo,
+		bare:        err == git.ErrIsBareRepository,
+		gitDir:      filepath.Join(dataDirectory, git.GitDirName),
+		closed:      make(chan struct{}),
+		remote:      options.Remote,
+		unpublished: make(chan struct{}, 1),
+	}
+
+	if backend.bare {
+		backend.gitDir = dataDirectory
+	}
+
+	backend.lock, err = lockDirectory(backend.gitDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to lock working directory: %w", err)
+	}
+
+	if err := backend.absorbWorktreeChanges(); err != nil {
+		_ = backend.Close()
+		return nil, fmt.Errorf("unable to absorb changes made outside of the wiki: %w", err)
+	}
+
+	if head, err := backend.headCommit(); err != nil {
+		_ = backend.Close()
+		return nil, err
+	} else if head != nil {
+		backend.head = head.Hash
+	}
+
+	if options.Remote != "" {
+		if err := backend.configureRemote(options.Remote); err != nil {
+			_ = backend.Close()
+			return nil, fmt.Errorf("unable to configure remote: %w", err)
+		}
+
+		backend.syncStatus.Remote = options.Remote
+		if err := backend.Sync(); err != nil {
+			log.Printf("Unable to synchronise with remote repository: %v", err)
+		}
+
+		backend.background.Add(1)
+		go backend.publishPeriodically()
+
+		if options.SyncInterval > 0 {
+			backend.background.Add(1)
+			go backend.syncPeriodically(options.SyncInterval)
+		}
+	}
+
+	if options.WatchInterval > 0 {
+		backend.background.Add(1)
+		go backend.watchHead(options.WatchInterval)
+	}
+
+	return backend, nil
 }
 
-func openOrInit(dataDirectory string) (*git.Repository, error) {
+func openOrInit(dataDirectory string, bare bool) (*git.Repository, error) {
 	gitRepo, err := git.PlainOpen(dataDirectory)
 	if err == nil {
 		return gitRepo, nil
 	}
-	gitRepo, err = git.PlainInit(dataDirectory, false)
+	gitRepo, err = git.PlainInit(dataDirectory, bare)
 	if err == nil {
 		return gitRepo, nil
 	}
 	return nil, err
 }
 
-// walkFiles calls filepath.WalkDir, filtering out private data (.git and .wiki folders), and supplying
-// both the path on disk and the web-appropriate path to the handler function.
-func (g *GitBackend) walkFiles(handler func(filePath, webPath string, info fs.DirEntry) error) error {
+// wikiFile provides access to a file stored in the wiki, regardless of whether it is being read from the working
+// tree or directly from git objects.
+type wikiFile interface {
+	Size() (int64, error)
+	Open() (io.ReadCloser, error)
+}
+
+type diskFile struct {
+	path  string
+	entry fs.DirEntry
+}
+
+func (d *diskFile) Size() (int64, error) {
+	info, err := d.entry.Info()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (d *diskFile) Open() (io.ReadCloser, error) {
+	return os.Open(d.path)
+}
+
+type blobFile struct {
+	repo *git.Repository
+	hash plumbing.Hash
+}
+
+func (b *blobFile) Size() (int64, error) {
+	blob, err := b.repo.BlobObject(b.hash)
+	if err != nil {
+		return 0, err
+	}
+	return blob.Size, nil
+}
+
+func (b *blobFile) Open() (io.ReadCloser, error) {
+	blob, err := b.repo.BlobObject(b.hash)
+	if err != nil {
+		return nil, err
+	}
+	return blob.Reader()
+}
+
+// walkFiles calls the handler for each file in the wiki, filtering out private data (.git and .wiki folders), and
+// supplying the web-appropriate path to the handler function. In bare mode files are read from the tree at HEAD,
+// otherwise from the working tree.
+func (g *GitBackend) walkFiles(handler func(webPath string, file wikiFile) error) error {
+	if g.bare {
+		commit, err := g.headCommit()
+		if err != nil || commit == nil {
+			return err
+		}
+
+		return g.walkCommitFiles(commit, handler)
+	}
+
 	return filepath.WalkDir(g.dir, func(path string, info fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -63,10 +216,79 @@ func (g *GitBackend) walkFiles(handler func(filePath, webPath string, info fs.Di
 		if err != nil {
 			return err
 		}
-		return handler(path, strings.ReplaceAll(rel, string(filepath.Separator), "/"), info)
+		return handler(strings.ReplaceAll(rel, string(filepath.Separator), "/"), &diskFile{path: path, entry: info})
+	})
+}
+
+// walkCommitFiles calls the handler for each file in the tree of the given commit, in the same manner as walkFiles.
+func (g *GitBackend) walkCommitFiles(commit *object.Commit, handler func(webPath string, file wikiFile) error) error {
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	return g.walkTreeFiles(tree, "", func(name string, entry object.TreeEntry) error {
+		if strings.HasPrefix(name, ".wiki/") {
+			return nil
+		}
+		return handler(name, &blobFile{repo: g.repo, hash: entry.Hash})
 	})
 }
 
+// openFile opens the file at the given git path. In bare mode the file is read from the tree at HEAD, otherwise
+// from the working tree. If the file doesn't exist the error will satisfy os.IsNotExist.
+func (g *GitBackend) openFile(gitPath string) (io.ReadCloser, error) {
+	if !g.bare {
+		return os.Open(filepath.Join(g.dir, filepath.FromSlash(gitPath)))
+	}
+
+	file, err := g.headFile(gitPath)
+	if err != nil {
+		return nil, err
+	}
+	return file.Reader()
+}
+
+// readFile reads the entire contents of the file at the given git path, in the same manner as openFile.
+func (g *GitBackend) readFile(gitPath string) ([]byte, error) {
+	reader, err := g.openFile(gitPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// fileExists determines whether a file exists at the given git path, in the same manner as openFile.
+func (g *GitBackend) fileExists(gitPath string) bool {
+	if !g.bare {
+		fi, err := os.Stat(filepath.Join(g.dir, filepath.FromSlash(gitPath)))
+		return err == nil && !fi.IsDir()
+	}
+
+	_, err := g.headFile(gitPath)
+	return err == nil
+}
+
+// headFile returns the file at the given git path in the tree at HEAD.
+func (g *GitBackend) headFile(gitPath string) (*object.File, error) {
+	notExist := &fs.PathError{Op: "open", Path: gitPath, Err: fs.ErrNotExist}
+
+	commit, err := g.headCommit()
+	if err != nil {
+		return nil, err
+	} else if commit == nil {
+		return nil, notExist
+	}
+
+	file, err := commit.File(gitPath)
+	if err == object.ErrFileNotFound {
+		return nil, notExist
+	}
+	return file, err
+}
+
 func (g *GitBackend) resolveRevision(rv string) (*plumbing.Hash, error) {
 	if rv == "" {
 		rv = "HEAD"
@@ -74,9 +296,73 @@ func (g *GitBackend) resolveRevision(rv string) (*plumbing.Hash, error) {
 	return g.repo.ResolveRevision(plumbing.Revision(rv))
 }
 
+// resolvePublishedRevision is like resolveRevision, but only resolves to commits that are part of the wiki's
+// published history: HEAD, snapshots, and their ancestors. Revisions given by users must be resolved with this,
+// so that drafts and change requests can only be read through the handlers that check who may see them.
+func (g *GitBackend) resolvePublishedRevision(rv string) (*plumbing.Hash, error) {
+	hash, err := g.resolveRevision(rv)
+	if err != nil {
+		return nil, err
+	}
+
+	published, err := g.isPublished(*hash)
+	if err != nil {
+		return nil, err
+	} else if !published {
+		return nil, plumbing.ErrReferenceNotFound
+	}
+	return hash, nil
+}
+
+// isPublished determines whether the given commit is reachable from HEAD or from any snapshot.
+func (g *GitBackend) isPublished(hash plumbing.Hash) (bool, error) {
+	head, err := g.headCommit()
+	if err != nil {
+		return false, err
+	}
+
+	tips := []*object.Commit{head}
+	tags, err := g.repo.Tags()
+	if err != nil {
+		return false, err
+	}
+	err = tags.ForEach(func(ref *plumbing.Reference) error {
+		commit, err := g.snapshotCommit(ref)
+		if err != nil {
+			return err
+		}
+		tips = append(tips, commit)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// Each walk skips the commits that earlier ones have already checked
+	seen := make(map[plumbing.Hash]bool)
+	found := false
+	for _, tip := range tips {
+		if tip == nil {
+			continue
+		}
+
+		err := object.NewCommitPreorderIter(tip, seen, nil).ForEach(func(commit *object.Commit) error {
+			if commit.Hash == hash {
+				found = true
+				return storer.ErrStop
+			}
+			seen[commit.Hash] = true
+			return nil
+		})
+		if err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
 func (g *GitBackend) resolvePath(base, name string) (string, string, error) {
-	p := filepath.Clean(filepath.Join(base, name))
-	p = strings.ToLower(p)
+	p := filepath.Clean(filepath.Join(base, strings.ToLower(name)))
 
 	if strings.ContainsRune(p, '%') {
 		return "", "", errors.New("paths cannot contain '%'")
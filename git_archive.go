@@ -0,0 +1,175 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Archive is the set of pages and files in the wiki at a revision, ready to be written out in an archive format.
+type Archive struct {
+	backend *GitBackend
+	// Revision is the change that the archive contains the wiki as of.
+	Revision string
+	files    []*archiveFile
+}
+
+type archiveFile struct {
+	name     string
+	hash     plumbing.Hash
+	size     int64
+	modified time.Time
+}
+
+// Archive collects the pages and files in the wiki at the given revision, or HEAD if empty. Config in .wiki is left
+// out. Each file is given the time of the last change to it as its modification time.
+func (g *GitBackend) Archive(revision string) (*Archive, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	hash, err := g.resolvePublishedRevision(revision)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := g.repo.CommitObject(*hash)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &Archive{backend: g, Revision: commit.Hash.String()}
+	pending := make(map[string]*archiveFile)
+	err = g.walkTreeFiles(tree, "", func(name string, entry object.TreeEntry) error {
+		if strings.HasPrefix(name, ".wiki/") {
+			return nil
+		}
+
+		blob, err := g.repo.BlobObject(entry.Hash)
+		if err != nil {
+			return err
+		}
+
+		f := &archiveFile{name: name, hash: entry.Hash, size: blob.Size, modified: commit.Author.When}
+		archive.files = append(archive.files, f)
+		pending[name] = f
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return archive, g.findModifiedTimes(commit, pending)
+}
+
+// findModifiedTimes sets the modification time of each file to the time of the most recent change to it, by
+// following first parents back from the given commit. Changes made on other branches are dated from when they
+// were merged.
+func (g *GitBackend) findModifiedTimes(commit *object.Commit, pending map[string]*archiveFile) error {
+	for commit != nil && len(pending) > 0 {
+		changes, err := commitDiff(commit)
+		if err != nil {
+			return err
+		}
+
+		for i := range changes {
+			if f, ok := pending[changes[i].To.Name]; ok {
+				f.modified = commit.Author.When
+				delete(pending, changes[i].To.Name)
+			}
+		}
+
+		if commit.NumParents() == 0 {
+			break
+		}
+		if commit, err = commit.Parent(0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openBlob opens the content of the blob with the given hash.
+func (g *GitBackend) openBlob(hash plumbing.Hash) (io.ReadCloser, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	blob, err := g.repo.BlobObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	return blob.Reader()
+}
+
+// ShortRevision returns an abbreviated form of the archive's revision, for use in file names.
+func (a *Archive) ShortRevision() string {
+	return a.Revision[:8]
+}
+
+// WriteZip writes the archive to w as a zip file.
+func (a *Archive) WriteZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	for _, f := range a.files {
+		out, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     f.name,
+			Method:   zip.Deflate,
+			Modified: f.modified,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := a.copyFile(out, f); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// WriteTarGz writes the archive to w as a gzip compressed tar file.
+func (a *Archive) WriteTarGz(w io.Writer) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, f := range a.files {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     f.name,
+			Size:     f.size,
+			Mode:     0644,
+			ModTime:  f.modified,
+			Format:   tar.FormatPAX,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := a.copyFile(tw, f); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func (a *Archive) copyFile(w io.Writer, f *archiveFile) error {
+	reader, err := a.backend.openBlob(f.hash)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = io.Copy(w, reader)
+	return err
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGitBackend_Archive(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("first", "", []byte("first"), "user", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutConfig("secret", []byte("secret"), "user", "config"); err != nil {
+		t.Fatalf("PutConfig() error = %v", err)
+	}
+	// Commit times only have second precision, so make sure the changes are distinguishable
+	time.Sleep(time.Second)
+	if err := backend.PutFile("dir/file.txt", io.NopCloser(strings.NewReader("file")), "user", "upload"); err != nil {
+		t.Fatalf("PutFile() error = %v", err)
+	}
+	if err := backend.CreateSnapshot("v1", "", "user", ""); err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+	if err := backend.PutPage("later", "", []byte("later"), "user", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	first, err := backend.GetPage("first")
+	if err != nil {
+		t.Fatalf("GetPage() error = %v", err)
+	}
+
+	archive, err := backend.Archive("v1")
+	if err != nil {
+		t.Fatalf("Archive() error = %v", err)
+	}
+
+	b := &bytes.Buffer{}
+	if err := archive.WriteZip(b); err != nil {
+		t.Fatalf("WriteZip() error = %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatalf("Unable to read zip: %v", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		if f.Name == "first.md" && !f.Modified.Equal(first.LastModified.Time) {
+			t.Errorf("WriteZip() first.md modified = %v, want %v", f.Modified, first.LastModified.Time)
+		}
+	}
+	if strings.Join(names, ",") != "dir/file.txt,first.md" {
+		t.Errorf("WriteZip() files = %v, want dir/file.txt and first.md", names)
+	}
+
+	b.Reset()
+	if err := archive.WriteTarGz(b); err != nil {
+		t.Fatalf("WriteTarGz() error = %v", err)
+	}
+	gr, err := gzip.NewReader(b)
+	if err != nil {
+		t.Fatalf("Unable to read gzip: %v", err)
+	}
+	tr := tar.NewReader(gr)
+	contents := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Unable to read tar: %v", err)
+		}
+		content, _ := io.ReadAll(tr)
+		contents[header.Name] = string(content)
+		if header.Name == "first.md" && !header.ModTime.Equal(first.LastModified.Time) {
+			t.Errorf("WriteTarGz() first.md modified = %v, want %v", header.ModTime, first.LastModified.Time)
+		}
+	}
+	if len(contents) != 2 || contents["first.md"] != "first" || contents["dir/file.txt"] != "file" {
+		t.Errorf("WriteTarGz() contents = %v", contents)
+	}
+}
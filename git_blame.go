@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// BlameLine is a single line of a page, along with the change that last modified it.
+type BlameLine struct {
+	Number int
+	Text   string
+	Change *LogEntry
+	// Start indicates that the previous line was last modified by a different change.
+	Start bool
+}
+
+// BlamePage attributes each line of a page to the change that last modified it, following the page across renames.
+func (g *GitBackend) BlamePage(title string) ([]*BlameLine, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	_, gitPath, err := g.resolvePath(g.dir, fmt.Sprintf("%s.md", title))
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := g.resolveRevision("")
+	if err != nil {
+		return nil, err
+	}
+
+	type revision struct {
+		commit  *object.Commit
+		content string
+		exists  bool
+	}
+
+	var revisions []revision
+	err = g.followPath(*head, gitPath, func(commit *object.Commit, path, _ string, changed bool) error {
+		if !changed {
+			return nil
+		}
+
+		file, err := commit.File(path)
+		if err != nil {
+			// The page was deleted by this commit, so nothing before it survives
+			revisions = append(revisions, revision{commit: commit})
+			return nil
+		}
+
+		content, err := file.Contents()
+		if err != nil {
+			return err
+		}
+		revisions = append(revisions, revision{commit: commit, content: content, exists: true})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(revisions) == 0 || !revisions[0].exists {
+		return nil, fmt.Errorf("page %s does not exist", title)
+	}
+
+	// Replay the revisions from oldest to newest, carrying attributions over for lines that didn't change
+	dmp := diffmatchpatch.New()
+	var previous string
+	var changes []*LogEntry
+	for i := len(revisions) - 1; i >= 0; i-- {
+		r := revisions[i]
+		if !r.exists {
+			previous, changes = "", nil
+			continue
+		}
+
+		entry := &LogEntry{
+			ChangeId: r.commit.Hash.String(),
+			User:     r.commit.Author.Name,
+			Time:     r.commit.Author.When,
+			Message:  r.commit.Message,
+		}
+
+		a, b, _ := dmp.DiffLinesToRunes(previous, r.content)
+		var next []*LogEntry
+		var old int
+		for _, diff := range dmp.DiffMainRunes(a, b, false) {
+			count := len([]rune(diff.Text))
+			switch diff.Type {
+			case diffmatchpatch.DiffEqual:
+				next = append(next, changes[old:old+count]...)
+				old += count
+			case diffmatchpatch.DiffDelete:
+				old += count
+			case diffmatchpatch.DiffInsert:
+				for j := 0; j < count; j++ {
+					next = append(next, entry)
+				}
+			}
+		}
+
+		previous, changes = r.content, next
+	}
+
+	var result []*BlameLine
+	for i, text := range splitLines(previous) {
+		result = append(result, &BlameLine{
+			Number: i + 1,
+			Text:   strings.TrimRight(text, "\r\n"),
+			Change: changes[i],
+			Start:  i == 0 || changes[i] != changes[i-1],
+		})
+	}
+	return result, nil
+}
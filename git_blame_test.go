@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestGitBackend_BlamePage(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("old", "", []byte("one\ntwo\nthree\n"), "alice", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutPage("old", "", []byte("one\n2\nthree\nfour\n"), "bob", "update"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.RenamePage("old", "new", "rename", "carol", false); err != nil {
+		t.Fatalf("RenamePage() error = %v", err)
+	}
+	if err := backend.PutPage("new", "", []byte("zero\none\n2\nthree\nfour\n"), "dave", "prepend"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	lines, err := backend.BlamePage("new")
+	if err != nil {
+		t.Fatalf("BlamePage() error = %v", err)
+	}
+
+	want := []struct {
+		text string
+		user string
+	}{
+		{"zero", "dave"},
+		{"one", "alice"},
+		{"2", "bob"},
+		{"three", "alice"},
+		{"four", "bob"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("BlamePage() returned %d lines, want %d", len(lines), len(want))
+	}
+	for i := range want {
+		if lines[i].Number != i+1 || lines[i].Text != want[i].text || lines[i].Change.User != want[i].user {
+			t.Errorf("BlamePage() line %d = %d %q by %s, want %q by %s", i, lines[i].Number, lines[i].Text, lines[i].Change.User, want[i].text, want[i].user)
+		}
+	}
+	if !lines[2].Start || lines[4].Change != lines[2].Change {
+		t.Errorf("BlamePage() didn't share changes between lines")
+	}
+
+	if _, err := backend.BlamePage("missing"); err == nil {
+		t.Errorf("BlamePage() of missing page succeeded")
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Changeset collects changes to several pages and files so that they can be committed together. Nothing is
+// visible in the wiki until Commit is called, and if any of the changes can't be applied then none of them are.
+type Changeset struct {
+	backend *GitBackend
+	changes []stagedChange
+}
+
+// stagedChange is a single change within a Changeset. If from is set, the file at that path is moved to path;
+// otherwise path is set to the given blob, or deleted if the blob is the zero hash.
+type stagedChange struct {
+	path     string
+	from     string
+	blob     plumbing.Hash
+	revision string
+}
+
+// NewChangeset starts a new, empty, set of changes.
+func (g *GitBackend) NewChangeset() *Changeset {
+	return &Changeset{backend: g}
+}
+
+// PutPage stages new content for a page. If baseRevision is given, any changes made to the page since that
+// revision are merged in when the changeset is committed, as for GitBackend.PutPage.
+func (c *Changeset) PutPage(title string, baseRevision string, content []byte) error {
+	return c.put(fmt.Sprintf("%s.md", title), baseRevision, bytes.NewReader(content))
+}
+
+// PutFile stages new content for a file.
+func (c *Changeset) PutFile(name string, content io.Reader) error {
+	return c.put(name, "", content)
+}
+
+// DeletePage stages the deletion of a page.
+func (c *Changeset) DeletePage(title string) error {
+	return c.delete(fmt.Sprintf("%s.md", title))
+}
+
+// DeleteFile stages the deletion of a file.
+func (c *Changeset) DeleteFile(name string) error {
+	return c.delete(name)
+}
+
+// RenamePage stages moving a page to a new title.
+func (c *Changeset) RenamePage(title string, newTitle string) error {
+	return c.move(fmt.Sprintf("%s.md", title), fmt.Sprintf("%s.md", newTitle))
+}
+
+// RenameFile stages moving a file to a new name.
+func (c *Changeset) RenameFile(name string, newName string) error {
+	return c.move(name, newName)
+}
+
+// Len returns the number of changes that have been staged.
+func (c *Changeset) Len() int {
+	return len(c.changes)
+}
+
+func (c *Changeset) put(name, baseRevision string, content io.Reader) error {
+	g := c.backend
+	_, gitPath, err := g.resolvePath(g.dir, name)
+	if err != nil {
+		return err
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	hash, err := g.writeBlob(content)
+	if err != nil {
+		return err
+	}
+
+	c.changes = append(c.changes, stagedChange{path: gitPath, blob: hash, revision: baseRevision})
+	return nil
+}
+
+func (c *Changeset) delete(name string) error {
+	g := c.backend
+	_, gitPath, err := g.resolvePath(g.dir, name)
+	if err != nil {
+		return err
+	}
+
+	c.changes = append(c.changes, stagedChange{path: gitPath})
+	return nil
+}
+
+func (c *Changeset) move(name, newName string) error {
+	g := c.backend
+	_, gitPath, err := g.resolvePath(g.dir, name)
+	if err != nil {
+		return err
+	}
+
+	_, newGitPath, err := g.resolvePath(g.dir, newName)
+	if err != nil {
+		return err
+	}
+
+	c.changes = append(c.changes, stagedChange{path: newGitPath, from: gitPath})
+	return nil
+}
+
+// Commit applies all the staged changes, in order, in a single commit. Each change sees the results of those
+// staged before it, so a file can be uploaded and then renamed, for example. If any change fails (because a
+// file to be moved or deleted doesn't exist, a file would be moved on top of another, or an edit conflicts with
+// another user's changes) an error is returned and the wiki is left untouched.
+func (c *Changeset) Commit(user string, message string) error {
+	g := c.backend
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	changes, err := c.apply()
+	if err != nil || len(changes) == 0 {
+		return err
+	}
+
+	return g.commitChanges(changes, user, message)
+}
+
+// Propose submits all the staged changes as a change request, instead of committing them, and returns its ID. The
+// changes are checked and merged in the same way as for Commit.
+func (c *Changeset) Propose(user string, message string) (int, error) {
+	g := c.backend
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	changes, err := c.apply()
+	if err != nil {
+		return 0, err
+	}
+
+	parent, err := g.headCommit()
+	if err != nil {
+		return 0, err
+	}
+
+	return g.proposeChanges(parent, changes, user, message)
+}
+
+// apply works out the blobs that the staged changes result in, relative to HEAD.
+func (c *Changeset) apply() (map[string]plumbing.Hash, error) {
+	g := c.backend
+	changes := make(map[string]plumbing.Hash)
+	// Edits are merged with the page as it was at HEAD, so note where each moved file came from
+	origins := make(map[string]string)
+	origin := func(gitPath string) string {
+		if from, ok := origins[gitPath]; ok {
+			return from
+		}
+		return gitPath
+	}
+
+	for _, change := range c.changes {
+		switch {
+		case change.from != "":
+			hash, err := g.stagedFile(changes, change.from)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := g.stagedFile(changes, change.path); err == nil {
+				return nil, &fs.PathError{Op: "rename", Path: change.path, Err: fs.ErrExist}
+			}
+			origins[change.path] = origin(change.from)
+			delete(origins, change.from)
+			changes[change.from] = plumbing.ZeroHash
+			changes[change.path] = hash
+		case change.blob.IsZero():
+			if _, err := g.stagedFile(changes, change.path); err != nil {
+				return nil, err
+			}
+			delete(origins, change.path)
+			changes[change.path] = plumbing.ZeroHash
+		case change.revision != "":
+			content, err := g.readBlob(change.blob)
+			if err != nil {
+				return nil, err
+			}
+
+			merged, err := g.mergeStagedEdit(changes, change.path, origin(change.path), change.revision, content)
+			if err != nil {
+				return nil, err
+			}
+
+			hash, err := g.writeBlob(bytes.NewReader(merged))
+			if err != nil {
+				return nil, err
+			}
+			changes[change.path] = hash
+		default:
+			changes[change.path] = change.blob
+		}
+	}
+
+	return changes, nil
+}
+
+// stagedFile returns the blob for a file, taking into account any changes that have been made on top of HEAD.
+func (g *GitBackend) stagedFile(changes map[string]plumbing.Hash, gitPath string) (plumbing.Hash, error) {
+	if hash, ok := changes[gitPath]; ok {
+		if hash.IsZero() {
+			return plumbing.ZeroHash, &fs.PathError{Op: "open", Path: gitPath, Err: fs.ErrNotExist}
+		}
+		return hash, nil
+	}
+
+	file, err := g.headFile(gitPath)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return file.Hash, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestChangeset_CommitsAllChangesTogether(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("old", "", []byte("old page"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	before, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+
+	changeset := backend.NewChangeset()
+	_ = changeset.PutPage("page", "", []byte("![[image.png]]"))
+	_ = changeset.PutFile("upload.png", strings.NewReader("image"))
+	_ = changeset.RenameFile("upload.png", "image.png")
+	_ = changeset.DeletePage("old")
+	if err := changeset.Commit("user", "batch"); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	head, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+
+	if len(head.ParentHashes) != 1 || head.ParentHashes[0] != before.Hash {
+		t.Errorf("Commit() didn't create a single commit on top of HEAD")
+	}
+
+	files, err := backend.ListFiles()
+	if err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+
+	var names []string
+	for i := range files {
+		names = append(names, files[i].Name)
+	}
+	if got := strings.Join(names, ","); got != "image.png" {
+		t.Errorf("ListFiles() = %s, want image.png", got)
+	}
+
+	pages, err := backend.ListPages()
+	if err != nil {
+		t.Fatalf("ListPages() error = %v", err)
+	}
+
+	if got := strings.Join(pages, ","); got != "page" {
+		t.Errorf("ListPages() = %s, want page", got)
+	}
+}
+
+func TestChangeset_FailedCommitChangesNothing(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("page", "", []byte("content"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	before, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+
+	changeset := backend.NewChangeset()
+	_ = changeset.PutPage("page", "", []byte("new content"))
+	_ = changeset.DeleteFile("missing.png")
+	if err := changeset.Commit("user", "batch"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Commit() error = %v, want %v", err, fs.ErrNotExist)
+	}
+
+	after, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+
+	if after.Hash != before.Hash {
+		t.Errorf("Commit() moved HEAD despite failing")
+	}
+
+	page, err := backend.GetPage("page")
+	if err != nil {
+		t.Fatalf("GetPage() error = %v", err)
+	}
+
+	if string(page.Content) != "content" {
+		t.Errorf("GetPage() content = %s, want content", page.Content)
+	}
+}
+
+func TestChangeset_RenameOverExisting(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutFile("one.png", io.NopCloser(strings.NewReader("one")), "user", "message"); err != nil {
+		t.Fatalf("PutFile() error = %v", err)
+	}
+
+	changeset := backend.NewChangeset()
+	_ = changeset.PutFile("two.png", strings.NewReader("two"))
+	_ = changeset.RenameFile("two.png", "one.png")
+	if err := changeset.Commit("user", "batch"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Commit() renaming over an existing file error = %v, want ErrExist", err)
+	}
+
+	changeset = backend.NewChangeset()
+	_ = changeset.RenameFile("one.png", "one.png")
+	if err := changeset.Commit("user", "batch"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Commit() renaming a file to itself error = %v, want ErrExist", err)
+	}
+
+	file, err := backend.GetFile("one.png")
+	if err != nil {
+		t.Fatalf("GetFile() error = %v", err)
+	}
+	defer file.Close()
+	if content, _ := io.ReadAll(file); string(content) != "one" {
+		t.Errorf("GetFile() = %q, want one", content)
+	}
+}
+
+func TestChangeset_MergesEditsWithStagedChanges(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("page", "", []byte("one\ntwo\nthree\nfour\nfive\n"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	base, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+
+	if err := backend.PutPage("page", "", []byte("one\ntwo\nthree\nfour\nfive changed\n"), "other", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	changeset := backend.NewChangeset()
+	_ = changeset.RenamePage("page", "moved")
+	_ = changeset.PutPage("moved", base.Hash.String(), []byte("one changed\ntwo\nthree\nfour\nfive\n"))
+	_ = changeset.PutPage("moved", base.Hash.String(), []byte("one\ntwo\nthree changed\nfour\nfive\n"))
+	if err := changeset.Commit("user", "batch"); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	page, err := backend.GetPage("moved")
+	if err != nil {
+		t.Fatalf("GetPage() error = %v", err)
+	}
+	if want := "one changed\ntwo\nthree changed\nfour\nfive changed\n"; string(page.Content) != want {
+		t.Errorf("GetPage() content = %q, want %q", page.Content, want)
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// draftBranchPrefix is the prefix of the branches that drafts are stored on, within refs/heads.
+const draftBranchPrefix = "drafts/"
+
+var (
+	draftNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	errNoSuchDraft   = &fs.PathError{Op: "open", Path: "draft", Err: fs.ErrNotExist}
+
+	// ErrInvalidDraftName is returned if a draft name contains anything other than lower case letters, numbers,
+	// hyphens and underscores.
+	ErrInvalidDraftName = errors.New("invalid draft name")
+)
+
+// Draft describes a set of unpublished changes to pages, stored on their own branch.
+type Draft struct {
+	Name string
+	// Owner is the user who started the draft, who along with admins may publish or discard it.
+	Owner string
+	// Pages are the titles of the pages that differ from the published version the draft was started from.
+	Pages        []string
+	LastModified *LogEntry
+}
+
+// draftBranch returns the name of the branch used for the given draft, if the name is valid.
+func draftBranch(name string) (plumbing.ReferenceName, error) {
+	if !draftNamePattern.MatchString(name) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidDraftName, name)
+	}
+	return plumbing.NewBranchReferenceName(draftBranchPrefix + name), nil
+}
+
+// isDraftBranch determines whether the given reference is a draft branch.
+func isDraftBranch(ref plumbing.ReferenceName) bool {
+	return ref.IsBranch() && strings.HasPrefix(ref.Short(), draftBranchPrefix)
+}
+
+// draftCommit returns the latest commit on the given draft branch, or nil if the draft doesn't exist.
+func (g *GitBackend) draftCommit(branch plumbing.ReferenceName) (*object.Commit, error) {
+	ref, err := g.repo.Storer.Reference(branch)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return g.repo.CommitObject(ref.Hash())
+}
+
+// ListDrafts returns details of all the drafts that haven't yet been published or discarded.
+func (g *GitBackend) ListDrafts() ([]*Draft, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	head, err := g.headCommit()
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := g.repo.Storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	var drafts []*Draft
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference || !isDraftBranch(ref.Name()) {
+			return nil
+		}
+
+		commit, err := g.repo.CommitObject(ref.Hash())
+		if err != nil {
+			return err
+		}
+
+		base, err := g.draftBase(head, commit)
+		if err != nil {
+			return err
+		}
+
+		pages, err := changedPages(base, commit)
+		if err != nil {
+			return err
+		}
+
+		owner, err := draftOwner(base, commit)
+		if err != nil {
+			return err
+		}
+
+		drafts = append(drafts, &Draft{
+			Name:  strings.TrimPrefix(ref.Name().Short(), draftBranchPrefix),
+			Owner: owner,
+			Pages: pages,
+			LastModified: &LogEntry{
+				ChangeId: commit.Hash.String(),
+				User:     commit.Author.Name,
+				Time:     commit.Author.When,
+				Message:  commit.Message,
+			},
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(drafts, func(i, j int) bool {
+		return drafts[i].Name < drafts[j].Name
+	})
+	return drafts, nil
+}
+
+// draftBase returns the published commit that a draft diverged from, or nil if nothing had been published.
+func (g *GitBackend) draftBase(head, draft *object.Commit) (*object.Commit, error) {
+	if head == nil {
+		return nil, nil
+	}
+
+	bases, err := head.MergeBase(draft)
+	if err != nil || len(bases) == 0 {
+		return nil, err
+	}
+	return bases[0], nil
+}
+
+// draftOwner returns the author of the first commit made in a draft after it diverged from base, or an empty
+// string if nothing has been saved to it.
+func draftOwner(base, draft *object.Commit) (string, error) {
+	var owner string
+	for commit := draft; base == nil || commit.Hash != base.Hash; {
+		owner = commit.Author.Name
+		if commit.NumParents() == 0 {
+			break
+		}
+
+		var err error
+		if commit, err = commit.Parent(0); err != nil {
+			return "", err
+		}
+	}
+	return owner, nil
+}
+
+// DraftInfo returns the user who started a draft, and the published revision that it was started from.
+func (g *GitBackend) DraftInfo(draft string) (owner, revision string, err error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	branch, err := draftBranch(draft)
+	if err != nil {
+		return "", "", err
+	}
+
+	tip, err := g.draftCommit(branch)
+	if err != nil {
+		return "", "", err
+	} else if tip == nil {
+		return "", "", errNoSuchDraft
+	}
+
+	head, err := g.headCommit()
+	if err != nil {
+		return "", "", err
+	}
+
+	base, err := g.draftBase(head, tip)
+	if err != nil {
+		return "", "", err
+	}
+
+	if owner, err = draftOwner(base, tip); err != nil {
+		return "", "", err
+	}
+
+	if base != nil {
+		revision = base.Hash.String()
+	}
+	return owner, revision, nil
+}
+
+// changedPages returns the titles of the pages that differ between two commits.
+func changedPages(from, to *object.Commit) ([]string, error) {
+	trees, err := commitTrees(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := object.DiffTree(trees[0], trees[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []string
+	for i := range changes {
+		name := changes[i].To.Name
+		if name == "" {
+			name = changes[i].From.Name
+		}
+		if path.Ext(name) == ".md" {
+			pages = append(pages, strings.TrimSuffix(name, ".md"))
+		}
+	}
+	sort.Strings(pages)
+	return pages, nil
+}
+
+// GetDraftPage returns the content of a page as it is in the given draft.
+func (g *GitBackend) GetDraftPage(draft, title string) (*Page, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	branch, err := draftBranch(draft)
+	if err != nil {
+		return nil, err
+	}
+
+	_, gitPath, err := g.resolvePath(g.dir, fmt.Sprintf("%s.md", title))
+	if err != nil {
+		return nil, err
+	}
+
+	tip, err := g.draftCommit(branch)
+	if err != nil {
+		return nil, err
+	} else if tip == nil {
+		return nil, errNoSuchDraft
+	}
+
+	file, err := tip.File(gitPath)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+
+	commitIter, err := g.repo.Log(&git.LogOptions{
+		From: tip.Hash,
+		PathFilter: func(s string) bool {
+			return s == gitPath
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := commitIter.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Page{
+		Content: []byte(content),
+		LastModified: &LogEntry{
+			ChangeId: commit.Hash.String(),
+			User:     commit.Author.Name,
+			Time:     commit.Author.When,
+			Message:  commit.Message,
+		},
+	}, nil
+}
+
+// PutDraftPage saves the content of a page to a draft, creating the draft from the currently published version
+// of the wiki if it doesn't already exist.
+func (g *GitBackend) PutDraftPage(draft, title string, content []byte, user, message string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	branch, err := draftBranch(draft)
+	if err != nil {
+		return err
+	}
+
+	_, gitPath, err := g.resolvePath(g.dir, fmt.Sprintf("%s.md", title))
+	if err != nil {
+		return err
+	}
+
+	parent, err := g.draftCommit(branch)
+	if err != nil {
+		return err
+	}
+
+	if parent == nil {
+		if parent, err = g.headCommit(); err != nil {
+			return err
+		}
+	}
+
+	hash, err := g.writeBlob(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+
+	commit, err := g.buildCommit(parent, map[string]plumbing.Hash{gitPath: hash}, user, message)
+	if err != nil {
+		return err
+	}
+
+	if commit == nil {
+		// The content hasn't changed, but make sure the draft exists
+		commit = parent
+	}
+
+	return g.repo.Storer.SetReference(plumbing.NewHashReference(branch, commit.Hash))
+}
+
+// PublishDraft merges the changes in a draft into the published version of the wiki, and removes the draft. If
+// any of the pages in the draft have been changed differently since it was started, a MergeConflictError is
+// returned and nothing is published.
+func (g *GitBackend) PublishDraft(draft, user, message string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	branch, err := draftBranch(draft)
+	if err != nil {
+		return err
+	}
+
+	tip, err := g.draftCommit(branch)
+	if err != nil {
+		return err
+	} else if tip == nil {
+		return errNoSuchDraft
+	}
+
+	head, err := g.headCommit()
+	if err != nil {
+		return err
+	}
+
+	if message == "" {
+		message = fmt.Sprintf("Publishing draft %s", draft)
+	}
+
+	if head == nil {
+		if err := g.moveHead(nil, tip); err != nil {
+			return err
+		}
+	} else if published, err := tip.IsAncestor(head); err != nil {
+		return err
+	} else if !published {
+		conflicts, err := g.mergeIntoHead(head, tip, conflictFail, user, user, message)
+		if err != nil {
+			return err
+		}
+
+		if len(conflicts) > 0 {
+			return &MergeConflictError{Conflicts: conflicts}
+		}
+	}
+
+	if err := g.repo.Storer.RemoveReference(branch); err != nil {
+		return err
+	}
+
+	g.publish()
+	return nil
+}
+
+// DeleteDraft discards all the changes in a draft.
+func (g *GitBackend) DeleteDraft(draft string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	branch, err := draftBranch(draft)
+	if err != nil {
+		return err
+	}
+
+	if _, err := g.repo.Storer.Reference(branch); err == plumbing.ErrReferenceNotFound {
+		return errNoSuchDraft
+	} else if err != nil {
+		return err
+	}
+
+	return g.repo.Storer.RemoveReference(branch)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"reflect"
+	"testing"
+
+	"github.com/mdbot/wiki/config"
+)
+
+func TestGitBackend_Drafts(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("page", "", []byte("published"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	if err := backend.PutDraftPage("user", "page", []byte("draft"), "user", "draft message"); err != nil {
+		t.Fatalf("PutDraftPage() error = %v", err)
+	}
+	if err := backend.PutDraftPage("user", "new", []byte("new page"), "other", "draft message"); err != nil {
+		t.Fatalf("PutDraftPage() error = %v", err)
+	}
+
+	if page, err := backend.GetPage("page"); err != nil || string(page.Content) != "published" {
+		t.Errorf("GetPage() = %v, %v, want published content", page, err)
+	}
+	if backend.PageExists("new") {
+		t.Errorf("PageExists() = true for a page only in a draft")
+	}
+
+	page, err := backend.GetDraftPage("user", "page")
+	if err != nil {
+		t.Fatalf("GetDraftPage() error = %v", err)
+	}
+	if string(page.Content) != "draft" {
+		t.Errorf("GetDraftPage() content = %s, want draft", page.Content)
+	}
+
+	drafts, err := backend.ListDrafts()
+	if err != nil {
+		t.Fatalf("ListDrafts() error = %v", err)
+	}
+	if len(drafts) != 1 || drafts[0].Name != "user" || !reflect.DeepEqual(drafts[0].Pages, []string{"new", "page"}) {
+		t.Errorf("ListDrafts() = %v, want one draft changing new and page", drafts)
+	}
+	if drafts[0].Owner != "user" {
+		t.Errorf("ListDrafts() owner = %s, want the user who started the draft", drafts[0].Owner)
+	}
+
+	for _, revision := range []string{"drafts/user", "refs/heads/drafts/user", drafts[0].LastModified.ChangeId} {
+		if page, err := backend.GetPageAt("page", revision); err == nil {
+			t.Errorf("GetPageAt(%s) = %s, want an error for an unpublished draft", revision, page.Content)
+		}
+		if _, err := backend.Archive(revision); err == nil {
+			t.Errorf("Archive(%s) succeeded, want an error for an unpublished draft", revision)
+		}
+	}
+
+	if err := backend.PublishDraft("user", "user", ""); err != nil {
+		t.Fatalf("PublishDraft() error = %v", err)
+	}
+
+	if page, err := backend.GetPage("page"); err != nil || string(page.Content) != "draft" {
+		t.Errorf("GetPage() after publishing = %v, %v, want draft content", page, err)
+	}
+	if !backend.PageExists("new") {
+		t.Errorf("PageExists() = false for a published page")
+	}
+
+	if _, err := backend.GetDraftPage("user", "page"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("GetDraftPage() after publishing error = %v, want ErrNotExist", err)
+	}
+}
+
+func TestGitBackend_DraftInfo(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("page", "", []byte("one\ntwo\nthree\n"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	published, err := backend.GetPage("page")
+	if err != nil {
+		t.Fatalf("GetPage() error = %v", err)
+	}
+
+	if err := backend.PutDraftPage("draft", "page", []byte("ONE\ntwo\nthree\n"), "author", "draft message"); err != nil {
+		t.Fatalf("PutDraftPage() error = %v", err)
+	}
+	if err := backend.PutPage("page", "", []byte("one\ntwo\nTHREE\n"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	owner, revision, err := backend.DraftInfo("draft")
+	if err != nil {
+		t.Fatalf("DraftInfo() error = %v", err)
+	}
+	if owner != "author" || revision != published.LastModified.ChangeId {
+		t.Errorf("DraftInfo() = %s, %s, want author, %s", owner, revision, published.LastModified.ChangeId)
+	}
+
+	// Publishing an edit of the draft directly merges in what's been published since the draft was started
+	if err := backend.PutPage("page", revision, []byte("ONE\ntwo\nthree\n"), "author", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if page, err := backend.GetPage("page"); err != nil || string(page.Content) != "ONE\ntwo\nTHREE\n" {
+		t.Errorf("GetPage() = %v, %v, want both changes", page, err)
+	}
+
+	if _, _, err := backend.DraftInfo("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("DraftInfo() of missing draft error = %v, want ErrNotExist", err)
+	}
+}
+
+func TestGitBackend_PublishDraftWithConflicts(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("page", "", []byte("original"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutDraftPage("draft", "page", []byte("draft"), "user", "message"); err != nil {
+		t.Fatalf("PutDraftPage() error = %v", err)
+	}
+	if err := backend.PutPage("page", "", []byte("changed"), "other", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	var conflict *MergeConflictError
+	if err := backend.PublishDraft("draft", "user", ""); !errors.As(err, &conflict) {
+		t.Fatalf("PublishDraft() error = %v, want MergeConflictError", err)
+	}
+	if !reflect.DeepEqual(conflict.Conflicts, []string{"page.md"}) {
+		t.Errorf("PublishDraft() conflicts = %v, want page.md", conflict.Conflicts)
+	}
+
+	if page, err := backend.GetPage("page"); err != nil || string(page.Content) != "changed" {
+		t.Errorf("GetPage() after failed publish = %v, %v, want changed content", page, err)
+	}
+	if _, err := backend.GetDraftPage("draft", "page"); err != nil {
+		t.Errorf("GetDraftPage() after failed publish error = %v, want the draft to remain", err)
+	}
+
+	if err := backend.PutDraftPage("invalid name", "page", []byte("x"), "user", "message"); !errors.Is(err, ErrInvalidDraftName) {
+		t.Errorf("PutDraftPage() error = %v, want ErrInvalidDraftName", err)
+	}
+}
+
+type testUsers []*config.User
+
+func (u testUsers) Users() []*config.User {
+	return u
+}
+
+func Test_canWriteDraft(t *testing.T) {
+	backend := newTestBackend(t)
+
+	alice := &config.User{Name: "Alice", Permissions: config.PermissionWrite}
+	bob := &config.User{Name: "Bob", Permissions: config.PermissionWrite}
+	admin := &config.User{Name: "Admin", Permissions: config.PermissionAdmin}
+	users := testUsers{alice, bob, admin}
+
+	if err := backend.PutDraftPage("shared", "page", []byte("draft"), "Alice", "message"); err != nil {
+		t.Fatalf("PutDraftPage() error = %v", err)
+	}
+
+	tests := []struct {
+		user  *config.User
+		draft string
+		want  bool
+	}{
+		{alice, "shared", true},
+		{bob, "shared", false},
+		{admin, "shared", true},
+		{bob, "bob", true},
+		{bob, "alice", false},
+		{bob, "new", true},
+		{nil, "new", true},
+		{nil, "bob", false},
+	}
+	for _, tt := range tests {
+		username := "Anonymoose"
+		if tt.user != nil {
+			username = tt.user.Name
+		}
+		if got, err := canWriteDraft(backend, users, tt.user, username, tt.draft); err != nil || got != tt.want {
+			t.Errorf("canWriteDraft(%s, %s) = %v, %v, want %v", username, tt.draft, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	lockFileName       = "wiki.lock"
+	quarantineDirName  = "quarantine"
+	externalChangeUser = "system"
+)
+
+func lockFilePath(dir string) string {
+	return filepath.Join(dir, lockFileName)
+}
+
+// Close stops any background work, waiting for anything in progress to finish, and releases the lock on the data
+// directory.
+func (g *GitBackend) Close() error {
+	g.mutex.Lock()
+	select {
+	case <-g.closed:
+		g.mutex.Unlock()
+		return nil
+	default:
+		close(g.closed)
+	}
+	g.mutex.Unlock()
+
+	// Background work may need the lock to finish what it's doing
+	g.background.Wait()
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	err := unlockDirectory(g.lock)
+	g.lock = nil
+	return err
+}
+
+// absorbWorktreeChanges deals with any changes that have been made to the working tree without being committed,
+// for example by copying files into the data directory. Changes to files that the wiki could have written itself
+// are committed; anything else is moved into a quarantine directory inside the git directory, and the file is
+// restored to its committed state.
+func (g *GitBackend) absorbWorktreeChanges() error {
+	if g.bare {
+		return nil
+	}
+
+	worktree, err := g.repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return err
+	}
+
+	if status.IsClean() {
+		return nil
+	}
+
+	head, err := g.headCommit()
+	if err != nil {
+		return err
+	}
+
+	trees, err := commitTrees(head)
+	if err != nil {
+		return err
+	}
+
+	idx, err := g.repo.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	changes := make(map[string]plumbing.Hash)
+	quarantine := filepath.Join(g.gitDir, quarantineDirName, time.Now().Format("20060102-150405"))
+	for name, fileStatus := range status {
+		if fileStatus.Worktree == git.Unmodified && fileStatus.Staging == git.Unmodified {
+			continue
+		}
+
+		filePath := filepath.Join(g.dir, filepath.FromSlash(name))
+		info, err := os.Lstat(filePath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if _, gitPath, err := g.resolvePath(g.dir, name); err == nil && gitPath == name && (info == nil || info.Mode().IsRegular()) {
+			hash := plumbing.ZeroHash
+			if info != nil {
+				if hash, err = g.writeFileBlob(filePath); err != nil {
+					return err
+				}
+			}
+
+			changes[name] = hash
+			continue
+		}
+
+		if info != nil {
+			target := filepath.Join(quarantine, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(target), os.FileMode(0755)); err != nil {
+				return err
+			}
+
+			if err := os.Rename(filePath, target); err != nil {
+				return err
+			}
+			log.Printf("Moved %s, changed outside of the wiki, to %s", name, target)
+		}
+
+		if err := g.checkoutPath(idx, name, treeEntry(trees[0], name)); err != nil {
+			return err
+		}
+	}
+
+	if err := g.repo.Storer.SetIndex(idx); err != nil {
+		return err
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	log.Printf("Committing %d file(s) changed outside of the wiki", len(changes))
+	return g.commitChanges(changes, externalChangeUser, "Adding changes made outside of the wiki")
+}
+
+// writeFileBlob stores the contents of a file on disk as a blob.
+func (g *GitBackend) writeFileBlob(filePath string) (plumbing.Hash, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	defer f.Close()
+
+	return g.writeBlob(f)
+}
+
+// treeEntry returns the entry for the file at the given path in a tree, or nil if it doesn't exist.
+func treeEntry(tree *object.Tree, name string) *object.TreeEntry {
+	if tree == nil {
+		return nil
+	}
+
+	entry, err := tree.FindEntry(name)
+	if err != nil {
+		return nil
+	}
+	return entry
+}
+
+// watchHead periodically checks whether HEAD has been moved by something other than the wiki, such as someone
+// committing directly in the data directory, until the backend is closed.
+func (g *GitBackend) watchHead(interval time.Duration) {
+	defer g.background.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.closed:
+			return
+		case <-ticker.C:
+			if err := g.checkHead(); err != nil {
+				log.Printf("Unable to check for changes made outside of the wiki: %v", err)
+			}
+		}
+	}
+}
+
+// checkHead absorbs any commits made outside of the wiki: the working tree is updated to match the new HEAD
+// (in case the branch was moved without checking it out), and the changes are published to the remote.
+func (g *GitBackend) checkHead() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	head, err := g.headCommit()
+	if err != nil {
+		return err
+	}
+
+	hash := plumbing.ZeroHash
+	if head != nil {
+		hash = head.Hash
+	}
+
+	if hash == g.head {
+		return nil
+	}
+
+	log.Printf("HEAD was moved from %s to %s outside of the wiki", g.head, hash)
+
+	var previous *object.Commit
+	if !g.head.IsZero() {
+		if previous, err = g.repo.CommitObject(g.head); err != nil {
+			return fmt.Errorf("unable to find previous HEAD: %w", err)
+		}
+	}
+
+	trees, err := commitTrees(previous, head)
+	if err != nil {
+		return err
+	}
+
+	if err := g.checkoutChanges(trees[0], trees[1]); err != nil {
+		return err
+	}
+
+	g.head = hash
+	g.publish()
+	return nil
+}
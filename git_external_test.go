@@ -0,0 +1,158 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestNewGitBackend_LocksDirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	backend, err := NewGitBackend(dir, GitOptions{})
+	if err != nil {
+		t.Fatalf("NewGitBackend() error = %v", err)
+	}
+
+	if _, err := NewGitBackend(dir, GitOptions{}); err == nil {
+		t.Fatalf("NewGitBackend() succeeded while directory was in use")
+	}
+
+	if err := backend.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	backend, err = NewGitBackend(dir, GitOptions{})
+	if err != nil {
+		t.Fatalf("NewGitBackend() error after Close() = %v", err)
+	}
+	_ = backend.Close()
+}
+
+func TestNewGitBackend_AbsorbsWorktreeChanges(t *testing.T) {
+	dir := t.TempDir()
+
+	backend, err := NewGitBackend(dir, GitOptions{})
+	if err != nil {
+		t.Fatalf("NewGitBackend() error = %v", err)
+	}
+
+	if err := backend.PutConfig("test", []byte("config"), "user", "message"); err != nil {
+		t.Fatalf("PutConfig() error = %v", err)
+	}
+	_ = backend.Close()
+
+	files := map[string]string{
+		"copied.md":            "copied content",
+		"Upper.md":             "invalid name",
+		".wiki/test.json.enc":  "tampered config",
+		".wiki/extra.json.enc": "extra config",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), os.FileMode(0644)); err != nil {
+			t.Fatalf("Unable to write %s: %v", name, err)
+		}
+	}
+
+	backend, err = NewGitBackend(dir, GitOptions{})
+	if err != nil {
+		t.Fatalf("NewGitBackend() error = %v", err)
+	}
+	defer backend.Close()
+
+	page, err := backend.GetPage("copied")
+	if err != nil {
+		t.Fatalf("GetPage() error = %v", err)
+	}
+
+	if string(page.Content) != "copied content" || page.LastModified.User != externalChangeUser {
+		t.Errorf("GetPage() = %s by %s, want copied content by %s", page.Content, page.LastModified.User, externalChangeUser)
+	}
+
+	config, err := backend.GetConfig("test")
+	if err != nil {
+		t.Fatalf("GetConfig() error = %v", err)
+	}
+
+	if string(config) != "config" {
+		t.Errorf("GetConfig() = %s, want config", config)
+	}
+
+	quarantined, err := filepath.Glob(filepath.Join(dir, ".git", quarantineDirName, "*", ".wiki", "*"))
+	if err != nil || len(quarantined) != 2 {
+		t.Errorf("Quarantined config files = %v, want 2 files", quarantined)
+	}
+
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatalf("PlainOpen() error = %v", err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("Worktree() error = %v", err)
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+
+	if !status.IsClean() {
+		t.Errorf("Working tree isn't clean:\n%s", status)
+	}
+}
+
+func TestGitBackend_AbsorbsHeadChanges(t *testing.T) {
+	dir := t.TempDir()
+
+	backend, err := NewGitBackend(dir, GitOptions{})
+	if err != nil {
+		t.Fatalf("NewGitBackend() error = %v", err)
+	}
+	defer backend.Close()
+
+	if err := backend.PutPage("page", "", []byte("first"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	first, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+
+	if err := backend.PutPage("page", "", []byte("second"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	// Move the branch back without checking it out, as "git update-ref" would
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatalf("PlainOpen() error = %v", err)
+	}
+
+	branch, err := backend.headBranch()
+	if err != nil {
+		t.Fatalf("headBranch() error = %v", err)
+	}
+
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(branch, first.Hash)); err != nil {
+		t.Fatalf("SetReference() error = %v", err)
+	}
+
+	if err := backend.checkHead(); err != nil {
+		t.Fatalf("checkHead() error = %v", err)
+	}
+
+	if backend.head != first.Hash {
+		t.Errorf("head = %s, want %s", backend.head, first.Hash)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "page.md"))
+	if err != nil || string(b) != "first" {
+		t.Errorf("page.md = %q (%v), want first", b, err)
+	}
+}
@@ -2,16 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/sergi/go-diff/diffmatchpatch"
+	"github.com/go-git/go-git/v5/plumbing/storer"
 )
 
 func (g *GitBackend) PageHistory(title string, start string, count int) (*History, error) {
@@ -23,41 +25,233 @@ func (g *GitBackend) PageHistory(title string, start string, count int) (*Histor
 		return nil, err
 	}
 
-	revision, err := g.resolveRevision(start)
+	return g.pathHistory(gitPath, true, start, count)
+}
+
+// FileHistory returns the changes made to an uploaded file, following it across renames.
+func (g *GitBackend) FileHistory(name string, start string, count int) (*History, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	_, gitPath, err := g.resolvePath(g.dir, name)
 	if err != nil {
 		return nil, err
 	}
 
-	commitIter, err := g.repo.Log(&git.LogOptions{
-		From: *revision,
-		PathFilter: func(s string) bool {
-			return s == gitPath
-		},
-	})
+	return g.pathHistory(gitPath, false, start, count)
+}
+
+// pathHistory returns up to count changes made to the file currently at gitPath, starting at the given revision.
+// If page is set, the names the file was renamed from are given as page titles.
+func (g *GitBackend) pathHistory(gitPath string, page bool, start string, count int) (*History, error) {
+	revision, err := g.resolvePublishedRevision(start)
 	if err != nil {
 		return nil, err
 	}
 
-	var history []*LogEntry
-	for i := 0; i < count; i++ {
-		commit, err := commitIter.Next()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
+	// When paginating, the page may have had a different name at the starting revision
+	if start != "" {
+		if gitPath, err = g.pagePathAt(gitPath, *revision); err != nil {
 			return nil, err
 		}
+	}
+
+	var history []*LogEntry
+	err = g.followPath(*revision, gitPath, func(commit *object.Commit, _, renamedFrom string, changed bool) error {
+		if !changed {
+			return nil
+		}
+
+		if len(history) == count {
+			return storer.ErrStop
+		}
+
+		if page {
+			renamedFrom = strings.TrimSuffix(renamedFrom, ".md")
+		}
 		history = append(history, &LogEntry{
-			ChangeId: commit.Hash.String(),
-			User:     commit.Author.Name,
-			Time:     commit.Author.When,
-			Message:  commit.Message,
+			ChangeId:    commit.Hash.String(),
+			User:        commit.Author.Name,
+			Time:        commit.Author.When,
+			Message:     commit.Message,
+			RenamedFrom: renamedFrom,
 		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return &History{Entries: history}, nil
 }
 
+// followPath walks the history starting at the given commit, calling fn for every commit with the path that the
+// file had at that point. Renames are detected by comparing each commit that added the file to its parents. The
+// path is tracked separately for each commit, and passed on to its parents as the walk reaches them, so a rename
+// on one line of history doesn't affect commits on another. changed indicates whether the commit differs
+// from all of its parents at that path, and renamedFrom is set for the commit that renamed the file. fn may return
+// storer.ErrStop to end the walk early.
+func (g *GitBackend) followPath(from plumbing.Hash, gitPath string, fn func(commit *object.Commit, path, renamedFrom string, changed bool) error) error {
+	commitIter, err := g.repo.Log(&git.LogOptions{From: from})
+	if err != nil {
+		return err
+	}
+
+	// Every commit is reached from one of its children, which will already have recorded its path
+	paths := map[plumbing.Hash]string{from: gitPath}
+	return commitIter.ForEach(func(commit *object.Commit) error {
+		current, ok := paths[commit.Hash]
+		if !ok {
+			current = gitPath
+		}
+		delete(paths, commit.Hash)
+
+		tree, err := commit.Tree()
+		if err != nil {
+			return err
+		}
+
+		var parentTrees []*object.Tree
+		err = commit.Parents().ForEach(func(parent *object.Commit) error {
+			parentTree, err := parent.Tree()
+			if err != nil {
+				return err
+			}
+			parentTrees = append(parentTrees, parentTree)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		changed := treeEntry(tree, current) != nil
+		if len(parentTrees) > 0 {
+			if changed, err = introducedBy(tree, parentTrees, current); err != nil {
+				return err
+			}
+		}
+
+		// Each parent that doesn't have the file may have had it under another name, such as when a page was renamed
+		// on a branch that this commit merges in
+		var renamedFrom string
+		for i, parentTree := range parentTrees {
+			_, known := paths[commit.ParentHashes[i]]
+			if known && (!changed || i > 0) {
+				continue
+			}
+
+			parentPath := current
+			if treeEntry(tree, current) != nil && treeEntry(parentTree, current) == nil {
+				source, err := g.renameSource(parentTree, tree, current, changed && i == 0)
+				if err != nil {
+					return err
+				}
+				if source != "" {
+					parentPath = source
+				}
+				if changed && i == 0 {
+					renamedFrom = source
+				}
+			}
+			if !known {
+				paths[commit.ParentHashes[i]] = parentPath
+			}
+		}
+
+		return fn(commit, current, renamedFrom, changed)
+	})
+}
+
+// renameSource returns the path in the parent tree of the file at gitPath in the child tree, if it was renamed
+// between them. If copies is set, pages renamed with a redirect left behind are also found.
+func (g *GitBackend) renameSource(parent, child *object.Tree, gitPath string, copies bool) (string, error) {
+	changes, err := object.DiffTreeWithOptions(context.Background(), parent, child, object.DefaultDiffTreeOptions)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range changes {
+		if changes[i].To.Name == gitPath && changes[i].From.Name != "" && changes[i].From.Name != gitPath {
+			return changes[i].From.Name, nil
+		}
+	}
+
+	// Pages renamed with a redirect left behind look like copies, so look for an identical page instead
+	if copies {
+		return g.findCopySource(parent, child, treeEntry(child, gitPath).Hash)
+	}
+	return "", nil
+}
+
+// findCopySource returns the path of a page in the parent tree with exactly the given content, if there is one
+// and it was changed in the child tree. Pages that were copied and left unchanged aren't treated as renamed.
+func (g *GitBackend) findCopySource(parent, child *object.Tree, hash plumbing.Hash) (string, error) {
+	var source string
+	err := g.walkTreeFiles(parent, "", func(name string, entry object.TreeEntry) error {
+		if entry.Hash != hash || path.Ext(name) != ".md" {
+			return nil
+		}
+
+		if now := treeEntry(child, name); now == nil || now.Hash != hash {
+			source = name
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if err == storer.ErrStop {
+		err = nil
+	}
+	return source, err
+}
+
+// pagePathAt finds the path that the page currently at gitPath had in the given commit, following renames.
+func (g *GitBackend) pagePathAt(gitPath string, revision plumbing.Hash) (string, error) {
+	paths, err := g.pagePathsAt(gitPath, revision)
+	if err != nil {
+		return "", err
+	}
+	return paths[revision], nil
+}
+
+// pagePathsAt finds the paths that the page currently at gitPath had in each of the given commits, following
+// renames. The history is walked at most once, however many revisions are given.
+func (g *GitBackend) pagePathsAt(gitPath string, revisions ...plumbing.Hash) (map[plumbing.Hash]string, error) {
+	paths := make(map[plumbing.Hash]string)
+	missing := make(map[plumbing.Hash]bool)
+	for _, revision := range revisions {
+		commit, err := g.repo.CommitObject(revision)
+		if err != nil {
+			return nil, err
+		}
+
+		paths[revision] = gitPath
+		if _, err := commit.File(gitPath); err != nil {
+			missing[revision] = true
+		}
+	}
+
+	if len(missing) == 0 {
+		return paths, nil
+	}
+
+	head, err := g.resolveRevision("")
+	if err != nil {
+		return nil, err
+	}
+
+	err = g.followPath(*head, gitPath, func(commit *object.Commit, path, _ string, _ bool) error {
+		if missing[commit.Hash] {
+			paths[commit.Hash] = path
+			delete(missing, commit.Hash)
+			if len(missing) == 0 {
+				return storer.ErrStop
+			}
+		}
+		return nil
+	})
+	return paths, err
+}
+
 func (g *GitBackend) GetPageAt(title, revision string) (*Page, error) {
 	g.mutex.RLock()
 	defer g.mutex.RUnlock()
@@ -67,7 +261,7 @@ func (g *GitBackend) GetPageAt(title, revision string) (*Page, error) {
 		return nil, err
 	}
 
-	commit, b, err := g.pathAtRevision(gitPath, revision)
+	commit, b, err := g.pageAtRevision(gitPath, revision)
 	if err != nil {
 		return nil, err
 	}
@@ -87,17 +281,139 @@ func (g *GitBackend) RevertPage(title, revision, user, message string) error {
 	g.mutex.Lock()
 	defer g.mutex.Unlock()
 
-	filePath, gitPath, err := g.resolvePath(g.dir, fmt.Sprintf("%s.md", title))
+	_, gitPath, err := g.resolvePath(g.dir, fmt.Sprintf("%s.md", title))
+	if err != nil {
+		return err
+	}
+
+	_, b, err := g.pageAtRevision(gitPath, revision)
+	if err != nil {
+		return err
+	}
+
+	return g.writeFile(gitPath, bytes.NewReader(b), user, message)
+}
+
+// RevertFile replaces an uploaded file with its content at the given revision.
+func (g *GitBackend) RevertFile(name, revision, user, message string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	_, gitPath, err := g.resolvePath(g.dir, name)
+	if err != nil {
+		return err
+	}
+
+	_, b, err := g.pageAtRevision(gitPath, revision)
+	if err != nil {
+		return err
+	}
+
+	return g.writeFile(gitPath, bytes.NewReader(b), user, message)
+}
+
+// GetFileAt opens an uploaded file as it was at the given revision, even if it had a different name at the time.
+func (g *GitBackend) GetFileAt(name, revision string) (io.ReadCloser, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	_, gitPath, err := g.resolvePath(g.dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	_, b, err := g.pageAtRevision(gitPath, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+// RevertChange undoes every change made by a single commit, including deletions and renames, by applying the
+// inverse of the commit on top of HEAD. Pages that have since been changed elsewhere are merged line-by-line; if
+// later changes can't be merged, a MergeConflictError is returned and nothing is changed. Merge commits are
+// reverted relative to their first parent.
+func (g *GitBackend) RevertChange(revision, user, message string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	hash, err := g.resolvePublishedRevision(revision)
+	if err != nil {
+		return err
+	}
+
+	commit, err := g.repo.CommitObject(*hash)
+	if err != nil {
+		return err
+	}
+
+	var parent *object.Commit
+	if commit.NumParents() > 0 {
+		if parent, err = commit.Parent(0); err != nil {
+			return err
+		}
+	}
+
+	head, err := g.headCommit()
+	if err != nil {
+		return err
+	} else if head == nil {
+		return plumbing.ErrReferenceNotFound
+	}
+
+	trees, err := commitTrees(commit, head, parent)
+	if err != nil {
+		return err
+	}
+
+	// Merging the parent into HEAD, using the commit itself as the base, applies exactly the inverse of the commit
+	tree, conflicts, err := g.mergeTrees(trees[0], trees[1], trees[2], conflictFail)
+	if err != nil {
+		return err
+	} else if len(conflicts) > 0 {
+		return &MergeConflictError{Conflicts: conflicts}
+	} else if tree == trees[1].Hash {
+		return ErrNoChanges
+	}
+
+	if message == "" {
+		message = fmt.Sprintf("Revert \"%s\"", strings.SplitN(strings.TrimSpace(commit.Message), "\n", 2)[0])
+	}
+	message = fmt.Sprintf("%s\n\nThis reverts commit %s.", message, commit.Hash)
+
+	sig := signature(user, time.Now())
+	reverted, err := g.commitTree(tree, []plumbing.Hash{head.Hash}, sig, sig, message)
 	if err != nil {
 		return err
 	}
 
-	_, b, err := g.pathAtRevision(gitPath, revision)
+	to, err := g.repo.CommitObject(reverted)
 	if err != nil {
 		return err
 	}
 
-	return g.writeFile(filePath, gitPath, bytes.NewReader(b), user, message)
+	if err := g.moveHead(head, to); err != nil {
+		return err
+	}
+
+	g.publish()
+	return nil
+}
+
+// pageAtRevision gets the contents of the page or file currently at the given path as it was at the given revision,
+// even if it had a different name at the time.
+func (g *GitBackend) pageAtRevision(gitPath, revision string) (*object.Commit, []byte, error) {
+	hash, err := g.resolvePublishedRevision(revision)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if gitPath, err = g.pagePathAt(gitPath, *hash); err != nil {
+		return nil, nil, err
+	}
+
+	return g.pathAtRevision(gitPath, hash.String())
 }
 
 // pathAtRevision gets the contents of the given path at the given revision, along the with commit object.
@@ -126,43 +442,50 @@ func (g *GitBackend) pathAtRevision(gitPath, revision string) (*object.Commit, [
 	return commit, b, nil
 }
 
-func (g *GitBackend) RecentChanges(start string, count int) ([]*RecentChange, error) {
-	g.mutex.Lock()
-	defer g.mutex.Unlock()
+// RecentChanges returns up to count changes matching the filter, starting at the given revision. Only the paths
+// that match the filter are included in each change.
+func (g *GitBackend) RecentChanges(start string, count int, filter ChangeFilter) ([]*RecentChange, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
 
-	revision, err := g.resolveRevision(start)
+	revision, err := g.resolvePublishedRevision(start)
 	if err != nil {
 		return nil, err
 	}
 
-	commitIter, err := g.repo.Log(&git.LogOptions{From: *revision})
+	// Ordering by commit time interleaves commits merged in from elsewhere with those made here, so once the main
+	// line of history passes the start of the filter, nothing older can match
+	commitIter, err := g.repo.Log(&git.LogOptions{From: *revision, Order: git.LogOrderCommitterTime})
 	if err != nil {
 		return nil, err
 	}
 
 	var history []*RecentChange
-	for i := 0; i < count; i++ {
-		commit, err := commitIter.Next()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, err
+	mainline := *revision
+	err = commitIter.ForEach(func(commit *object.Commit) error {
+		if len(history) == count {
+			return storer.ErrStop
 		}
 
-		myTree, err := commit.Tree()
-		if err != nil {
-			return nil, err
+		if commit.Hash == mainline {
+			if !filter.Since.IsZero() && commit.Committer.When.Before(filter.Since) {
+				return storer.ErrStop
+			}
+			if commit.NumParents() > 0 {
+				mainline = commit.ParentHashes[0]
+			}
 		}
 
-		parent, err := commit.Parent(0)
-		if err != nil {
-			return nil, err
+		when := commit.Author.When
+		if (filter.User != "" && !strings.EqualFold(commit.Author.Name, filter.User)) ||
+			(!filter.Since.IsZero() && when.Before(filter.Since)) ||
+			(!filter.Until.IsZero() && !when.Before(filter.Until)) {
+			return nil
 		}
 
-		parentTree, err := parent.Tree()
+		changes, err := commitDiff(commit)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		entry := &RecentChange{
@@ -174,43 +497,132 @@ func (g *GitBackend) RecentChanges(start string, count int) ([]*RecentChange, er
 			},
 		}
 
-		addFile := func(name string) {
-			if filepath.Dir(name) == ".wiki" {
-				entry.Config = strings.TrimSuffix(filepath.Base(name), ".json.enc")
-			} else if path.Ext(name) == ".md" {
-				entry.Page = strings.TrimSuffix(name, ".md")
-			} else {
-				entry.File = name
+		for j := range changes {
+			changed, err := g.changedPath(changes[j])
+			if err != nil {
+				return err
+			}
+			if filter.matches(changed) {
+				entry.Paths = append(entry.Paths, changed)
 			}
 		}
 
-		var hashes = make(map[string]plumbing.Hash)
-		if err := g.walkTreeFiles(myTree, "", func(name string, entry object.TreeEntry) error {
-			hashes[name] = entry.Hash
+		// Commits that don't change anything are only shown when they can't have been filtered out
+		if len(entry.Paths) == 0 && (len(changes) > 0 || filter.Prefix != "" || filter.Type != "") {
 			return nil
-		}); err != nil {
-			return nil, err
 		}
 
-		// Find any hashes that have changed, or files that exist in the parent tree that no longer do
-		if err := g.walkTreeFiles(parentTree, "", func(name string, entry object.TreeEntry) error {
-			if hash := hashes[name]; hash != entry.Hash {
-				addFile(name)
-			}
-			delete(hashes, name)
-			return nil
-		}); err != nil {
+		history = append(history, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// matches determines whether a changed path is within the filter's directory and of the right type.
+func (f ChangeFilter) matches(p *ChangedPath) bool {
+	switch f.Type {
+	case ChangeTypePages:
+		if p.Page == "" {
+			return false
+		}
+	case ChangeTypeFiles:
+		if p.File == "" {
+			return false
+		}
+	case ChangeTypeConfig:
+		if p.Config == "" {
+			return false
+		}
+	}
+
+	if f.Prefix == "" {
+		return true
+	}
+
+	prefix := strings.ToLower(strings.Trim(f.Prefix, "/")) + "/"
+	for _, name := range []string{p.Page, p.File, p.RenamedFrom} {
+		if name != "" && strings.HasPrefix(name+"/", prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// commitDiff finds the files changed by a commit, compared to its first parent, detecting any renames. Every file
+// in the root commit is treated as added. Only subtrees whose hashes differ are compared, so the cost depends on
+// the size of the change rather than the size of the repository.
+func commitDiff(commit *object.Commit) (object.Changes, error) {
+	var parent *object.Commit
+	if commit.NumParents() > 0 {
+		var err error
+		if parent, err = commit.Parent(0); err != nil {
 			return nil, err
 		}
+	}
 
-		// Any leftover files are new compared to the parent tree
-		for j := range hashes {
-			addFile(j)
+	trees, err := commitTrees(parent, commit)
+	if err != nil {
+		return nil, err
+	}
+
+	return object.DiffTreeWithOptions(context.Background(), trees[0], trees[1], object.DefaultDiffTreeOptions)
+}
+
+// changedPath describes a change to a single file, working out whether it's a page, file or config setting and
+// how much its size changed.
+func (g *GitBackend) changedPath(change *object.Change) (*ChangedPath, error) {
+	from, to := change.From.Name, change.To.Name
+
+	var size int64
+	if from != "" {
+		blob, err := g.repo.BlobObject(change.From.TreeEntry.Hash)
+		if err != nil {
+			return nil, err
+		}
+		size -= blob.Size
+	}
+	if to != "" {
+		blob, err := g.repo.BlobObject(change.To.TreeEntry.Hash)
+		if err != nil {
+			return nil, err
 		}
+		size += blob.Size
+	}
 
-		history = append(history, entry)
+	result := &ChangedPath{SizeDelta: size}
+	name := to
+	switch {
+	case from == "":
+		result.Action = ChangeAdded
+	case to == "":
+		result.Action = ChangeDeleted
+		name = from
+	case from != to:
+		result.Action = ChangeRenamed
+		result.RenamedFrom = pathTitle(from)
+	default:
+		result.Action = ChangeModified
 	}
-	return history, nil
+
+	if filepath.Dir(name) == ".wiki" {
+		result.Config = strings.TrimSuffix(filepath.Base(name), ".json.enc")
+	} else if path.Ext(name) == ".md" {
+		result.Page = pathTitle(name)
+	} else {
+		result.File = name
+	}
+	return result, nil
+}
+
+// pathTitle converts a path in the repository to the name shown to users, which for pages is the title.
+func pathTitle(name string) string {
+	if path.Ext(name) == ".md" {
+		return strings.TrimSuffix(name, ".md")
+	}
+	return name
 }
 
 func (g *GitBackend) walkTreeFiles(tree *object.Tree, prefix string, h func(name string, entry object.TreeEntry) error) error {
@@ -233,18 +645,52 @@ func (g *GitBackend) walkTreeFiles(tree *object.Tree, prefix string, h func(name
 	return nil
 }
 
-func (g *GitBackend) PathDiff(path string, startRevision string, endRevision string) ([]diffmatchpatch.Diff, error) {
+// PathDiff compares a page at two revisions, following it across renames. Pages that were added or deleted
+// between the revisions are treated as empty on the side where they're missing.
+func (g *GitBackend) PathDiff(path string, startRevision string, endRevision string, options DiffOptions) (*TextDiff, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
 	_, gitPath, err := g.resolvePath(g.dir, fmt.Sprintf("%s.md", path))
-	_, startContent, err := g.pathAtRevision(gitPath, startRevision)
 	if err != nil {
 		return nil, err
 	}
-	_, endContent, err := g.pathAtRevision(gitPath, endRevision)
+
+	start, err := g.resolvePublishedRevision(startRevision)
+	if err != nil {
+		return nil, err
+	}
+	end, err := g.resolvePublishedRevision(endRevision)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.pathDiff(gitPath, *start, *end, options)
+}
+
+// pathDiff compares the file at gitPath in two commits, following it across renames.
+func (g *GitBackend) pathDiff(gitPath string, start, end plumbing.Hash, options DiffOptions) (*TextDiff, error) {
+	paths, err := g.pagePathsAt(gitPath, start, end)
 	if err != nil {
 		return nil, err
 	}
-	thing := diffmatchpatch.New()
-	diffs := thing.DiffMain(string(startContent), string(endContent), false)
-	diffs = thing.DiffCleanupSemantic(diffs)
-	return diffs, nil
+
+	startPath, endPath := paths[start], paths[end]
+	_, startContent, startErr := g.pathAtRevision(startPath, start.String())
+	if startErr != nil && startErr != object.ErrFileNotFound {
+		return nil, startErr
+	}
+	_, endContent, endErr := g.pathAtRevision(endPath, end.String())
+	if endErr != nil && (endErr != object.ErrFileNotFound || startErr != nil) {
+		return nil, endErr
+	}
+
+	diff := diffText(string(startContent), string(endContent), options)
+	if startErr == nil {
+		diff.OldPath = startPath
+	}
+	if endErr == nil {
+		diff.NewPath = endPath
+	}
+	return diff, nil
 }
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGitBackend_HistoryFollowsRenames(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("old", "", []byte("first"), "user", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutPage("old", "", []byte("second"), "user", "update"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutPage("other", "", []byte("unrelated"), "user", "other"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.RenamePage("old", "dir/new", "rename", "user", false); err != nil {
+		t.Fatalf("RenamePage() error = %v", err)
+	}
+	if err := backend.PutPage("dir/new", "", []byte("third"), "user", "after rename"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	history, err := backend.PageHistory("dir/new", "", 10)
+	if err != nil {
+		t.Fatalf("PageHistory() error = %v", err)
+	}
+
+	var messages []string
+	for i := range history.Entries {
+		messages = append(messages, history.Entries[i].Message)
+	}
+	if len(messages) != 4 || messages[0] != "after rename" || messages[1] != "rename" || messages[3] != "create" {
+		t.Fatalf("PageHistory() messages = %v, want after rename, rename, update, create", messages)
+	}
+	if history.Entries[1].RenamedFrom != "old" {
+		t.Errorf("PageHistory() rename entry RenamedFrom = %q, want old", history.Entries[1].RenamedFrom)
+	}
+
+	// Paginating from before the rename should still find the older revisions
+	older, err := backend.PageHistory("dir/new", history.Entries[2].ChangeId, 10)
+	if err != nil || len(older.Entries) != 2 {
+		t.Errorf("PageHistory() from before rename = %v, %v, want 2 entries", older, err)
+	}
+
+	page, err := backend.GetPageAt("dir/new", history.Entries[3].ChangeId)
+	if err != nil || string(page.Content) != "first" {
+		t.Errorf("GetPageAt() = %v, %v, want first revision", page, err)
+	}
+
+	diff, err := backend.PathDiff("dir/new", history.Entries[2].ChangeId, "HEAD", DiffOptions{})
+	if err != nil || len(diff.Hunks) == 0 {
+		t.Errorf("PathDiff() across rename = %v, %v", diff, err)
+	}
+
+	if err := backend.RevertPage("dir/new", history.Entries[3].ChangeId, "user", "revert"); err != nil {
+		t.Fatalf("RevertPage() error = %v", err)
+	}
+	if page, err := backend.GetPage("dir/new"); err != nil || string(page.Content) != "first" {
+		t.Errorf("GetPage() after revert = %v, %v, want first revision", page, err)
+	}
+}
+
+func TestGitBackend_HistoryFollowsRenamesOnMergedBranches(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("old", "", []byte("content"), "user", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	created, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+	if err := backend.RenamePage("old", "new", "rename", "user", false); err != nil {
+		t.Fatalf("RenamePage() error = %v", err)
+	}
+	renamed, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+
+	// Make an unrelated change alongside the rename, then merge the rename in as the second parent
+	if err := backend.moveHead(renamed, created); err != nil {
+		t.Fatalf("moveHead() error = %v", err)
+	}
+	if err := backend.PutPage("other", "", []byte("unrelated"), "user", "other"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	local, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+	if conflicts, err := backend.mergeIntoHead(local, renamed, conflictFail, "user", "user", "merge"); err != nil || len(conflicts) > 0 {
+		t.Fatalf("mergeIntoHead() = %v, %v", conflicts, err)
+	}
+
+	history, err := backend.PageHistory("new", "", 10)
+	if err != nil {
+		t.Fatalf("PageHistory() error = %v", err)
+	}
+
+	messages := make(map[string]string)
+	for i := range history.Entries {
+		messages[history.Entries[i].Message] = history.Entries[i].RenamedFrom
+	}
+	if !reflect.DeepEqual(messages, map[string]string{"create": "", "rename": "old"}) {
+		t.Errorf("PageHistory() = %v, want create and rename from old", messages)
+	}
+
+	page, err := backend.GetPageAt("new", created.Hash.String())
+	if err != nil || string(page.Content) != "content" {
+		t.Errorf("GetPageAt() before the rename = %v, %v, want content", page, err)
+	}
+}
+
+func TestGitBackend_RevertChange(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("edited", "", []byte("one\ntwo\nthree\n"), "user", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutPage("deleted", "", []byte("deleted"), "user", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutPage("renamed", "", []byte("renamed"), "user", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	changeset := backend.NewChangeset()
+	if err := changeset.PutPage("edited", "", []byte("one\ntwo\nchanged\n")); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := changeset.DeletePage("deleted"); err != nil {
+		t.Fatalf("DeletePage() error = %v", err)
+	}
+	if err := changeset.RenamePage("renamed", "moved"); err != nil {
+		t.Fatalf("RenamePage() error = %v", err)
+	}
+	if err := changeset.PutFile("added.txt", io.NopCloser(strings.NewReader("added"))); err != nil {
+		t.Fatalf("PutFile() error = %v", err)
+	}
+	if err := changeset.Commit("user", "Several changes"); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	change, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+
+	// A later, unrelated change to the same page should survive the revert
+	if err := backend.PutPage("edited", "", []byte("later\ntwo\nchanged\n"), "other", "later"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	if err := backend.RevertChange(change.Hash.String(), "reverter", ""); err != nil {
+		t.Fatalf("RevertChange() error = %v", err)
+	}
+
+	pages, err := backend.ListPages()
+	if err != nil {
+		t.Fatalf("ListPages() error = %v", err)
+	}
+	if strings.Join(pages, ",") != "deleted,edited,renamed" {
+		t.Errorf("ListPages() after revert = %v, want deleted, edited, renamed", pages)
+	}
+	if page, err := backend.GetPage("edited"); err != nil || string(page.Content) != "later\ntwo\nthree\n" {
+		t.Errorf("GetPage() after revert = %v, %v, want merged content", page, err)
+	}
+	if _, err := backend.GetFile("added.txt"); err == nil {
+		t.Errorf("GetFile() after revert found a file that should have been removed")
+	}
+
+	head, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+	if !strings.HasPrefix(head.Message, `Revert "Several changes"`) || !strings.Contains(head.Message, change.Hash.String()) {
+		t.Errorf("Revert commit message = %q", head.Message)
+	}
+
+	if err := backend.RevertChange(change.Hash.String(), "reverter", ""); !errors.Is(err, ErrNoChanges) {
+		t.Errorf("RevertChange() again error = %v, want ErrNoChanges", err)
+	}
+}
+
+func TestGitBackend_RevertChangeWithConflicts(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("page", "", []byte("original"), "user", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutPage("page", "", []byte("changed"), "user", "change"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	change, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+
+	if err := backend.PutPage("page", "", []byte("changed again"), "other", "later"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	var conflict *MergeConflictError
+	if err := backend.RevertChange(change.Hash.String(), "reverter", ""); !errors.As(err, &conflict) || len(conflict.Conflicts) != 1 {
+		t.Fatalf("RevertChange() error = %v, want MergeConflictError", err)
+	}
+
+	if page, err := backend.GetPage("page"); err != nil || string(page.Content) != "changed again" {
+		t.Errorf("GetPage() after failed revert = %v, %v, want unchanged", page, err)
+	}
+}
+
+func TestGitBackend_FileHistory(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutFile("image.png", io.NopCloser(strings.NewReader("first")), "user", "upload"); err != nil {
+		t.Fatalf("PutFile() error = %v", err)
+	}
+	if err := backend.PutFile("image.png", io.NopCloser(strings.NewReader("second")), "user", "replace"); err != nil {
+		t.Fatalf("PutFile() error = %v", err)
+	}
+
+	history, err := backend.FileHistory("image.png", "", 10)
+	if err != nil {
+		t.Fatalf("FileHistory() error = %v", err)
+	}
+	if len(history.Entries) != 2 || history.Entries[0].Message != "replace" || history.Entries[1].Message != "upload" {
+		t.Fatalf("FileHistory() = %v, want replace and upload", history.Entries)
+	}
+
+	reader, err := backend.GetFileAt("image.png", history.Entries[1].ChangeId)
+	if err != nil {
+		t.Fatalf("GetFileAt() error = %v", err)
+	}
+	if b, _ := io.ReadAll(reader); string(b) != "first" {
+		t.Errorf("GetFileAt() = %q, want first", b)
+	}
+
+	if err := backend.RevertFile("image.png", history.Entries[1].ChangeId, "user", "revert"); err != nil {
+		t.Fatalf("RevertFile() error = %v", err)
+	}
+	reader, err = backend.GetFile("image.png")
+	if err != nil {
+		t.Fatalf("GetFile() error = %v", err)
+	}
+	defer reader.Close()
+	if b, _ := io.ReadAll(reader); string(b) != "first" {
+		t.Errorf("GetFile() after revert = %q, want first", b)
+	}
+
+	changeset := backend.NewChangeset()
+	_ = changeset.RenameFile("image.png", "photo.png")
+	if err := changeset.Commit("user", "rename"); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	history, err = backend.FileHistory("photo.png", "", 10)
+	if err != nil {
+		t.Fatalf("FileHistory() after rename error = %v", err)
+	}
+	if len(history.Entries) != 4 || history.Entries[0].RenamedFrom != "image.png" {
+		t.Errorf("FileHistory() after rename = %v, want it renamed from image.png", history.Entries)
+	}
+}
+
+func TestGitBackend_PathDiffPatchForNewPage(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("other", "", []byte("other"), "user", "create other"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutPage("new", "", []byte("one\ntwo\n"), "user", "create new"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	history, err := backend.PageHistory("other", "", 10)
+	if err != nil {
+		t.Fatalf("PageHistory() error = %v", err)
+	}
+
+	diff, err := backend.PathDiff("new", history.Entries[0].ChangeId, "HEAD", DiffOptions{Context: defaultDiffContext})
+	if err != nil {
+		t.Fatalf("PathDiff() error = %v", err)
+	}
+	if diff.OldPath != "" || diff.NewPath != "new.md" {
+		t.Errorf("PathDiff() paths = %q, %q, want only the new path", diff.OldPath, diff.NewPath)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "new.patch"), []byte(diff.Patch()), os.FileMode(0644)); err != nil {
+		t.Fatalf("Unable to write patch: %v", err)
+	}
+	if out, err := runGit(t, dir, "apply", "new.patch"); err != nil {
+		t.Fatalf("git apply error = %v: %s", err, out)
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, "new.md")); err != nil || string(content) != "one\ntwo\n" {
+		t.Errorf("Applied patch = %q, %v, want the new page", content, err)
+	}
+}
+
+func TestGitBackend_RecentChangesIncludesRootCommit(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("first", "", []byte("first"), "user", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutFile("dir/file.txt", io.NopCloser(strings.NewReader("file")), "user", "upload"); err != nil {
+		t.Fatalf("PutFile() error = %v", err)
+	}
+
+	changes, err := backend.RecentChanges("", 10, ChangeFilter{})
+	if err != nil {
+		t.Fatalf("RecentChanges() error = %v", err)
+	}
+	if len(changes) != 2 || len(changes[0].Paths) != 1 || changes[0].Paths[0].File != "dir/file.txt" || len(changes[1].Paths) != 1 || changes[1].Paths[0].Page != "first" {
+		t.Fatalf("RecentChanges() = %v, want the upload and the root commit", changes)
+	}
+
+	if changes, err := backend.RecentChanges(changes[1].ChangeId, 10, ChangeFilter{}); err != nil || len(changes) != 1 {
+		t.Errorf("RecentChanges() from root commit = %v, %v", changes, err)
+	}
+}
+
+func TestGitBackend_RecentChangesListsEveryPath(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("edited", "", []byte("1234"), "user", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutPage("deleted", "", []byte("123"), "user", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutPage("renamed", "", []byte("12"), "user", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	changeset := backend.NewChangeset()
+	if err := changeset.PutPage("edited", "", []byte("123456")); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := changeset.DeletePage("deleted"); err != nil {
+		t.Fatalf("DeletePage() error = %v", err)
+	}
+	if err := changeset.RenamePage("renamed", "moved"); err != nil {
+		t.Fatalf("RenamePage() error = %v", err)
+	}
+	if err := changeset.PutFile("added.txt", io.NopCloser(strings.NewReader("1"))); err != nil {
+		t.Fatalf("PutFile() error = %v", err)
+	}
+	if err := changeset.Commit("user", "Several changes"); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	changes, err := backend.RecentChanges("", 1, ChangeFilter{})
+	if err != nil {
+		t.Fatalf("RecentChanges() error = %v", err)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("RecentChanges() = %v, want one change", changes)
+	}
+
+	got := make(map[string]ChangedPath)
+	for _, p := range changes[0].Paths {
+		got[p.Page+p.File] = *p
+	}
+	want := map[string]ChangedPath{
+		"edited":    {Action: ChangeModified, Page: "edited", SizeDelta: 2},
+		"deleted":   {Action: ChangeDeleted, Page: "deleted", SizeDelta: -3},
+		"moved":     {Action: ChangeRenamed, Page: "moved", RenamedFrom: "renamed"},
+		"added.txt": {Action: ChangeAdded, File: "added.txt", SizeDelta: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RecentChanges() paths = %+v, want %+v", got, want)
+	}
+}
+
+func TestGitBackend_RecentChangesFilter(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("docs/one", "", []byte("one"), "alice", "docs one"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutFile("docs/image.png", io.NopCloser(strings.NewReader("image")), "bob", "docs image"); err != nil {
+		t.Fatalf("PutFile() error = %v", err)
+	}
+	if err := backend.PutPage("other", "", []byte("other"), "alice", "other"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutPage("docs/two", "", []byte("two"), "bob", "docs two"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutPage("documents", "", []byte("not in docs"), "bob", "documents"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	messages := func(filter ChangeFilter, start string, count int) []string {
+		changes, err := backend.RecentChanges(start, count, filter)
+		if err != nil {
+			t.Fatalf("RecentChanges() error = %v", err)
+		}
+		var result []string
+		for i := range changes {
+			result = append(result, changes[i].Message)
+		}
+		return result
+	}
+
+	tests := []struct {
+		name   string
+		filter ChangeFilter
+		want   []string
+	}{
+		{"user", ChangeFilter{User: "Alice"}, []string{"other", "docs one"}},
+		{"prefix", ChangeFilter{Prefix: "/docs/"}, []string{"docs two", "docs image", "docs one"}},
+		{"type", ChangeFilter{Prefix: "docs", Type: ChangeTypeFiles}, []string{"docs image"}},
+		{"combined", ChangeFilter{User: "bob", Type: ChangeTypePages}, []string{"documents", "docs two"}},
+		{"until", ChangeFilter{Until: time.Now().Add(-time.Hour)}, nil},
+		{"since", ChangeFilter{Since: time.Now().Add(-time.Hour), Type: ChangeTypeConfig}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := messages(tt.filter, "", 10); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RecentChanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Paginating should carry on from the given change, still applying the filter
+	changes, err := backend.RecentChanges("", 2, ChangeFilter{Prefix: "docs"})
+	if err != nil || len(changes) != 2 {
+		t.Fatalf("RecentChanges() = %v, %v", changes, err)
+	}
+	if got := messages(ChangeFilter{Prefix: "docs"}, changes[1].ChangeId, 10); !reflect.DeepEqual(got, []string{"docs image", "docs one"}) {
+		t.Errorf("RecentChanges() from %s = %v", changes[1].ChangeId, got)
+	}
+}
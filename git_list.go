@@ -3,8 +3,8 @@ package main
 import (
 	"fmt"
 	"io/fs"
-	"os"
-	"path/filepath"
+	"path"
+	"sort"
 	"strings"
 )
 
@@ -12,40 +12,113 @@ func (g *GitBackend) PageExists(title string) bool {
 	g.mutex.RLock()
 	defer g.mutex.RUnlock()
 
-	filePath, _, err := g.resolvePath(g.dir, fmt.Sprintf("%s.md", title))
+	_, gitPath, err := g.resolvePath(g.dir, fmt.Sprintf("%s.md", title))
 	if err != nil {
 		return false
 	}
 
-	fi, err := os.Stat(filePath)
-	return err == nil && !fi.IsDir()
+	return g.fileExists(gitPath)
 }
 
 func (g *GitBackend) ListPages() ([]string, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	return listPages(g.walkFiles)
+}
+
+func (g *GitBackend) ListFiles() ([]File, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	return listFiles(g.walkFiles)
+}
+
+// listPages collects the titles of all the pages visited by the given walk function.
+func listPages(walk func(handler func(webPath string, file wikiFile) error) error) ([]string, error) {
 	var pages []string
-	return pages, g.walkFiles(func(filePath, webPath string, info fs.DirEntry) error {
-		if filepath.Ext(filePath) == ".md" {
+	return pages, walk(func(webPath string, file wikiFile) error {
+		if path.Ext(webPath) == ".md" {
 			pages = append(pages, strings.TrimSuffix(webPath, ".md"))
 		}
 		return nil
 	})
 }
 
-func (g *GitBackend) ListFiles() ([]File, error) {
+// listFiles collects the names and sizes of all the non-page files visited by the given walk function.
+func listFiles(walk func(handler func(webPath string, file wikiFile) error) error) ([]File, error) {
 	var files []File
-
-	return files, g.walkFiles(func(filePath, webPath string, info fs.DirEntry) error {
-		if filepath.Ext(filePath) != ".md" {
-			stat, err := os.Stat(filePath)
+	return files, walk(func(webPath string, file wikiFile) error {
+		if path.Ext(webPath) != ".md" {
+			size, err := file.Size()
 			if err != nil {
 				return err
 			}
 
 			files = append(files, File{
 				Name: webPath,
-				Size: stat.Size(),
+				Size: size,
 			})
 		}
 		return nil
 	})
 }
+
+// DirectoryContents returns the titles of all pages and the names of all files within the given directory,
+// including those in subdirectories.
+func (g *GitBackend) DirectoryContents(dir string) ([]string, []string, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	_, gitPath, err := g.resolvePath(g.dir, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files, err := g.directoryFiles(gitPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var pages, others []string
+	for name := range files {
+		if path.Ext(name) == ".md" {
+			pages = append(pages, strings.TrimSuffix(name, ".md"))
+		} else {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(pages)
+	sort.Strings(others)
+	return pages, others, nil
+}
+
+// directoryFiles returns the entries for every file within the given directory at HEAD.
+func (g *GitBackend) directoryFiles(gitPath string) (treeFiles, error) {
+	head, err := g.headCommit()
+	if err != nil {
+		return nil, err
+	}
+
+	trees, err := commitTrees(head)
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := g.flattenTree(trees[0])
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(treeFiles)
+	for name, entry := range all {
+		if strings.HasPrefix(name, gitPath+"/") {
+			files[name] = entry
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, &fs.PathError{Op: "open", Path: gitPath, Err: fs.ErrNotExist}
+	}
+	return files, nil
+}
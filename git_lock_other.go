@@ -0,0 +1,27 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockDirectory creates a lock file in the given directory, so that no other wiki process can use it at the same
+// time. Without support for file locking, a lock file left behind by a crash must be removed manually.
+func lockDirectory(dir string) (*os.File, error) {
+	path := lockFilePath(dir)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, os.FileMode(0644))
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("%s is already in use by another process (if not, remove %s)", dir, path)
+	}
+	return f, err
+}
+
+// unlockDirectory releases a lock taken by lockDirectory.
+func unlockDirectory(f *os.File) error {
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,34 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockDirectory takes an exclusive lock on a file in the given directory, so that no other wiki process can use
+// it at the same time. The lock is released automatically when the process exits.
+func lockDirectory(dir string) (*os.File, error) {
+	f, err := os.OpenFile(lockFilePath(dir), os.O_RDWR|os.O_CREATE, os.FileMode(0644))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%s is already in use by another process", dir)
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// unlockDirectory releases a lock taken by lockDirectory.
+func unlockDirectory(f *os.File) error {
+	return f.Close()
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// conflictStrategy determines how mergeTrees handles files that were changed differently on both sides.
+type conflictStrategy int
+
+const (
+	// conflictFail reports conflicting files and does not produce a merged tree.
+	conflictFail conflictStrategy = iota
+	// conflictOurs resolves conflicting files by taking our version.
+	conflictOurs
+	// conflictTheirs resolves conflicting files by taking their version.
+	conflictTheirs
+)
+
+// mergeTrees performs a three-way merge of two trees that share a common base. Files changed on only one side are
+// taken from that side, and pages changed on both sides are merged line-by-line. Any other files changed on both
+// sides are handled according to the given strategy; if it is conflictFail and any conflicts are found, their
+// paths are returned and no tree is written.
+func (g *GitBackend) mergeTrees(base, ours, theirs *object.Tree, strategy conflictStrategy) (plumbing.Hash, []string, error) {
+	trees := []*object.Tree{base, ours, theirs}
+	files := make([]treeFiles, len(trees))
+	for i := range trees {
+		f, err := g.flattenTree(trees[i])
+		if err != nil {
+			return plumbing.ZeroHash, nil, err
+		}
+		files[i] = f
+	}
+	baseFiles, ourFiles, theirFiles := files[0], files[1], files[2]
+
+	paths := make(map[string]bool)
+	for i := range files {
+		for name := range files[i] {
+			paths[name] = true
+		}
+	}
+
+	var conflicts []string
+	merged := make(treeFiles)
+	for name := range paths {
+		b, inBase := baseFiles[name]
+		o, inOurs := ourFiles[name]
+		t, inTheirs := theirFiles[name]
+
+		var result object.TreeEntry
+		var keep bool
+		switch {
+		case sameEntry(o, inOurs, t, inTheirs):
+			result, keep = o, inOurs
+		case sameEntry(b, inBase, o, inOurs):
+			result, keep = t, inTheirs
+		case sameEntry(b, inBase, t, inTheirs):
+			result, keep = o, inOurs
+		default:
+			entry, ok, err := g.mergeFile(name, b, inBase, o, inOurs, t, inTheirs)
+			if err != nil {
+				return plumbing.ZeroHash, nil, err
+			}
+
+			if ok {
+				result, keep = entry, true
+			} else if strategy == conflictOurs {
+				result, keep = o, inOurs
+			} else if strategy == conflictTheirs {
+				result, keep = t, inTheirs
+			} else {
+				conflicts = append(conflicts, name)
+				continue
+			}
+		}
+
+		if keep {
+			merged[name] = result
+		}
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return plumbing.ZeroHash, conflicts, nil
+	}
+
+	hash, err := g.writeTree(merged)
+	return hash, nil, err
+}
+
+// MergeConflictError is returned when unpublished changes, such as a draft, can't be merged because the pages they
+// change have been changed differently since they were started.
+type MergeConflictError struct {
+	Conflicts []string
+}
+
+func (e *MergeConflictError) Error() string {
+	return fmt.Sprintf("changes conflict with published changes to: %s", strings.Join(e.Conflicts, ", "))
+}
+
+// mergeIntoHead creates a merge commit combining HEAD (which must be the given local commit) with another commit,
+// and moves HEAD to it. If the merge has conflicts that the strategy doesn't resolve, they are returned and HEAD is
+// left untouched. The author and committer are usually the same user, but may differ if one user's changes are
+// being merged by another.
+func (g *GitBackend) mergeIntoHead(local, other *object.Commit, strategy conflictStrategy, author, committer, message string) ([]string, error) {
+	var base *object.Commit
+	bases, err := local.MergeBase(other)
+	if err != nil {
+		return nil, err
+	}
+	if len(bases) > 0 {
+		base = bases[0]
+	}
+
+	trees, err := commitTrees(base, local, other)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, conflicts, err := g.mergeTrees(trees[0], trees[1], trees[2], strategy)
+	if err != nil || len(conflicts) > 0 {
+		return conflicts, err
+	}
+
+	now := time.Now()
+	hash, err := g.commitTree(tree, []plumbing.Hash{local.Hash, other.Hash}, signature(author, now), signature(committer, now), message)
+	if err != nil {
+		return nil, err
+	}
+
+	merged, err := g.repo.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, g.moveHead(local, merged)
+}
+
+// mergeFile attempts a line-based merge of a page that was changed on both sides. It returns false if the file
+// is not a page, has been deleted on either side, or if the changes overlap.
+func (g *GitBackend) mergeFile(name string, base object.TreeEntry, inBase bool, ours object.TreeEntry, inOurs bool, theirs object.TreeEntry, inTheirs bool) (object.TreeEntry, bool, error) {
+	if path.Ext(name) != ".md" || !inOurs || !inTheirs {
+		return object.TreeEntry{}, false, nil
+	}
+
+	var baseContent []byte
+	if inBase {
+		b, err := g.readBlob(base.Hash)
+		if err != nil {
+			return object.TreeEntry{}, false, err
+		}
+		baseContent = b
+	}
+
+	ourContent, err := g.readBlob(ours.Hash)
+	if err != nil {
+		return object.TreeEntry{}, false, err
+	}
+
+	theirContent, err := g.readBlob(theirs.Hash)
+	if err != nil {
+		return object.TreeEntry{}, false, err
+	}
+
+	merged, clean := mergeText(baseContent, ourContent, theirContent, "ours", "theirs")
+	if !clean {
+		return object.TreeEntry{}, false, nil
+	}
+
+	hash, err := g.writeBlob(bytes.NewReader(merged))
+	if err != nil {
+		return object.TreeEntry{}, false, err
+	}
+
+	return object.TreeEntry{Name: ours.Name, Mode: ours.Mode, Hash: hash}, true, nil
+}
+
+// sameEntry determines whether two (possibly absent) tree entries have the same content.
+func sameEntry(a object.TreeEntry, aExists bool, b object.TreeEntry, bExists bool) bool {
+	if !aExists || !bExists {
+		return aExists == bExists
+	}
+	return a.Hash == b.Hash && a.Mode == b.Mode
+}
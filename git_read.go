@@ -3,8 +3,7 @@ package main
 import (
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
+	"path"
 
 	"github.com/go-git/go-git/v5"
 )
@@ -13,7 +12,7 @@ func (g *GitBackend) GetPage(title string) (*Page, error) {
 	g.mutex.RLock()
 	defer g.mutex.RUnlock()
 
-	filePath, gitPath, err := g.resolvePath(g.dir, fmt.Sprintf("%s.md", title))
+	_, gitPath, err := g.resolvePath(g.dir, fmt.Sprintf("%s.md", title))
 	if err != nil {
 		return nil, err
 	}
@@ -30,7 +29,7 @@ func (g *GitBackend) GetPage(title string) (*Page, error) {
 	if err != nil {
 		return nil, err
 	}
-	bytes, err := os.ReadFile(filePath)
+	bytes, err := g.readFile(gitPath)
 	if err != nil {
 		return nil, err
 	}
@@ -46,15 +45,20 @@ func (g *GitBackend) GetPage(title string) (*Page, error) {
 }
 
 func (g *GitBackend) GetFile(name string) (io.ReadCloser, error) {
-	filePath, _, err := g.resolvePath(g.dir, name)
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	_, gitPath, err := g.resolvePath(g.dir, name)
 	if err != nil {
 		return nil, err
 	}
 
-	return os.Open(filePath)
+	return g.openFile(gitPath)
 }
 
 func (g *GitBackend) GetConfig(name string) ([]byte, error) {
-	filePath := filepath.Join(g.dir, ".wiki", fmt.Sprintf("%s.json.enc", name))
-	return os.ReadFile(filePath)
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	return g.readFile(path.Join(".wiki", fmt.Sprintf("%s.json.enc", name)))
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// redirectPattern matches pages that redirect to another page. Only the first line of a page is checked.
+var redirectPattern = regexp.MustCompile(`(?i)^\s*#redirect\s*\[\[([^\]|]+)(?:\|[^\]]*)?]]`)
+
+// Redirect describes a page that redirects to another.
+type Redirect struct {
+	Page   string
+	Target string
+	// Double is true if the target is itself a redirect, which won't be followed.
+	Double bool
+	// Broken is true if the target doesn't exist.
+	Broken bool
+}
+
+// redirectContent returns the content of a page that redirects to the given page.
+func redirectContent(target string) []byte {
+	return []byte(fmt.Sprintf("#REDIRECT [[%s]]\n", target))
+}
+
+// parseRedirect returns the title of the page that the given page content redirects to, if it is a redirect.
+func parseRedirect(content []byte) (string, bool) {
+	match := redirectPattern.FindSubmatch(content)
+	if match == nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(match[1])), true
+}
+
+// Redirects returns all pages that redirect to another page, noting any that redirect to missing pages or to
+// other redirects.
+func (g *GitBackend) Redirects() ([]*Redirect, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	pages := make(map[string]bool)
+	var redirects []*Redirect
+	err := g.walkFiles(func(webPath string, file wikiFile) error {
+		if path.Ext(webPath) != ".md" {
+			return nil
+		}
+
+		title := strings.TrimSuffix(webPath, ".md")
+		pages[title] = true
+
+		reader, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		// Redirects are only recognised on the first line, so there's no need to read all of every page
+		content, err := io.ReadAll(io.LimitReader(reader, 1024))
+		if err != nil {
+			return err
+		}
+
+		if target, ok := parseRedirect(content); ok {
+			redirects = append(redirects, &Redirect{Page: title, Target: strings.ToLower(target)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make(map[string]bool)
+	for i := range redirects {
+		targets[redirects[i].Page] = true
+	}
+
+	for i := range redirects {
+		// Targets may link to a section of the page, which doesn't affect whether it exists
+		target, _, _ := strings.Cut(redirects[i].Target, "#")
+		redirects[i].Double = targets[target]
+		redirects[i].Broken = !pages[target]
+	}
+
+	sort.Slice(redirects, func(i, j int) bool {
+		return redirects[i].Page < redirects[j].Page
+	})
+	return redirects, nil
+}
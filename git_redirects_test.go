@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseRedirect(t *testing.T) {
+	tests := []struct {
+		content string
+		target  string
+		ok      bool
+	}{
+		{"#REDIRECT [[new/page]]\n", "new/page", true},
+		{"  #redirect[[Other|label]]", "Other", true},
+		{"Some text\n#REDIRECT [[new]]", "", false},
+		{"# Redirect [[new]]", "", false},
+	}
+	for _, tt := range tests {
+		target, ok := parseRedirect([]byte(tt.content))
+		if target != tt.target || ok != tt.ok {
+			t.Errorf("parseRedirect(%q) = %q, %v, want %q, %v", tt.content, target, ok, tt.target, tt.ok)
+		}
+	}
+}
+
+func Test_redirectLocation(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		target   string
+		location string
+	}{
+		{"", "new/page", "/view/new/page?redirect=no"},
+		{"", "what? 100% & more", "/view/what%3F%20100%25%20&%20more?redirect=no"},
+		{"", "other#some section", "/view/other?redirect=no#some%20section"},
+		{"/snapshot/v1", "page", "/snapshot/v1/view/page?redirect=no"},
+	}
+	for _, tt := range tests {
+		if location := redirectLocation(tt.prefix, tt.target); location != tt.location {
+			t.Errorf("redirectLocation(%q, %q) = %q, want %q", tt.prefix, tt.target, location, tt.location)
+		}
+	}
+}
+
+func TestGitBackend_RenamePageLeavesRedirect(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("first", "", []byte("content"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.RenamePage("first", "second", "", "user", true); err != nil {
+		t.Fatalf("RenamePage() error = %v", err)
+	}
+	if err := backend.RenamePage("second", "third", "", "user", true); err != nil {
+		t.Fatalf("RenamePage() error = %v", err)
+	}
+	if err := backend.PutPage("broken", "", redirectContent("missing"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutPage("section", "", redirectContent("third#Intro"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	page, err := backend.GetPage("first")
+	if err != nil {
+		t.Fatalf("GetPage() error = %v", err)
+	}
+	if target, ok := parseRedirect(page.Content); !ok || target != "second" {
+		t.Errorf("GetPage() of renamed page = %q, want a redirect to second", page.Content)
+	}
+
+	history, err := backend.PageHistory("third", "", 10)
+	if err != nil {
+		t.Fatalf("PageHistory() error = %v", err)
+	}
+	if len(history.Entries) != 3 || history.Entries[0].RenamedFrom != "second" || history.Entries[1].RenamedFrom != "first" {
+		t.Errorf("PageHistory() didn't follow renames that left redirects: %v", history.Entries)
+	}
+
+	redirects, err := backend.Redirects()
+	if err != nil {
+		t.Fatalf("Redirects() error = %v", err)
+	}
+	want := []*Redirect{
+		{Page: "broken", Target: "missing", Broken: true},
+		{Page: "first", Target: "second", Double: true},
+		{Page: "second", Target: "third"},
+		{Page: "section", Target: "third#intro"},
+	}
+	if !reflect.DeepEqual(redirects, want) {
+		t.Errorf("Redirects() = %v, want %v", redirects, want)
+	}
+}
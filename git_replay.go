@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// ReplayedChange is a change to a single page or file that was originally made elsewhere, to be added to the
+// wiki's history as it happened.
+type ReplayedChange struct {
+	// Page is the title of the page that was changed, or File the name of the file, whichever is set.
+	Page string
+	File string
+	// Open returns the new content of the page or file.
+	Open    func() (io.ReadCloser, error)
+	Author  string
+	Time    time.Time
+	Message string
+}
+
+// ReplayChanges adds each change as a separate commit on top of HEAD, in the order given. Commits are attributed
+// to the change's original author and dated when it was originally made, but signed by the given committer at the
+// current time. HEAD is only moved, and the result published, once every commit has been built, so if any change
+// fails the wiki is left untouched. Changes that don't alter anything are skipped; the number of commits made is
+// returned.
+func (g *GitBackend) ReplayChanges(changes []*ReplayedChange, committer string) (int, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	head, err := g.headCommit()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	commit, count := head, 0
+	for _, change := range changes {
+		name := change.File
+		if change.Page != "" {
+			name = fmt.Sprintf("%s.md", change.Page)
+		}
+
+		_, gitPath, err := g.resolvePath(g.dir, name)
+		if err != nil {
+			return 0, err
+		}
+
+		hash, err := g.writeReplayedBlob(change)
+		if err != nil {
+			return 0, err
+		}
+
+		next, err := g.buildCommitAs(commit, map[string]plumbing.Hash{gitPath: hash}, signature(change.Author, change.Time), signature(committer, now), change.Message)
+		if err != nil {
+			return 0, err
+		} else if next == nil {
+			continue
+		}
+		commit = next
+		count++
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	if err := g.moveHead(head, commit); err != nil {
+		return 0, err
+	}
+
+	g.publish()
+	return count, nil
+}
+
+func (g *GitBackend) writeReplayedBlob(change *ReplayedChange) (plumbing.Hash, error) {
+	content, err := change.Open()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	defer content.Close()
+
+	return g.writeBlob(content)
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// requestRefPrefix is where change requests are stored. They're deliberately kept outside refs/heads, so they
+// aren't included when the wiki is cloned or synchronised with a remote.
+const requestRefPrefix = "refs/requests/"
+
+const (
+	ChangeRequestOpen     = "open"
+	ChangeRequestApproved = "approved"
+	ChangeRequestRejected = "rejected"
+)
+
+var (
+	errNoSuchChangeRequest = &fs.PathError{Op: "open", Path: "change request", Err: fs.ErrNotExist}
+
+	// ErrChangeRequestClosed is returned when trying to review a change request that has already been approved or
+	// rejected.
+	ErrChangeRequestClosed = errors.New("change request has already been closed")
+
+	// ErrNoChanges is returned when a proposed edit doesn't change anything.
+	ErrNoChanges = errors.New("no changes were made")
+)
+
+// ChangeRequest is a proposed change to the wiki that must be approved by an admin before it's published. The
+// proposed commit is stored under refs/requests/<id>/head, and everything else is stored as a JSON blob under
+// refs/requests/<id>/meta.
+type ChangeRequest struct {
+	Id       int `json:"-"`
+	Title    string
+	Author   string
+	Created  time.Time
+	Status   string
+	Reviewer string    `json:",omitempty"`
+	Reviewed time.Time `json:",omitempty"`
+	Comments []*Comment
+	// Base is the commit the change was made against.
+	Base string
+	// Head is the commit containing the proposed change.
+	Head string `json:"-"`
+	// Pages are the titles of the pages changed by the request.
+	Pages []string `json:"-"`
+}
+
+type Comment struct {
+	User string
+	Time time.Time
+	Text string
+}
+
+func requestRef(id int, kind string) plumbing.ReferenceName {
+	return plumbing.ReferenceName(fmt.Sprintf("%s%d/%s", requestRefPrefix, id, kind))
+}
+
+// requestId returns the ID of the change request that the given reference belongs to, if any.
+func requestId(ref plumbing.ReferenceName) (int, bool) {
+	rest, ok := strings.CutPrefix(ref.String(), requestRefPrefix)
+	if !ok {
+		return 0, false
+	}
+
+	id, kind, _ := strings.Cut(rest, "/")
+	n, err := strconv.Atoi(id)
+	return n, err == nil && kind == "meta"
+}
+
+// ProposePage creates a change request to update the content of a page. The change is based on the given revision,
+// if any, so that edits made in the meantime are merged rather than overwritten when it's approved.
+func (g *GitBackend) ProposePage(title, baseRevision string, content []byte, user, message string) (int, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	_, gitPath, err := g.resolvePath(g.dir, fmt.Sprintf("%s.md", title))
+	if err != nil {
+		return 0, err
+	}
+
+	var parent *object.Commit
+	if baseRevision != "" {
+		hash, err := g.resolvePublishedRevision(baseRevision)
+		if err != nil {
+			return 0, err
+		}
+		if parent, err = g.repo.CommitObject(*hash); err != nil {
+			return 0, err
+		}
+	} else if parent, err = g.headCommit(); err != nil {
+		return 0, err
+	}
+
+	hash, err := g.writeBlob(bytes.NewReader(content))
+	if err != nil {
+		return 0, err
+	}
+
+	if message == "" {
+		message = fmt.Sprintf("Change to %s", title)
+	}
+
+	return g.proposeChanges(parent, map[string]plumbing.Hash{gitPath: hash}, user, message)
+}
+
+// proposeChanges creates a change request for the given changes, made on top of the given parent commit.
+func (g *GitBackend) proposeChanges(parent *object.Commit, changes map[string]plumbing.Hash, user, message string) (int, error) {
+	commit, err := g.buildCommit(parent, changes, user, message)
+	if err != nil {
+		return 0, err
+	} else if commit == nil {
+		return 0, ErrNoChanges
+	}
+
+	id, err := g.nextRequestId()
+	if err != nil {
+		return 0, err
+	}
+
+	request := &ChangeRequest{
+		Id:      id,
+		Title:   message,
+		Author:  user,
+		Created: commit.Author.When,
+		Status:  ChangeRequestOpen,
+	}
+	if parent != nil {
+		request.Base = parent.Hash.String()
+	}
+
+	if err := g.repo.Storer.SetReference(plumbing.NewHashReference(requestRef(id, "head"), commit.Hash)); err != nil {
+		return 0, err
+	}
+	return id, g.writeRequest(request)
+}
+
+// nextRequestId finds the lowest ID that is higher than that of all existing change requests.
+func (g *GitBackend) nextRequestId() (int, error) {
+	refs, err := g.repo.Storer.IterReferences()
+	if err != nil {
+		return 0, err
+	}
+
+	next := 1
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if id, ok := requestId(ref.Name()); ok && id >= next {
+			next = id + 1
+		}
+		return nil
+	})
+	return next, err
+}
+
+// ListChangeRequests returns all change requests, newest first.
+func (g *GitBackend) ListChangeRequests() ([]*ChangeRequest, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	refs, err := g.repo.Storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	var requests []*ChangeRequest
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		id, ok := requestId(ref.Name())
+		if !ok {
+			return nil
+		}
+
+		request, err := g.readRequest(id)
+		if err != nil {
+			return err
+		}
+		requests = append(requests, request)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Id > requests[j].Id
+	})
+	return requests, nil
+}
+
+// GetChangeRequest returns the details of a single change request.
+func (g *GitBackend) GetChangeRequest(id int) (*ChangeRequest, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	return g.readRequest(id)
+}
+
+// ChangeRequestDiff compares a page as it was when a change request was made with the version the request proposes.
+func (g *GitBackend) ChangeRequestDiff(id int, title string, options DiffOptions) (*TextDiff, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	_, gitPath, err := g.resolvePath(g.dir, fmt.Sprintf("%s.md", title))
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := g.readRequest(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Requests made before the wiki had any pages propose everything from scratch
+	if request.Base == "" {
+		_, content, err := g.pathAtRevision(gitPath, request.Head)
+		if err != nil {
+			return nil, err
+		}
+		diff := diffText("", string(content), options)
+		diff.NewPath = gitPath
+		return diff, nil
+	}
+
+	return g.pathDiff(gitPath, plumbing.NewHash(request.Base), plumbing.NewHash(request.Head), options)
+}
+
+// CommentOnChangeRequest adds a comment to the discussion of a change request.
+func (g *GitBackend) CommentOnChangeRequest(id int, user, text string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	request, err := g.readRequest(id)
+	if err != nil {
+		return err
+	}
+
+	request.Comments = append(request.Comments, &Comment{User: user, Time: time.Now(), Text: text})
+	return g.writeRequest(request)
+}
+
+// ApproveChangeRequest merges the change into the published version of the wiki. The merge commit is attributed to
+// the author of the change, and the approver is recorded as its committer. If the pages in the change have since
+// been changed differently, a MergeConflictError is returned and the request is left open.
+func (g *GitBackend) ApproveChangeRequest(id int, user, message string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	request, err := g.readRequest(id)
+	if err != nil {
+		return err
+	}
+
+	if request.Status != ChangeRequestOpen {
+		return ErrChangeRequestClosed
+	}
+
+	tip, err := g.repo.CommitObject(plumbing.NewHash(request.Head))
+	if err != nil {
+		return err
+	}
+
+	head, err := g.headCommit()
+	if err != nil {
+		return err
+	}
+
+	if message == "" {
+		message = fmt.Sprintf("Merge change request #%d: %s", id, request.Title)
+	}
+	message = fmt.Sprintf("%s\n\nApproved-by: %s", message, user)
+
+	if head == nil {
+		if err := g.moveHead(nil, tip); err != nil {
+			return err
+		}
+	} else if conflicts, err := g.mergeIntoHead(head, tip, conflictFail, request.Author, user, message); err != nil {
+		return err
+	} else if len(conflicts) > 0 {
+		return &MergeConflictError{Conflicts: conflicts}
+	}
+
+	request.Status = ChangeRequestApproved
+	request.Reviewer = user
+	request.Reviewed = time.Now()
+	if err := g.writeRequest(request); err != nil {
+		return err
+	}
+
+	g.publish()
+	return nil
+}
+
+// RejectChangeRequest closes a change request without merging it, optionally recording the reason as a comment.
+func (g *GitBackend) RejectChangeRequest(id int, user, reason string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	request, err := g.readRequest(id)
+	if err != nil {
+		return err
+	}
+
+	if request.Status != ChangeRequestOpen {
+		return ErrChangeRequestClosed
+	}
+
+	request.Status = ChangeRequestRejected
+	request.Reviewer = user
+	request.Reviewed = time.Now()
+	if reason != "" {
+		request.Comments = append(request.Comments, &Comment{User: user, Time: request.Reviewed, Text: reason})
+	}
+	return g.writeRequest(request)
+}
+
+// readRequest loads a change request, along with the pages that it changes.
+func (g *GitBackend) readRequest(id int) (*ChangeRequest, error) {
+	metaRef, err := g.repo.Storer.Reference(requestRef(id, "meta"))
+	if err == plumbing.ErrReferenceNotFound {
+		return nil, errNoSuchChangeRequest
+	} else if err != nil {
+		return nil, err
+	}
+
+	b, err := g.readBlob(metaRef.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	request := &ChangeRequest{Id: id}
+	if err := json.Unmarshal(b, request); err != nil {
+		return nil, err
+	}
+
+	headRef, err := g.repo.Storer.Reference(requestRef(id, "head"))
+	if err != nil {
+		return nil, err
+	}
+	request.Head = headRef.Hash().String()
+
+	tip, err := g.repo.CommitObject(headRef.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	var base *object.Commit
+	if request.Base != "" {
+		if base, err = g.repo.CommitObject(plumbing.NewHash(request.Base)); err != nil {
+			return nil, err
+		}
+	}
+
+	if request.Pages, err = changedPages(base, tip); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// writeRequest stores the details of a change request, replacing any previous version.
+func (g *GitBackend) writeRequest(request *ChangeRequest) error {
+	b, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	hash, err := g.writeBlob(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	return g.repo.Storer.SetReference(plumbing.NewHashReference(requestRef(request.Id, "meta"), hash))
+}
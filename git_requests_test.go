@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mdbot/wiki/config"
+)
+
+func TestGitBackend_ApproveChangeRequest(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("page", "", []byte("one\ntwo\nthree\n"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	page, err := backend.GetPage("page")
+	if err != nil {
+		t.Fatalf("GetPage() error = %v", err)
+	}
+
+	id, err := backend.ProposePage("page", page.LastModified.ChangeId, []byte("one\ntwo\nproposed\n"), "author", "Proposal")
+	if err != nil {
+		t.Fatalf("ProposePage() error = %v", err)
+	}
+
+	// Changes made in the meantime should be merged with the proposal
+	if err := backend.PutPage("page", "", []byte("changed\ntwo\nthree\n"), "other", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	if err := backend.CommentOnChangeRequest(id, "reviewer", "Looks good"); err != nil {
+		t.Fatalf("CommentOnChangeRequest() error = %v", err)
+	}
+
+	requests, err := backend.ListChangeRequests()
+	if err != nil {
+		t.Fatalf("ListChangeRequests() error = %v", err)
+	}
+	if len(requests) != 1 || requests[0].Id != id || requests[0].Status != ChangeRequestOpen {
+		t.Fatalf("ListChangeRequests() = %v, want one open request", requests)
+	}
+	if !reflect.DeepEqual(requests[0].Pages, []string{"page"}) || len(requests[0].Comments) != 1 {
+		t.Errorf("ListChangeRequests() pages = %v, comments = %v", requests[0].Pages, requests[0].Comments)
+	}
+
+	diff, err := backend.ChangeRequestDiff(id, "page", DiffOptions{})
+	if err != nil || len(diff.Hunks) == 0 {
+		t.Errorf("ChangeRequestDiff() = %v, %v, want a diff of the proposal", diff, err)
+	}
+
+	// The proposal can only be seen through the change request until it's approved
+	for _, revision := range []string{requests[0].Head, fmt.Sprintf("refs/requests/%d/head", id)} {
+		if page, err := backend.GetPageAt("page", revision); err == nil {
+			t.Errorf("GetPageAt(%s) = %s, want an error", revision, page.Content)
+		}
+		if _, err := backend.PathDiff("page", requests[0].Base, revision, DiffOptions{}); err == nil {
+			t.Errorf("PathDiff() to %s succeeded, want an error", revision)
+		}
+	}
+
+	if err := backend.ApproveChangeRequest(id, "reviewer", ""); err != nil {
+		t.Fatalf("ApproveChangeRequest() error = %v", err)
+	}
+
+	if page, err := backend.GetPage("page"); err != nil || string(page.Content) != "changed\ntwo\nproposed\n" {
+		t.Errorf("GetPage() after approval = %v, %v, want merged content", page, err)
+	}
+
+	head, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+	if head.Author.Name != "author" || head.Committer.Name != "reviewer" || !strings.Contains(head.Message, "Approved-by: reviewer") {
+		t.Errorf("Merge commit author = %s, committer = %s, message = %q", head.Author.Name, head.Committer.Name, head.Message)
+	}
+
+	request, err := backend.GetChangeRequest(id)
+	if err != nil {
+		t.Fatalf("GetChangeRequest() error = %v", err)
+	}
+	if request.Status != ChangeRequestApproved || request.Reviewer != "reviewer" {
+		t.Errorf("GetChangeRequest() status = %s, reviewer = %s", request.Status, request.Reviewer)
+	}
+
+	if err := backend.RejectChangeRequest(id, "reviewer", ""); !errors.Is(err, ErrChangeRequestClosed) {
+		t.Errorf("RejectChangeRequest() error = %v, want ErrChangeRequestClosed", err)
+	}
+}
+
+func TestGitBackend_ApproveChangeRequestWithConflicts(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("page", "", []byte("original"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	id, err := backend.ProposePage("page", "", []byte("proposed"), "author", "")
+	if err != nil {
+		t.Fatalf("ProposePage() error = %v", err)
+	}
+
+	if err := backend.PutPage("page", "", []byte("changed"), "other", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	var conflict *MergeConflictError
+	if err := backend.ApproveChangeRequest(id, "reviewer", ""); !errors.As(err, &conflict) {
+		t.Fatalf("ApproveChangeRequest() error = %v, want MergeConflictError", err)
+	}
+
+	if err := backend.RejectChangeRequest(id, "reviewer", "Out of date"); err != nil {
+		t.Fatalf("RejectChangeRequest() error = %v", err)
+	}
+
+	request, err := backend.GetChangeRequest(id)
+	if err != nil {
+		t.Fatalf("GetChangeRequest() error = %v", err)
+	}
+	if request.Status != ChangeRequestRejected || len(request.Comments) != 1 {
+		t.Errorf("GetChangeRequest() status = %s, comments = %v", request.Status, request.Comments)
+	}
+
+	if _, err := backend.ProposePage("page", "", []byte("changed"), "author", ""); !errors.Is(err, ErrNoChanges) {
+		t.Errorf("ProposePage() error = %v, want ErrNoChanges", err)
+	}
+}
+
+func TestProtectedPathsNeedReview(t *testing.T) {
+	backend := newTestBackend(t)
+	site := &config.Site{ProtectedPaths: []string{"policies/"}}
+
+	for _, title := range []string{"policies/rules", "policies/old", "other"} {
+		if err := backend.PutPage(title, "", []byte(title), "admin", "create"); err != nil {
+			t.Fatalf("PutPage() error = %v", err)
+		}
+	}
+
+	post := func(handler http.HandlerFunc, target string, user *config.User, form url.Values) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request = request.WithContext(context.WithValue(request.Context(), contextUserKey, user))
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		return recorder
+	}
+
+	writer := &config.User{Name: "writer", Permissions: config.PermissionWrite}
+	admin := &config.User{Name: "admin", Permissions: config.PermissionAdmin}
+	submit := SubmitPageHandler(nil, backend, testUsers{writer, admin}, site)
+	rename := RenamePageHandler(nil, backend, nil, site)
+	remove := DeletePageHandler(backend, site)
+
+	tests := []struct {
+		name     string
+		response *httptest.ResponseRecorder
+	}{
+		{"edit", post(submit, "/edit/Policies/Rules", writer, url.Values{"content": {"changed"}})},
+		{"rename", post(rename, "/rename/other", writer, url.Values{"newName": {"policies/other"}})},
+		{"delete", post(remove, "/delete/policies/old", writer, url.Values{"confirm": {"yes"}})},
+	}
+	for i, tt := range tests {
+		if want := fmt.Sprintf("/wiki/requests/%d", i+1); tt.response.Code != http.StatusSeeOther || tt.response.Header().Get("Location") != want {
+			t.Errorf("%s response = %d to %s, want redirect to %s", tt.name, tt.response.Code, tt.response.Header().Get("Location"), want)
+		}
+	}
+
+	if page, err := backend.GetPage("policies/rules"); err != nil || string(page.Content) != "policies/rules" {
+		t.Errorf("GetPage() after edit = %v, %v, want it unchanged", page, err)
+	}
+	if !backend.PageExists("other") || backend.PageExists("policies/other") || !backend.PageExists("policies/old") {
+		t.Errorf("pages were renamed or deleted directly")
+	}
+
+	requests, err := backend.ListChangeRequests()
+	if err != nil {
+		t.Fatalf("ListChangeRequests() error = %v", err)
+	}
+	if len(requests) != 3 || !reflect.DeepEqual(requests[0].Pages, []string{"policies/old"}) || !reflect.DeepEqual(requests[1].Pages, []string{"other", "policies/other"}) {
+		t.Fatalf("ListChangeRequests() = %v, want an edit, rename and deletion", requests)
+	}
+	if err := backend.ApproveChangeRequest(requests[0].Id, "admin", ""); err != nil {
+		t.Fatalf("ApproveChangeRequest() error = %v", err)
+	}
+	if backend.PageExists("policies/old") {
+		t.Errorf("PageExists() after approving deletion = true")
+	}
+
+	// Admins and unprotected pages are unaffected
+	if response := post(submit, "/edit/policies/rules", admin, url.Values{"content": {"admin"}}); response.Header().Get("Location") != "/view/policies/rules" {
+		t.Errorf("admin edit redirected to %s, want the page", response.Header().Get("Location"))
+	}
+	if response := post(submit, "/edit/other", writer, url.Values{"content": {"writer"}}); response.Header().Get("Location") != "/view/other" {
+		t.Errorf("unprotected edit redirected to %s, want the page", response.Header().Get("Location"))
+	}
+	if page, err := backend.GetPage("policies/rules"); err != nil || string(page.Content) != "admin" {
+		t.Errorf("GetPage() after admin edit = %v, %v, want admin content", page, err)
+	}
+}
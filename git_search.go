@@ -4,41 +4,21 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
-	"io/fs"
-	"os"
-	"path/filepath"
+	"path"
 	"strings"
 )
 
 func (g *GitBackend) SearchWiki(pattern string) []SearchResult {
-	trimPrefix := filepath.Clean(g.dir) + string(filepath.Separator)
-	patternBytes := bytes.ToLower([]byte(pattern))
-	results := searchDirectory(g.dir, patternBytes)
-	var output []SearchResult
-	for index := range results {
-		output = append(output, SearchResult{
-			Filename:   strings.TrimSuffix(strings.TrimPrefix(results[index].Filename, trimPrefix), ".md"),
-			FoundLines: results[index].FoundLines,
-		})
-	}
-	return output
-}
-
-type SearchResult struct {
-	Filename   string
-	FoundLines []string
-}
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
 
-func searchDirectory(path string, pattern []byte) []SearchResult {
+	patternBytes := bytes.ToLower([]byte(pattern))
 	results := make([]SearchResult, 0)
-	_ = filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
-		if d.IsDir() ||
-			strings.HasPrefix(path, ".git") ||
-			strings.HasPrefix(path, ".wiki") ||
-			!strings.HasSuffix(path, ".md") {
+	_ = g.walkFiles(func(webPath string, file wikiFile) error {
+		if path.Ext(webPath) != ".md" {
 			return nil
 		}
-		result, err := searchFile(path, pattern)
+		result, err := searchFile(webPath, file, patternBytes)
 		if err == nil {
 			results = append(results, result)
 		}
@@ -47,8 +27,13 @@ func searchDirectory(path string, pattern []byte) []SearchResult {
 	return results
 }
 
-func searchFile(file string, pattern []byte) (SearchResult, error) {
-	f, err := os.Open(file)
+type SearchResult struct {
+	Filename   string
+	FoundLines []string
+}
+
+func searchFile(name string, file wikiFile, pattern []byte) (SearchResult, error) {
+	f, err := file.Open()
 	if err != nil {
 		return SearchResult{}, err
 	}
@@ -56,7 +41,7 @@ func searchFile(file string, pattern []byte) (SearchResult, error) {
 		_ = f.Close()
 	}()
 	result := SearchResult{
-		Filename:   file,
+		Filename:   strings.TrimSuffix(name, ".md"),
 		FoundLines: nil,
 	}
 	found := false
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// snapshotDateFormat is the format of dates that can be used in place of a snapshot name, to view the wiki as it
+// was at the end of that day.
+const snapshotDateFormat = "2006-01-02"
+
+var (
+	snapshotNamePattern = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*$`)
+	errNoSuchSnapshot   = &fs.PathError{Op: "open", Path: "snapshot", Err: fs.ErrNotExist}
+
+	// ErrInvalidSnapshotName is returned if a snapshot name contains anything other than lower case letters,
+	// numbers, and single dots, hyphens or underscores between them.
+	ErrInvalidSnapshotName = errors.New("invalid snapshot name")
+)
+
+// Snapshot is a named point in the wiki's history, stored as a git tag.
+type Snapshot struct {
+	Name string
+	// Revision is the change that the snapshot captures.
+	Revision string
+	// Created describes who created the snapshot, when, and why. For tags created outside the wiki without any
+	// details of their own, the details of the tagged change are used.
+	Created *LogEntry
+}
+
+// CreateSnapshot tags the given revision (or HEAD, if empty) with the given name. If a snapshot with the same name
+// already exists, an error satisfying errors.Is(err, fs.ErrExist) is returned.
+func (g *GitBackend) CreateSnapshot(name, revision, user, message string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !snapshotNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: %s", ErrInvalidSnapshotName, name)
+	}
+
+	hash, err := g.resolvePublishedRevision(revision)
+	if err != nil {
+		return err
+	}
+
+	if message == "" {
+		message = fmt.Sprintf("Snapshot %s", name)
+	}
+
+	tagger := signature(user, time.Now())
+	_, err = g.repo.CreateTag(name, *hash, &git.CreateTagOptions{
+		Tagger:  &tagger,
+		Message: message,
+	})
+	if errors.Is(err, git.ErrTagExists) {
+		return &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+	return err
+}
+
+// ListSnapshots returns all snapshots, most recently created first.
+func (g *GitBackend) ListSnapshots() ([]*Snapshot, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	tags, err := g.repo.Tags()
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []*Snapshot
+	err = tags.ForEach(func(ref *plumbing.Reference) error {
+		snapshot, err := g.snapshot(ref)
+		if err != nil {
+			return err
+		}
+		snapshots = append(snapshots, snapshot)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Created.Time.After(snapshots[j].Created.Time)
+	})
+	return snapshots, nil
+}
+
+// snapshot builds the details of the snapshot stored in the given tag, which may be annotated or lightweight.
+func (g *GitBackend) snapshot(ref *plumbing.Reference) (*Snapshot, error) {
+	commit, err := g.snapshotCommit(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{
+		Name:     ref.Name().Short(),
+		Revision: commit.Hash.String(),
+		Created: &LogEntry{
+			ChangeId: commit.Hash.String(),
+			User:     commit.Author.Name,
+			Time:     commit.Author.When,
+			Message:  commit.Message,
+		},
+	}
+
+	if tag, err := g.repo.TagObject(ref.Hash()); err == nil {
+		snapshot.Created.User = tag.Tagger.Name
+		snapshot.Created.Time = tag.Tagger.When
+		snapshot.Created.Message = tag.Message
+	}
+	return snapshot, nil
+}
+
+// snapshotCommit returns the commit that the given tag points to, peeling any annotated tags.
+func (g *GitBackend) snapshotCommit(ref *plumbing.Reference) (*object.Commit, error) {
+	tag, err := g.repo.TagObject(ref.Hash())
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return g.repo.CommitObject(ref.Hash())
+	} else if err != nil {
+		return nil, err
+	}
+	return tag.Commit()
+}
+
+// DeleteSnapshot removes the named snapshot. The changes it refers to remain part of the wiki's history.
+func (g *GitBackend) DeleteSnapshot(name string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !snapshotNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: %s", ErrInvalidSnapshotName, name)
+	}
+
+	if err := g.repo.DeleteTag(name); errors.Is(err, git.ErrTagNotFound) {
+		return errNoSuchSnapshot
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SnapshotView provides read-only access to the pages and files in the wiki as they were at a point in its history.
+type SnapshotView struct {
+	backend *GitBackend
+	commit  *object.Commit
+	// Name is the name of the snapshot, or the date, that the view was opened with.
+	Name string
+}
+
+// Snapshot opens a view of the wiki as it was at the given snapshot. If there is no snapshot with that name and it
+// is a date in the format YYYY-MM-DD, the view shows the wiki as it was at the end of that day (UTC). Otherwise, an
+// error satisfying errors.Is(err, fs.ErrNotExist) is returned.
+func (g *GitBackend) Snapshot(name string) (*SnapshotView, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	if snapshotNamePattern.MatchString(name) {
+		ref, err := g.repo.Tag(name)
+		if err == nil {
+			commit, err := g.snapshotCommit(ref)
+			if err != nil {
+				return nil, err
+			}
+			return &SnapshotView{backend: g, commit: commit, Name: name}, nil
+		} else if !errors.Is(err, git.ErrTagNotFound) {
+			return nil, err
+		}
+	}
+
+	date, err := time.Parse(snapshotDateFormat, name)
+	if err != nil {
+		return nil, errNoSuchSnapshot
+	}
+
+	commit, err := g.commitBefore(date.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	return &SnapshotView{backend: g, commit: commit, Name: name}, nil
+}
+
+// CurrentView opens a view of the wiki as it is now, so that many pages and files can be read from the same
+// revision even if the wiki is changed in the meantime.
+func (g *GitBackend) CurrentView() (*SnapshotView, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	commit, err := g.headCommit()
+	if err != nil {
+		return nil, err
+	} else if commit == nil {
+		return nil, errNoSuchSnapshot
+	}
+	return &SnapshotView{backend: g, commit: commit}, nil
+}
+
+// commitBefore finds the change that HEAD pointed to at the given time, by following first parents back from HEAD
+// until a change committed before then is found.
+func (g *GitBackend) commitBefore(when time.Time) (*object.Commit, error) {
+	commit, err := g.headCommit()
+	if err != nil {
+		return nil, err
+	}
+
+	for commit != nil && !commit.Committer.When.Before(when) {
+		if commit.NumParents() == 0 {
+			return nil, errNoSuchSnapshot
+		}
+		if commit, err = commit.Parent(0); err != nil {
+			return nil, err
+		}
+	}
+
+	if commit == nil {
+		return nil, errNoSuchSnapshot
+	}
+	return commit, nil
+}
+
+// Revision returns the change that the view shows the wiki as of.
+func (s *SnapshotView) Revision() string {
+	return s.commit.Hash.String()
+}
+
+// Time returns when the change that the view shows was made.
+func (s *SnapshotView) Time() time.Time {
+	return s.commit.Committer.When
+}
+
+// PageExists determines whether the page existed in the snapshot.
+func (s *SnapshotView) PageExists(title string) bool {
+	s.backend.mutex.RLock()
+	defer s.backend.mutex.RUnlock()
+
+	_, gitPath, err := s.backend.resolvePath(s.backend.dir, fmt.Sprintf("%s.md", title))
+	if err != nil {
+		return false
+	}
+
+	_, err = s.commit.File(gitPath)
+	return err == nil
+}
+
+// GetPage returns the content of the page as it was in the snapshot, along with the last change to it before then.
+func (s *SnapshotView) GetPage(title string) (*Page, error) {
+	s.backend.mutex.RLock()
+	defer s.backend.mutex.RUnlock()
+
+	_, gitPath, err := s.backend.resolvePath(s.backend.dir, fmt.Sprintf("%s.md", title))
+	if err != nil {
+		return nil, err
+	}
+
+	_, b, err := s.backend.pathAtRevision(gitPath, s.Revision())
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, &fs.PathError{Op: "open", Path: gitPath, Err: fs.ErrNotExist}
+	} else if err != nil {
+		return nil, err
+	}
+
+	commitIter, err := s.backend.repo.Log(&git.LogOptions{
+		From: s.commit.Hash,
+		PathFilter: func(p string) bool {
+			return p == gitPath
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	commit, err := commitIter.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Page{
+		Content: b,
+		LastModified: &LogEntry{
+			ChangeId: commit.Hash.String(),
+			User:     commit.Author.Name,
+			Time:     commit.Author.When,
+			Message:  commit.Message,
+		},
+	}, nil
+}
+
+// GetFile opens the uploaded file as it was in the snapshot.
+func (s *SnapshotView) GetFile(name string) (io.ReadCloser, error) {
+	s.backend.mutex.RLock()
+	defer s.backend.mutex.RUnlock()
+
+	_, gitPath, err := s.backend.resolvePath(s.backend.dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := s.commit.File(gitPath)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, &fs.PathError{Op: "open", Path: gitPath, Err: fs.ErrNotExist}
+	} else if err != nil {
+		return nil, err
+	}
+	return file.Reader()
+}
+
+// ListPages returns the titles of all the pages in the snapshot.
+func (s *SnapshotView) ListPages() ([]string, error) {
+	s.backend.mutex.RLock()
+	defer s.backend.mutex.RUnlock()
+
+	return listPages(s.walkFiles)
+}
+
+// ListFiles returns the names and sizes of all the uploaded files in the snapshot.
+func (s *SnapshotView) ListFiles() ([]File, error) {
+	s.backend.mutex.RLock()
+	defer s.backend.mutex.RUnlock()
+
+	return listFiles(s.walkFiles)
+}
+
+func (s *SnapshotView) walkFiles(handler func(webPath string, file wikiFile) error) error {
+	return s.backend.walkCommitFiles(s.commit, handler)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGitBackend_Snapshots(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("page", "", []byte("old"), "user", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutFile("image.png", io.NopCloser(strings.NewReader("image")), "user", "upload"); err != nil {
+		t.Fatalf("PutFile() error = %v", err)
+	}
+	if err := backend.CreateSnapshot("v1.0", "", "admin", "First release"); err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+	if err := backend.CreateSnapshot("v1.0", "", "admin", ""); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("CreateSnapshot() with existing name error = %v, want fs.ErrExist", err)
+	}
+	if err := backend.CreateSnapshot("../bad", "", "admin", ""); !errors.Is(err, ErrInvalidSnapshotName) {
+		t.Errorf("CreateSnapshot() with invalid name error = %v, want ErrInvalidSnapshotName", err)
+	}
+
+	if err := backend.PutPage("page", "", []byte("new"), "editor", "update"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutPage("later", "", []byte("later"), "editor", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.DeleteFile("image.png", "delete", "editor"); err != nil {
+		t.Fatalf("DeleteFile() error = %v", err)
+	}
+
+	snapshots, err := backend.ListSnapshots()
+	if err != nil {
+		t.Fatalf("ListSnapshots() error = %v", err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Name != "v1.0" || snapshots[0].Created.User != "admin" || snapshots[0].Created.Message != "First release\n" {
+		t.Fatalf("ListSnapshots() = %v, want v1.0 created by admin", snapshots)
+	}
+
+	view, err := backend.Snapshot("v1.0")
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	page, err := view.GetPage("page")
+	if err != nil {
+		t.Fatalf("GetPage() error = %v", err)
+	}
+	if string(page.Content) != "old" || page.LastModified.User != "user" {
+		t.Errorf("GetPage() = %q by %s, want old by user", page.Content, page.LastModified.User)
+	}
+	if view.PageExists("later") {
+		t.Error("PageExists() = true for a page created after the snapshot")
+	}
+	if _, err := view.GetPage("later"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("GetPage() for a later page error = %v, want fs.ErrNotExist", err)
+	}
+	file, err := view.GetFile("image.png")
+	if err != nil {
+		t.Fatalf("GetFile() error = %v", err)
+	}
+	_ = file.Close()
+
+	pages, err := view.ListPages()
+	if err != nil {
+		t.Fatalf("ListPages() error = %v", err)
+	}
+	if len(pages) != 1 || pages[0] != "page" {
+		t.Errorf("ListPages() = %v, want [page]", pages)
+	}
+	files, err := view.ListFiles()
+	if err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+	if len(files) != 1 || files[0].Name != "image.png" || files[0].Size != 5 {
+		t.Errorf("ListFiles() = %v, want image.png", files)
+	}
+
+	// Today's date shows the latest version, and dates before the wiki existed show nothing
+	view, err = backend.Snapshot(time.Now().UTC().Format(snapshotDateFormat))
+	if err != nil {
+		t.Fatalf("Snapshot() for today error = %v", err)
+	}
+	if !view.PageExists("later") {
+		t.Error("PageExists() = false for a page in today's snapshot")
+	}
+	if _, err := backend.Snapshot("2000-01-01"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Snapshot() before the first change error = %v, want fs.ErrNotExist", err)
+	}
+	if _, err := backend.Snapshot("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Snapshot() for unknown name error = %v, want fs.ErrNotExist", err)
+	}
+
+	if err := backend.DeleteSnapshot("v1.0"); err != nil {
+		t.Fatalf("DeleteSnapshot() error = %v", err)
+	}
+	if err := backend.DeleteSnapshot("v1.0"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("DeleteSnapshot() twice error = %v, want fs.ErrNotExist", err)
+	}
+}
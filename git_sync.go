@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+const syncRemoteName = "origin"
+
+var errSyncConflict = errors.New("remote repository contains conflicting changes")
+
+// SyncStatus describes the state of synchronisation with a remote repository.
+type SyncStatus struct {
+	Remote    string
+	LastSync  time.Time
+	LastError string
+	Conflicts []string
+}
+
+// configureRemote ensures the sync remote exists and points at the given URL.
+func (g *GitBackend) configureRemote(url string) error {
+	remote, err := g.repo.Remote(syncRemoteName)
+	if err == nil {
+		if urls := remote.Config().URLs; len(urls) == 1 && urls[0] == url {
+			return nil
+		}
+		if err := g.repo.DeleteRemote(syncRemoteName); err != nil {
+			return err
+		}
+	} else if err != git.ErrRemoteNotFound {
+		return err
+	}
+
+	_, err = g.repo.CreateRemote(&gitconfig.RemoteConfig{
+		Name: syncRemoteName,
+		URLs: []string{url},
+	})
+	return err
+}
+
+// syncPeriodically calls Sync every interval, until the backend is closed.
+func (g *GitBackend) syncPeriodically(interval time.Duration) {
+	defer g.background.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.closed:
+			return
+		case <-ticker.C:
+			if err := g.Sync(); err != nil {
+				log.Printf("Unable to synchronise with remote repository: %v", err)
+			}
+		}
+	}
+}
+
+// publishPeriodically pushes local changes to the remote whenever publish reports there are some, until the
+// backend is closed. If the push fails (most likely because someone else has pushed in the meantime) a full sync
+// is attempted. Errors are logged and recorded rather than returned, as the changes have already been committed
+// locally.
+func (g *GitBackend) publishPeriodically() {
+	defer g.background.Done()
+
+	for {
+		select {
+		case <-g.closed:
+			return
+		case <-g.unpublished:
+			g.network.Lock()
+			err := g.push()
+			g.network.Unlock()
+
+			if err == nil {
+				g.mutex.Lock()
+				g.recordSync(nil)
+				g.mutex.Unlock()
+			} else if err := g.sync(conflictFail, "system"); err != nil {
+				log.Printf("Unable to publish changes to remote repository: %v", err)
+			}
+		}
+	}
+}
+
+// Sync fetches changes from the remote repository, merges them into the local branch, and pushes the result back.
+// If the same file has been changed both locally and remotely the merge is abandoned, the local branch is left
+// untouched, and the conflicting files are reported in the SyncStatus.
+func (g *GitBackend) Sync() error {
+	return g.sync(conflictFail, "system")
+}
+
+// ResolveSyncConflicts merges changes from the remote repository, resolving any conflicting files by keeping either
+// the local or remote version in their entirety.
+func (g *GitBackend) ResolveSyncConflicts(keepLocal bool, user string) error {
+	if keepLocal {
+		return g.sync(conflictOurs, user)
+	}
+	return g.sync(conflictTheirs, user)
+}
+
+// SyncStatus returns details of the last synchronisation with the remote repository, or nil if no remote has
+// been configured.
+func (g *GitBackend) SyncStatus() *SyncStatus {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	if g.remote == "" {
+		return nil
+	}
+
+	status := g.syncStatus
+	status.Conflicts = append([]string(nil), g.syncStatus.Conflicts...)
+	return &status
+}
+
+// publish arranges for newly committed changes to be pushed to the remote repository, if one is configured. The
+// push happens in the background, so callers can keep holding the lock.
+func (g *GitBackend) publish() {
+	if g.remote == "" {
+		return
+	}
+
+	select {
+	case g.unpublished <- struct{}{}:
+	default:
+		// A push is already waiting, and will include these changes
+	}
+}
+
+// sync fetches and merges changes from the remote, then pushes the result. Unlike most unexported methods it must
+// be called without holding the lock: the lock is only taken while the local branch is examined and updated, not
+// while waiting on the network.
+func (g *GitBackend) sync(strategy conflictStrategy, user string) error {
+	if g.remote == "" {
+		return nil
+	}
+
+	g.network.Lock()
+	defer g.network.Unlock()
+
+	err := g.fetchAndMerge(strategy, user)
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.recordSync(err)
+	return err
+}
+
+func (g *GitBackend) recordSync(err error) {
+	g.syncStatus.LastSync = time.Now()
+	if err == nil {
+		g.syncStatus.LastError = ""
+		g.syncStatus.Conflicts = nil
+	} else {
+		g.syncStatus.LastError = err.Error()
+		if err != errSyncConflict {
+			g.syncStatus.Conflicts = nil
+		}
+	}
+}
+
+// fetchAndMerge fetches from the remote, then merges the remote branch into the local one, and pushes the result
+// if the remote is now behind. It must be called holding the network lock, but not the main lock.
+func (g *GitBackend) fetchAndMerge(strategy conflictStrategy, user string) error {
+	err := g.repo.Fetch(&git.FetchOptions{RemoteName: syncRemoteName})
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return g.push()
+	} else if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("unable to fetch: %w", err)
+	}
+
+	needsPush, err := g.mergeFetched(strategy, user)
+	if err != nil || !needsPush {
+		return err
+	}
+	return g.push()
+}
+
+// mergeFetched merges the fetched remote branch into the local one, and reports whether the remote needs to be
+// updated afterwards. HEAD is only read once the fetch has finished, so changes made while it was in progress
+// are included.
+func (g *GitBackend) mergeFetched(strategy conflictStrategy, user string) (bool, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	branch, err := g.headBranch()
+	if err != nil {
+		return false, err
+	}
+
+	remoteRef, err := g.repo.Reference(plumbing.NewRemoteReferenceName(syncRemoteName, branch.Short()), true)
+	if err == plumbing.ErrReferenceNotFound {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	remote, err := g.repo.CommitObject(remoteRef.Hash())
+	if err != nil {
+		return false, err
+	}
+
+	local, err := g.headCommit()
+	if err != nil {
+		return false, err
+	}
+
+	if local == nil {
+		return false, g.moveHead(nil, remote)
+	}
+
+	if local.Hash == remote.Hash {
+		return false, nil
+	}
+
+	if behind, err := remote.IsAncestor(local); err != nil {
+		return false, err
+	} else if behind {
+		return true, nil
+	}
+
+	if ahead, err := local.IsAncestor(remote); err != nil {
+		return false, err
+	} else if ahead {
+		return false, g.moveHead(local, remote)
+	}
+
+	if err := g.mergeCommits(local, remote, strategy, user); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// mergeCommits merges the remote commit into the local branch.
+func (g *GitBackend) mergeCommits(local, remote *object.Commit, strategy conflictStrategy, user string) error {
+	conflicts, err := g.mergeIntoHead(local, remote, strategy, user, user, "Merge changes from remote repository")
+	if err != nil {
+		return err
+	}
+
+	if len(conflicts) > 0 {
+		g.syncStatus.Conflicts = conflicts
+		return errSyncConflict
+	}
+	return nil
+}
+
+// push pushes the local branch to the remote. It must be called holding the network lock, but not the main lock;
+// the commit to push is read under the lock, and pushed by hash so that later changes to the branch don't matter.
+func (g *GitBackend) push() error {
+	g.mutex.RLock()
+	branch, err := g.headBranch()
+	var head *object.Commit
+	if err == nil {
+		head, err = g.headCommit()
+	}
+	g.mutex.RUnlock()
+
+	if err != nil || head == nil {
+		// Nothing to push yet
+		return err
+	}
+
+	err = g.repo.Push(&git.PushOptions{
+		RemoteName: syncRemoteName,
+		RefSpecs:   []gitconfig.RefSpec{gitconfig.RefSpec(fmt.Sprintf("%s:%s", head.Hash, branch))},
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("unable to push: %w", err)
+	}
+	return nil
+}
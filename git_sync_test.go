@@ -0,0 +1,200 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// newSyncedBackend creates a bare repository to act as a remote, and a backend that synchronises with it.
+func newSyncedBackend(t *testing.T) (*GitBackend, string) {
+	t.Helper()
+
+	// go-git uses the git binary to talk to local remotes
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+
+	remoteDir := t.TempDir()
+	if _, err := git.PlainInit(remoteDir, true); err != nil {
+		t.Fatalf("Unable to create remote repository: %v", err)
+	}
+
+	return newTestBackendWithOptions(t, GitOptions{Remote: remoteDir}), remoteDir
+}
+
+// waitForPublish waits for the backend's HEAD to be pushed to the remote in the background.
+func waitForPublish(t *testing.T, backend *GitBackend, remoteDir string) {
+	t.Helper()
+
+	head, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+
+	repo, err := git.PlainOpen(remoteDir)
+	if err != nil {
+		t.Fatalf("Unable to open remote: %v", err)
+	}
+
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if ref, err := repo.Head(); err == nil && ref.Hash() == head.Hash {
+			return
+		}
+	}
+	t.Fatalf("HEAD %s wasn't pushed to the remote", head.Hash)
+}
+
+// commitToRemote clones the remote, writes the given file, and pushes the change back.
+func commitToRemote(t *testing.T, remoteDir, name, content string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	repo, err := git.PlainClone(dir, false, &git.CloneOptions{URL: remoteDir})
+	if err != nil {
+		t.Fatalf("Unable to clone remote: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), os.FileMode(0644)); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("Unable to get worktree: %v", err)
+	}
+
+	if _, err := worktree.Add(name); err != nil {
+		t.Fatalf("Unable to add file: %v", err)
+	}
+
+	if _, err := worktree.Commit("Remote change", &git.CommitOptions{
+		Author: &object.Signature{Name: "remote", Email: "remote@example.com", When: time.Now()},
+	}); err != nil {
+		t.Fatalf("Unable to commit: %v", err)
+	}
+
+	if err := repo.Push(&git.PushOptions{}); err != nil {
+		t.Fatalf("Unable to push: %v", err)
+	}
+}
+
+func remoteHead(t *testing.T, remoteDir string) string {
+	t.Helper()
+
+	repo, err := git.PlainOpen(remoteDir)
+	if err != nil {
+		t.Fatalf("Unable to open remote: %v", err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatalf("Unable to resolve remote HEAD: %v", err)
+	}
+	return head.Hash().String()
+}
+
+func TestGitBackend_PushesChanges(t *testing.T) {
+	backend, remoteDir := newSyncedBackend(t)
+
+	if err := backend.PutPage("test", "", []byte("content"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	waitForPublish(t, backend, remoteDir)
+}
+
+func TestGitBackend_SyncMergesRemoteChanges(t *testing.T) {
+	backend, remoteDir := newSyncedBackend(t)
+
+	if err := backend.PutPage("local", "", []byte("local content"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	waitForPublish(t, backend, remoteDir)
+
+	commitToRemote(t, remoteDir, "remote.md", "remote content")
+
+	if err := backend.PutPage("another", "", []byte("more content"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	page, err := backend.GetPage("remote")
+	if err != nil {
+		t.Fatalf("GetPage() error = %v", err)
+	}
+
+	if string(page.Content) != "remote content" {
+		t.Errorf("GetPage() content = %s, want remote content", page.Content)
+	}
+
+	head, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+
+	if head.NumParents() != 2 {
+		t.Errorf("HEAD has %d parents, want a merge commit", head.NumParents())
+	}
+
+	if got := remoteHead(t, remoteDir); got != head.Hash.String() {
+		t.Errorf("remote HEAD = %s, want %s", got, head.Hash)
+	}
+}
+
+func TestGitBackend_SyncReportsConflicts(t *testing.T) {
+	backend, remoteDir := newSyncedBackend(t)
+
+	if err := backend.PutPage("page", "", []byte("original"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	waitForPublish(t, backend, remoteDir)
+
+	commitToRemote(t, remoteDir, "page.md", "remote version")
+
+	if err := backend.PutPage("page", "", []byte("local version"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	if err := backend.Sync(); err != errSyncConflict {
+		t.Fatalf("Sync() error = %v, want %v", err, errSyncConflict)
+	}
+
+	status := backend.SyncStatus()
+	if len(status.Conflicts) != 1 || status.Conflicts[0] != "page.md" {
+		t.Errorf("SyncStatus() conflicts = %v, want [page.md]", status.Conflicts)
+	}
+
+	page, err := backend.GetPage("page")
+	if err != nil {
+		t.Fatalf("GetPage() error = %v", err)
+	}
+
+	if string(page.Content) != "local version" {
+		t.Errorf("GetPage() content = %s, want local version", page.Content)
+	}
+
+	if err := backend.ResolveSyncConflicts(false, "admin"); err != nil {
+		t.Fatalf("ResolveSyncConflicts() error = %v", err)
+	}
+
+	page, err = backend.GetPage("page")
+	if err != nil {
+		t.Fatalf("GetPage() error = %v", err)
+	}
+
+	if string(page.Content) != "remote version" {
+		t.Errorf("GetPage() content = %s, want remote version", page.Content)
+	}
+
+	if status := backend.SyncStatus(); len(status.Conflicts) != 0 {
+		t.Errorf("SyncStatus() conflicts = %v, want none", status.Conflicts)
+	}
+}
@@ -1,10 +1,106 @@
 package main
 
 import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
+// newTestBackend creates a backend in a temporary directory, which is closed when the test finishes.
+func newTestBackend(t *testing.T) *GitBackend {
+	t.Helper()
+
+	return newTestBackendWithOptions(t, GitOptions{})
+}
+
+func newTestBackendWithOptions(t *testing.T, options GitOptions) *GitBackend {
+	t.Helper()
+
+	backend, err := NewGitBackend(t.TempDir(), options)
+	if err != nil {
+		t.Fatalf("Unable to create backend: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = backend.Close()
+	})
+	return backend
+}
+
+func TestGitBackend_Bare(t *testing.T) {
+	backend := newTestBackendWithOptions(t, GitOptions{Bare: true})
+	if !backend.bare {
+		t.Fatalf("NewGitBackend() didn't create a bare repository")
+	}
+
+	if err := backend.PutPage("notes/first", "", []byte("some content"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutPage("second", "", []byte("other content"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutFile("image.png", io.NopCloser(strings.NewReader("image")), "user", "message"); err != nil {
+		t.Fatalf("PutFile() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(backend.dir, "second.md")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Page was written to the data directory of a bare repository: %v", err)
+	}
+
+	page, err := backend.GetPage("notes/first")
+	if err != nil {
+		t.Fatalf("GetPage() error = %v", err)
+	}
+	if string(page.Content) != "some content" {
+		t.Errorf("GetPage() = %q, want some content", page.Content)
+	}
+
+	pages, err := backend.ListPages()
+	if err != nil {
+		t.Fatalf("ListPages() error = %v", err)
+	}
+	if !reflect.DeepEqual(pages, []string{"notes/first", "second"}) {
+		t.Errorf("ListPages() = %v", pages)
+	}
+
+	files, err := backend.ListFiles()
+	if err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+	if len(files) != 1 || files[0].Name != "image.png" {
+		t.Errorf("ListFiles() = %v", files)
+	}
+
+	results := backend.SearchWiki("other")
+	if len(results) != 1 || results[0].Filename != "second" {
+		t.Errorf("SearchWiki() = %v", results)
+	}
+
+	if err := backend.RenamePage("second", "third", "", "user", false); err != nil {
+		t.Fatalf("RenamePage() error = %v", err)
+	}
+	if backend.PageExists("second") || !backend.PageExists("third") {
+		t.Errorf("RenamePage() didn't move the page")
+	}
+
+	if err := backend.DeletePage("third", "", "user"); err != nil {
+		t.Fatalf("DeletePage() error = %v", err)
+	}
+	if err := backend.DeleteFile("image.png", "", "user"); err != nil {
+		t.Fatalf("DeleteFile() error = %v", err)
+	}
+	if backend.PageExists("third") {
+		t.Errorf("PageExists() = true after DeletePage()")
+	}
+	if files, err := backend.ListFiles(); err != nil || len(files) != 0 {
+		t.Errorf("ListFiles() after DeleteFile() = %v, %v", files, err)
+	}
+}
+
 func Test_resolvePath(t *testing.T) {
 	type args struct {
 		base  string
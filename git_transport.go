@@ -0,0 +1,473 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+const (
+	UploadPackService  = "git-upload-pack"
+	ReceivePackService = "git-receive-pack"
+)
+
+// AdvertiseReferences writes the list of references and capabilities that git clients request before fetching
+// from or pushing to the wiki, prefixed with the service announcement used by the smart HTTP protocol.
+func (g *GitBackend) AdvertiseReferences(service string, w io.Writer) error {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	ar := packp.NewAdvRefs()
+	ar.Prefix = [][]byte{[]byte(fmt.Sprintf("# service=%s", service)), pktline.Flush}
+
+	caps := []capability.Capability{capability.OFSDelta}
+	if service == UploadPackService {
+		caps = append(caps, capability.MultiACKDetailed)
+	} else {
+		caps = append(caps, capability.ReportStatus)
+	}
+	for i := range caps {
+		if err := ar.Capabilities.Set(caps[i]); err != nil {
+			return err
+		}
+	}
+	if err := ar.Capabilities.Set(capability.Agent, capability.DefaultAgent()); err != nil {
+		return err
+	}
+
+	refs, err := g.advertisedReferences()
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		ar.References[ref.Name().String()] = ref.Hash()
+	}
+
+	if branch, err := g.headBranch(); err == nil {
+		if hash, ok := ar.References[branch.String()]; ok && service == UploadPackService {
+			ar.Head = &hash
+			if err := ar.Capabilities.Add(capability.SymRef, fmt.Sprintf("%s:%s", plumbing.HEAD, branch)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return ar.Encode(w)
+}
+
+// advertisedReferences returns the references that git clients may fetch: branches and tags. Drafts are deliberately
+// left out, as they should only be visible to editors, as are change requests.
+func (g *GitBackend) advertisedReferences() ([]*plumbing.Reference, error) {
+	refs, err := g.repo.Storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	var advertised []*plumbing.Reference
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference && (ref.Name().IsBranch() || ref.Name().IsTag()) && !isDraftBranch(ref.Name()) {
+			advertised = append(advertised, ref)
+		}
+		return nil
+	})
+	return advertised, err
+}
+
+// UploadPack handles a request from a git client to fetch objects from the wiki. As with the smart HTTP protocol,
+// each request is stateless: if the client hasn't yet finished negotiating which objects it already has, only
+// acknowledgements are sent; otherwise a packfile with all the requested objects is written. Only objects
+// reachable from the advertised references can be requested. The lock is only held while working out which
+// objects to send, not while talking to the client, so a slow client can't hold up the wiki.
+func (g *GitBackend) UploadPack(r io.Reader, w io.Writer) error {
+	req := packp.NewUploadRequest()
+	if err := req.Decode(r); err != nil {
+		return err
+	}
+
+	if len(req.Shallows) > 0 || !req.Depth.IsZero() {
+		return errors.New("shallow clones are not supported")
+	}
+
+	var haves []plumbing.Hash
+	done := false
+	scanner := pktline.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(string(scanner.Bytes()), "\n")
+		if line == "done" {
+			done = true
+			break
+		}
+
+		if have, ok := strings.CutPrefix(line, "have "); ok {
+			haves = append(haves, plumbing.NewHash(have))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	e := pktline.NewEncoder(w)
+	common, err := g.negotiateUpload(req.Wants, haves)
+	var unreachable *unreachableWantError
+	if errors.As(err, &unreachable) {
+		_ = e.Encodef("ERR upload-pack: %s\n", unreachable)
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	if !done {
+		if req.Capabilities.Supports(capability.MultiACKDetailed) {
+			for i := range common {
+				if err := e.Encodef("ACK %s common\n", common[i]); err != nil {
+					return err
+				}
+			}
+			if len(common) > 0 {
+				if err := e.Encodef("ACK %s ready\n", common[len(common)-1]); err != nil {
+					return err
+				}
+			}
+		} else if len(common) > 0 {
+			return e.Encodef("ACK %s\n", common[0])
+		}
+		return e.EncodeString("NAK\n")
+	}
+
+	if len(common) > 0 {
+		if err := e.Encodef("ACK %s\n", common[len(common)-1]); err != nil {
+			return err
+		}
+	} else if err := e.EncodeString("NAK\n"); err != nil {
+		return err
+	}
+
+	// Objects are never changed once written, so the pack can be built without holding the lock
+	haveObjects, err := revlist.Objects(g.repo.Storer, common, nil)
+	if err != nil {
+		return err
+	}
+
+	objects, err := revlist.Objects(g.repo.Storer, req.Wants, haveObjects)
+	if err != nil {
+		return err
+	}
+
+	_, err = packfile.NewEncoder(w, g.repo.Storer, false).Encode(objects, 10)
+	return err
+}
+
+// unreachableWantError is returned when a git client asks for an object that it wasn't shown.
+type unreachableWantError struct {
+	hash plumbing.Hash
+}
+
+func (e *unreachableWantError) Error() string {
+	return fmt.Sprintf("not our ref %s", e.hash)
+}
+
+// negotiateUpload checks that every object a client wants is reachable from the advertised references, so that
+// drafts and change requests can't be fetched by their hashes, and returns the commits that the client has which
+// the wiki also has.
+func (g *GitBackend) negotiateUpload(wants, haves []plumbing.Hash) ([]plumbing.Hash, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	refs, err := g.advertisedReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make(map[plumbing.Hash]bool)
+	for i := range wants {
+		pending[wants[i]] = true
+	}
+
+	var tips []*object.Commit
+	for _, ref := range refs {
+		delete(pending, ref.Hash())
+		if commit, err := g.snapshotCommit(ref); err == nil {
+			tips = append(tips, commit)
+		}
+	}
+
+	// Clients normally only want the advertised references themselves, so history is only walked for older commits
+	seen := make(map[plumbing.Hash]bool)
+	for _, tip := range tips {
+		if len(pending) == 0 {
+			break
+		}
+
+		err := object.NewCommitPreorderIter(tip, seen, nil).ForEach(func(commit *object.Commit) error {
+			seen[commit.Hash] = true
+			delete(pending, commit.Hash)
+			if len(pending) == 0 {
+				return storer.ErrStop
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for hash := range pending {
+		return nil, &unreachableWantError{hash: hash}
+	}
+
+	var common []plumbing.Hash
+	for i := range haves {
+		if _, err := g.repo.CommitObject(haves[i]); err == nil {
+			common = append(common, haves[i])
+		}
+	}
+	return common, nil
+}
+
+// ReceivePack handles a push from a git client. Only fast-forward updates to the wiki's current branch are
+// accepted, and every new commit is checked to make sure it only changes files that could have been changed
+// through the wiki itself. Accepted changes are checked out and published as if they had been made in the wiki.
+// The pushed objects are received and stored before taking the lock, which is only needed to check and move HEAD.
+func (g *GitBackend) ReceivePack(r io.Reader, w io.Writer) error {
+	req := packp.NewReferenceUpdateRequest()
+	if err := req.Decode(r); err != nil {
+		return err
+	}
+
+	status := packp.NewReportStatus()
+	status.UnpackStatus = "ok"
+	if req.Packfile != nil {
+		if err := g.receivePackfile(req.Packfile); err != nil {
+			status.UnpackStatus = err.Error()
+		}
+	}
+
+	updated := false
+	g.mutex.Lock()
+	for _, cmd := range req.Commands {
+		err := errors.New("unpacker error")
+		if status.UnpackStatus == "ok" {
+			err = g.receiveCommand(cmd)
+		}
+
+		msg := "ok"
+		if err != nil {
+			msg = err.Error()
+		} else {
+			updated = true
+		}
+		status.CommandStatuses = append(status.CommandStatuses, &packp.CommandStatus{
+			ReferenceName: cmd.Name,
+			Status:        msg,
+		})
+	}
+	g.mutex.Unlock()
+
+	if updated {
+		g.publish()
+	}
+
+	if !req.Capabilities.Supports(capability.ReportStatus) {
+		return nil
+	}
+	return status.Encode(w)
+}
+
+// receivePackfile stores the objects in a pushed packfile. The whole pack is read from the client into a temporary
+// file first, then added to the repository under the network lock, as objects fetched from the remote are.
+func (g *GitBackend) receivePackfile(pack io.Reader) error {
+	file, err := os.CreateTemp("", "wiki-push-*.pack")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if _, err := io.Copy(file, pack); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	g.network.Lock()
+	defer g.network.Unlock()
+
+	return packfile.UpdateObjectStorage(g.repo.Storer, file)
+}
+
+func (g *GitBackend) receiveCommand(cmd *packp.Command) error {
+	branch, err := g.headBranch()
+	if err != nil {
+		return err
+	}
+
+	if cmd.Name != branch {
+		return fmt.Errorf("only %s can be pushed to", branch.Short())
+	}
+
+	if cmd.Action() == packp.Delete {
+		return errors.New("deletion prohibited")
+	}
+
+	head, err := g.headCommit()
+	if err != nil {
+		return err
+	}
+
+	var oldHash plumbing.Hash
+	if head != nil {
+		oldHash = head.Hash
+	}
+	if cmd.Old != oldHash {
+		return errors.New("fetch first")
+	}
+
+	commit, err := g.repo.CommitObject(cmd.New)
+	if err != nil {
+		return err
+	}
+
+	if head != nil {
+		if ff, err := head.IsAncestor(commit); err != nil {
+			return err
+		} else if !ff {
+			return errors.New("non-fast-forward")
+		}
+	}
+
+	if err := g.validatePush(head, commit); err != nil {
+		return err
+	}
+
+	return g.moveHead(head, commit)
+}
+
+// validatePush checks every commit reachable from the new commit, but not from the old one.
+func (g *GitBackend) validatePush(old, new *object.Commit) error {
+	seen := make(map[plumbing.Hash]bool)
+	if old != nil {
+		err := object.NewCommitPreorderIter(old, nil, nil).ForEach(func(c *object.Commit) error {
+			seen[c.Hash] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return object.NewCommitPreorderIter(new, seen, nil).ForEach(g.validatePushedCommit)
+}
+
+// validatePushedCommit makes sure the files introduced by a commit are ones that the wiki could have written.
+// For merge commits, only files that differ from every parent are checked, so merging in history that contains
+// changes to the wiki's own config is allowed.
+func (g *GitBackend) validatePushedCommit(c *object.Commit) error {
+	var parentTrees []*object.Tree
+	err := c.Parents().ForEach(func(parent *object.Commit) error {
+		tree, err := parent.Tree()
+		if err != nil {
+			return err
+		}
+		parentTrees = append(parentTrees, tree)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return err
+	}
+
+	var first *object.Tree
+	if len(parentTrees) > 0 {
+		first = parentTrees[0]
+	}
+
+	changes, err := object.DiffTree(first, tree)
+	if err != nil {
+		return err
+	}
+
+	for i := range changes {
+		name := changes[i].To.Name
+		if name == "" {
+			name = changes[i].From.Name
+		}
+
+		if introduced, err := introducedBy(tree, parentTrees[min(1, len(parentTrees)):], name); err != nil {
+			return err
+		} else if !introduced {
+			continue
+		}
+
+		if err := g.validatePushedPath(changes[i].From); err != nil {
+			return fmt.Errorf("commit %s: %w", c.Hash, err)
+		}
+		if err := g.validatePushedPath(changes[i].To); err != nil {
+			return fmt.Errorf("commit %s: %w", c.Hash, err)
+		}
+	}
+	return nil
+}
+
+func (g *GitBackend) validatePushedPath(entry object.ChangeEntry) error {
+	if entry.Name == "" {
+		return nil
+	}
+
+	_, gitPath, err := g.resolvePath(g.dir, entry.Name)
+	if err != nil {
+		return fmt.Errorf("invalid path %s: %w", entry.Name, err)
+	}
+
+	if gitPath != entry.Name {
+		return fmt.Errorf("invalid path %s: paths must be lower case", entry.Name)
+	}
+
+	if mode := entry.TreeEntry.Mode; mode != filemode.Regular && mode != filemode.Executable {
+		return fmt.Errorf("invalid file %s: only regular files are allowed", entry.Name)
+	}
+	return nil
+}
+
+// introducedBy determines whether the file at the given path differs from its version in all the other trees.
+func introducedBy(tree *object.Tree, others []*object.Tree, name string) (bool, error) {
+	entry, err := tree.FindEntry(name)
+	if err != nil && err != object.ErrEntryNotFound && err != object.ErrDirectoryNotFound {
+		return false, err
+	}
+
+	for i := range others {
+		other, err := others[i].FindEntry(name)
+		if err != nil && err != object.ErrEntryNotFound && err != object.ErrDirectoryNotFound {
+			return false, err
+		}
+
+		if sameEntry(derefEntry(entry), entry != nil, derefEntry(other), other != nil) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func derefEntry(entry *object.TreeEntry) object.TreeEntry {
+	if entry == nil {
+		return object.TreeEntry{}
+	}
+	return *entry
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/gorilla/mux"
+	"github.com/mdbot/wiki/config"
+)
+
+// newGitServer creates a backend and serves it over smart HTTP with the same permissions as the wiki, returning the
+// URL to clone as an admin. There is also a read-only user called reader; every user's password is "password".
+func newGitServer(t *testing.T) (*GitBackend, string) {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+
+	backend := newTestBackend(t)
+
+	users, err := config.NewUserManager(config.NewStore(backend, strings.Repeat("ab", 32)))
+	if err != nil {
+		t.Fatalf("NewUserManager() error = %v", err)
+	}
+	if err := users.AddUser("admin", "password", "test"); err != nil {
+		t.Fatalf("AddUser() error = %v", err)
+	}
+	if err := users.AddUser("reader", "password", "test"); err != nil {
+		t.Fatalf("AddUser() error = %v", err)
+	}
+	if err := users.SetPermission("reader", config.PermissionRead, "test"); err != nil {
+		t.Fatalf("SetPermission() error = %v", err)
+	}
+
+	if err := backend.PutPage("page", "", []byte("content"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	pm := &PermissionChecker{requireAuthForWrites: true}
+	router := mux.NewRouter()
+	router.Use(BasicAuthHandler(users))
+	router.Path("/wiki.git/info/refs").Queries("service", UploadPackService).Handler(pm.RequireAdmin(GitInfoRefsHandler(backend, UploadPackService)))
+	router.Path("/wiki.git/info/refs").Queries("service", ReceivePackService).Handler(pm.RequireWrite(GitInfoRefsHandler(backend, ReceivePackService)))
+	router.Path("/wiki.git/" + UploadPackService).Handler(pm.RequireAdmin(GitUploadPackHandler(backend)))
+	router.Path("/wiki.git/" + ReceivePackService).Handler(pm.RequireWrite(GitReceivePackHandler(backend)))
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	return backend, gitURL(t, server.URL+"/wiki.git", "admin")
+}
+
+// gitURL adds the credentials of the given test user to a URL.
+func gitURL(t *testing.T, rawURL, username string) string {
+	t.Helper()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("Unable to parse URL: %v", err)
+	}
+	u.User = url.UserPassword(username, "password")
+	return u.String()
+}
+
+func runGit(t *testing.T, dir string, args ...string) (string, error) {
+	t.Helper()
+
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func commitFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), os.FileMode(0755)); err != nil {
+		t.Fatalf("Unable to create directory: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), os.FileMode(0644)); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+
+	if out, err := runGit(t, dir, "add", "."); err != nil {
+		t.Fatalf("git add failed: %v\n%s", err, out)
+	}
+
+	if out, err := runGit(t, dir, "commit", "-m", "Change "+name); err != nil {
+		t.Fatalf("git commit failed: %v\n%s", err, out)
+	}
+}
+
+func TestGitBackend_CloneAndPush(t *testing.T) {
+	backend, url := newGitServer(t)
+
+	dir := t.TempDir()
+	if out, err := runGit(t, dir, "clone", url, "."); err != nil {
+		t.Fatalf("git clone failed: %v\n%s", err, out)
+	}
+
+	if b, err := os.ReadFile(filepath.Join(dir, "page.md")); err != nil || string(b) != "content" {
+		t.Fatalf("Cloned page = %q (%v), want content", b, err)
+	}
+
+	commitFile(t, dir, "pushed.md", "pushed content")
+	if out, err := runGit(t, dir, "push"); err != nil {
+		t.Fatalf("git push failed: %v\n%s", err, out)
+	}
+
+	page, err := backend.GetPage("pushed")
+	if err != nil {
+		t.Fatalf("GetPage() error = %v", err)
+	}
+
+	if string(page.Content) != "pushed content" {
+		t.Errorf("GetPage() content = %s, want pushed content", page.Content)
+	}
+
+	// Fetching again should negotiate using the objects we already have
+	if err := backend.PutPage("page", "", []byte("updated"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	if out, err := runGit(t, dir, "pull"); err != nil {
+		t.Fatalf("git pull failed: %v\n%s", err, out)
+	}
+
+	if b, err := os.ReadFile(filepath.Join(dir, "page.md")); err != nil || string(b) != "updated" {
+		t.Errorf("Pulled page = %q (%v), want updated", b, err)
+	}
+}
+
+func TestGitBackend_RejectsInvalidPushes(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+	}{
+		{"config", ".wiki/users.json.enc"},
+		{"upper case", "Page.md"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, url := newGitServer(t)
+
+			before, err := backend.headCommit()
+			if err != nil {
+				t.Fatalf("headCommit() error = %v", err)
+			}
+
+			dir := t.TempDir()
+			if out, err := runGit(t, dir, "clone", url, "."); err != nil {
+				t.Fatalf("git clone failed: %v\n%s", err, out)
+			}
+
+			commitFile(t, dir, tt.file, "content")
+			commitFile(t, dir, "valid.md", "content")
+			out, err := runGit(t, dir, "push")
+			if err == nil {
+				t.Fatalf("git push succeeded, want rejection")
+			}
+
+			if !strings.Contains(out, tt.file) {
+				t.Errorf("git push output doesn't mention %s:\n%s", tt.file, out)
+			}
+
+			after, err := backend.headCommit()
+			if err != nil {
+				t.Fatalf("headCommit() error = %v", err)
+			}
+
+			if after.Hash != before.Hash {
+				t.Errorf("Rejected push moved HEAD")
+			}
+		})
+	}
+}
+
+func TestGitBackend_CloneRequiresAdmin(t *testing.T) {
+	_, adminURL := newGitServer(t)
+
+	anonymous, err := url.Parse(adminURL)
+	if err != nil {
+		t.Fatalf("Unable to parse URL: %v", err)
+	}
+	anonymous.User = nil
+
+	dir := t.TempDir()
+	for name, cloneURL := range map[string]string{"anonymous": anonymous.String(), "reader": gitURL(t, adminURL, "reader")} {
+		out, err := runGit(t, dir, "clone", cloneURL, name)
+		if err == nil {
+			t.Errorf("git clone as %s succeeded, want it refused", name)
+		}
+		if _, err := os.Stat(filepath.Join(dir, name, ".wiki", "users.json.enc")); err == nil {
+			t.Errorf("git clone as %s fetched the users:\n%s", name, out)
+		}
+	}
+
+	if out, err := runGit(t, dir, "clone", adminURL, "admin"); err != nil {
+		t.Fatalf("git clone as admin failed: %v\n%s", err, out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "admin", ".wiki", "users.json.enc")); err != nil {
+		t.Errorf("Admin clone is missing the users: %v", err)
+	}
+}
+
+func TestGitBackend_UploadPackRefusesUnadvertisedObjects(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("page", "", []byte("published"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutDraftPage("draft", "page", []byte("secret"), "user", "message"); err != nil {
+		t.Fatalf("PutDraftPage() error = %v", err)
+	}
+
+	head, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+	drafts, err := backend.ListDrafts()
+	if err != nil || len(drafts) != 1 {
+		t.Fatalf("ListDrafts() = %v, %v", drafts, err)
+	}
+
+	upload := func(want plumbing.Hash) error {
+		var body bytes.Buffer
+		req := packp.NewUploadRequest()
+		req.Wants = []plumbing.Hash{want}
+		if err := req.Encode(&body); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+		if err := pktline.NewEncoder(&body).EncodeString("done\n"); err != nil {
+			t.Fatalf("EncodeString() error = %v", err)
+		}
+		return backend.UploadPack(&body, io.Discard)
+	}
+
+	if err := upload(head.Hash); err != nil {
+		t.Errorf("UploadPack() of HEAD error = %v", err)
+	}
+	if err := upload(plumbing.NewHash(drafts[0].LastModified.ChangeId)); err == nil {
+		t.Errorf("UploadPack() of a draft succeeded, want an error")
+	}
+}
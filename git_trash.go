@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+// DeletedItem describes a page or file that has been deleted and not since recreated.
+type DeletedItem struct {
+	// Name is the page title, or the file name.
+	Name   string
+	IsPage bool
+	// Deleted describes the change that deleted it.
+	Deleted *LogEntry
+}
+
+// DeletedItems scans the history for pages and files that have been deleted, returning the most recent deletion of
+// each one that doesn't currently exist. Pages that were renamed aren't included.
+func (g *GitBackend) DeletedItems() ([]*DeletedItem, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	head, err := g.headCommit()
+	if err != nil || head == nil {
+		return nil, err
+	}
+
+	trees, err := commitTrees(head)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var items []*DeletedItem
+	err = g.walkDeletions(head.Hash, func(commit *object.Commit, name string, _ object.TreeEntry) error {
+		if seen[name] || treeEntry(trees[0], name) != nil {
+			return nil
+		}
+		seen[name] = true
+
+		item := &DeletedItem{
+			Name: name,
+			Deleted: &LogEntry{
+				ChangeId: commit.Hash.String(),
+				User:     commit.Author.Name,
+				Time:     commit.Author.When,
+				Message:  commit.Message,
+			},
+		}
+		if path.Ext(name) == ".md" {
+			item.Name = strings.TrimSuffix(name, ".md")
+			item.IsPage = true
+		}
+		items = append(items, item)
+		return nil
+	})
+	return items, err
+}
+
+// walkDeletions calls fn for each file deleted by each commit reachable from the given one, newest first, along
+// with the file's entry before it was deleted. Commits are compared with their first parent, and files that were
+// renamed in the same commit, and the wiki's own config, are skipped. Merge commits only report files that all
+// of their parents had, as anything else was deleted on one of the branches being merged and is found there. fn
+// may return storer.ErrStop to end the walk early.
+func (g *GitBackend) walkDeletions(from plumbing.Hash, fn func(commit *object.Commit, name string, entry object.TreeEntry) error) error {
+	commitIter, err := g.repo.Log(&git.LogOptions{From: from})
+	if err != nil {
+		return err
+	}
+
+	return commitIter.ForEach(func(commit *object.Commit) error {
+		if commit.NumParents() == 0 {
+			return nil
+		}
+
+		var parents []*object.Commit
+		err := commit.Parents().ForEach(func(parent *object.Commit) error {
+			parents = append(parents, parent)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		trees, err := commitTrees(append(parents, commit)...)
+		if err != nil {
+			return err
+		}
+
+		// Renames are reported as a single change from the old path to the new, rather than as a deletion
+		changes, err := object.DiffTreeWithOptions(context.Background(), trees[0], trees[len(parents)], object.DefaultDiffTreeOptions)
+		if err != nil {
+			return err
+		}
+
+		for i := range changes {
+			action, err := changes[i].Action()
+			if err != nil {
+				return err
+			}
+
+			name := changes[i].From.Name
+			if action != merkletrie.Delete || strings.HasPrefix(name, ".wiki/") || !inAllTrees(trees[1:len(parents)], name) {
+				continue
+			}
+
+			if err := fn(commit, name, changes[i].From.TreeEntry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// inAllTrees determines whether every one of the trees contains the given path.
+func inAllTrees(trees []*object.Tree, gitPath string) bool {
+	for i := range trees {
+		if treeEntry(trees[i], gitPath) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Undelete restores a deleted page or file to the content it had when it was last deleted.
+func (g *GitBackend) Undelete(name string, isPage bool, user, message string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	fileName := name
+	if isPage {
+		fileName = fmt.Sprintf("%s.md", name)
+	}
+
+	_, gitPath, err := g.resolvePath(g.dir, fileName)
+	if err != nil {
+		return err
+	}
+
+	head, err := g.headCommit()
+	if err != nil {
+		return err
+	} else if head == nil {
+		return &fs.PathError{Op: "undelete", Path: gitPath, Err: fs.ErrNotExist}
+	}
+
+	if _, err := head.File(gitPath); err == nil {
+		return &fs.PathError{Op: "undelete", Path: gitPath, Err: fs.ErrExist}
+	}
+
+	var hash plumbing.Hash
+	err = g.walkDeletions(head.Hash, func(_ *object.Commit, deleted string, entry object.TreeEntry) error {
+		if deleted == gitPath {
+			hash = entry.Hash
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if hash.IsZero() {
+		return &fs.PathError{Op: "undelete", Path: gitPath, Err: fs.ErrNotExist}
+	}
+
+	if message == "" {
+		message = fmt.Sprintf("Restored %s", name)
+	}
+
+	return g.commitChanges(map[string]plumbing.Hash{gitPath: hash}, user, message)
+}
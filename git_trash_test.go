@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestGitBackend_Undelete(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("gone", "", []byte("first"), "user", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutPage("gone", "", []byte("last"), "user", "update"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutFile("image.png", io.NopCloser(strings.NewReader("image")), "user", "upload"); err != nil {
+		t.Fatalf("PutFile() error = %v", err)
+	}
+	if err := backend.PutPage("moved", "", []byte("moved"), "user", "create"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.DeletePage("gone", "delete page", "deleter"); err != nil {
+		t.Fatalf("DeletePage() error = %v", err)
+	}
+	if err := backend.DeleteFile("image.png", "delete file", "deleter"); err != nil {
+		t.Fatalf("DeleteFile() error = %v", err)
+	}
+	if err := backend.RenamePage("moved", "elsewhere", "rename", "user", false); err != nil {
+		t.Fatalf("RenamePage() error = %v", err)
+	}
+
+	items, err := backend.DeletedItems()
+	if err != nil {
+		t.Fatalf("DeletedItems() error = %v", err)
+	}
+	if len(items) != 2 || items[0].Name != "image.png" || items[0].IsPage || items[1].Name != "gone" || !items[1].IsPage {
+		t.Fatalf("DeletedItems() = %v, want image.png and gone", items)
+	}
+	if items[1].Deleted.User != "deleter" || items[1].Deleted.Message != "delete page" {
+		t.Errorf("DeletedItems() deletion = %v", items[1].Deleted)
+	}
+
+	if err := backend.Undelete("gone", true, "restorer", ""); err != nil {
+		t.Fatalf("Undelete() error = %v", err)
+	}
+	if page, err := backend.GetPage("gone"); err != nil || string(page.Content) != "last" {
+		t.Errorf("GetPage() after undelete = %v, %v, want last content", page, err)
+	}
+	if err := backend.Undelete("image.png", false, "restorer", ""); err != nil {
+		t.Fatalf("Undelete() error = %v", err)
+	}
+
+	if err := backend.Undelete("gone", true, "restorer", ""); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Undelete() of existing page error = %v, want ErrExist", err)
+	}
+	if err := backend.Undelete("never", true, "restorer", ""); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Undelete() of unknown page error = %v, want ErrNotExist", err)
+	}
+
+	if items, err := backend.DeletedItems(); err != nil || len(items) != 0 {
+		t.Errorf("DeletedItems() after undelete = %v, %v, want none", items, err)
+	}
+}
+
+func TestGitBackend_DeletedItemsInMerges(t *testing.T) {
+	backend := newTestBackend(t)
+
+	for _, title := range []string{"branch", "merge", "one", "two"} {
+		content := title
+		if title == "one" || title == "two" {
+			content = "same"
+		}
+		if err := backend.PutPage(title, "", []byte(content), "user", "create"); err != nil {
+			t.Fatalf("PutPage() error = %v", err)
+		}
+	}
+	created, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+
+	// Deleting two identical pages while adding another copy renames one of them, but still deletes the other
+	changeset := backend.NewChangeset()
+	_ = changeset.DeletePage("one")
+	_ = changeset.DeletePage("two")
+	_ = changeset.PutPage("three", "", []byte("same"))
+	if err := changeset.Commit("user", "copies"); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	if err := backend.DeletePage("branch", "delete on branch", "deleter"); err != nil {
+		t.Fatalf("DeletePage() error = %v", err)
+	}
+	side, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+
+	if err := backend.moveHead(side, created); err != nil {
+		t.Fatalf("moveHead() error = %v", err)
+	}
+	if err := backend.PutPage("other", "", []byte("unrelated"), "user", "other"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	local, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+
+	// A merge that deletes a page both of its parents had, such as when resolving a conflict
+	other, err := backend.writeBlob(strings.NewReader("unrelated"))
+	if err != nil {
+		t.Fatalf("writeBlob() error = %v", err)
+	}
+	deleted, err := backend.buildCommit(side, map[string]plumbing.Hash{"merge.md": plumbing.ZeroHash, "other.md": other}, "deleter", "unused")
+	if err != nil {
+		t.Fatalf("buildCommit() error = %v", err)
+	}
+	now := time.Now()
+	hash, err := backend.commitTree(deleted.TreeHash, []plumbing.Hash{local.Hash, side.Hash}, signature("deleter", now), signature("deleter", now), "merge")
+	if err != nil {
+		t.Fatalf("commitTree() error = %v", err)
+	}
+	merge, err := backend.repo.CommitObject(hash)
+	if err != nil {
+		t.Fatalf("CommitObject() error = %v", err)
+	}
+	if err := backend.moveHead(local, merge); err != nil {
+		t.Fatalf("moveHead() error = %v", err)
+	}
+
+	items, err := backend.DeletedItems()
+	if err != nil {
+		t.Fatalf("DeletedItems() error = %v", err)
+	}
+
+	messages := make(map[string]string)
+	for i := range items {
+		messages[items[i].Name] = items[i].Deleted.Message
+	}
+	if len(messages) != 3 || messages["merge"] != "merge" || messages["branch"] != "delete on branch" || (messages["one"] != "copies" && messages["two"] != "copies") {
+		t.Errorf("DeletedItems() = %v, want merge, branch and one of the copies", messages)
+	}
+}
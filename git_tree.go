@@ -0,0 +1,400 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+// treeFiles is a flattened view of a git tree, mapping the full path of each file to its entry.
+type treeFiles map[string]object.TreeEntry
+
+// signature builds the commit signature used for the given wiki user.
+func signature(user string, when time.Time) object.Signature {
+	return object.Signature{
+		Name:  user,
+		Email: user + "@wiki",
+		When:  when,
+	}
+}
+
+// flattenTree collects all files within the given tree. A nil tree is treated as empty.
+func (g *GitBackend) flattenTree(tree *object.Tree) (treeFiles, error) {
+	files := make(treeFiles)
+	if tree == nil {
+		return files, nil
+	}
+
+	return files, g.walkTreeFiles(tree, "", func(name string, entry object.TreeEntry) error {
+		files[name] = entry
+		return nil
+	})
+}
+
+type treeNode struct {
+	files    []object.TreeEntry
+	children map[string]*treeNode
+}
+
+// writeTree stores the tree objects needed to represent the given files, returning the hash of the root tree.
+// The blobs referenced by the files must already exist in the repository.
+func (g *GitBackend) writeTree(files treeFiles) (plumbing.Hash, error) {
+	root := &treeNode{children: make(map[string]*treeNode)}
+	for name, entry := range files {
+		node := root
+		parts := strings.Split(name, "/")
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := node.children[part]
+			if !ok {
+				child = &treeNode{children: make(map[string]*treeNode)}
+				node.children[part] = child
+			}
+			node = child
+		}
+
+		entry.Name = parts[len(parts)-1]
+		node.files = append(node.files, entry)
+	}
+	return g.writeTreeNode(root)
+}
+
+func (g *GitBackend) writeTreeNode(node *treeNode) (plumbing.Hash, error) {
+	entries := node.files
+	for name, child := range node.children {
+		hash, err := g.writeTreeNode(child)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		entries = append(entries, object.TreeEntry{Name: name, Mode: filemode.Dir, Hash: hash})
+	}
+	return g.storeTree(entries)
+}
+
+// updateTree stores a copy of the given tree with changes applied, returning the hash of the new tree. Changes map
+// paths within the tree to new blob hashes; a zero hash removes the file. Only the subtrees containing changes are
+// rewritten. The returned bool is true if the resulting tree is empty.
+func (g *GitBackend) updateTree(tree *object.Tree, changes map[string]plumbing.Hash) (plumbing.Hash, bool, error) {
+	entries := make(map[string]object.TreeEntry)
+	if tree != nil {
+		for i := range tree.Entries {
+			entries[tree.Entries[i].Name] = tree.Entries[i]
+		}
+	}
+
+	nested := make(map[string]map[string]plumbing.Hash)
+	for name, hash := range changes {
+		if dir, rest, ok := strings.Cut(name, "/"); ok {
+			if nested[dir] == nil {
+				nested[dir] = make(map[string]plumbing.Hash)
+			}
+			nested[dir][rest] = hash
+		} else if hash.IsZero() {
+			delete(entries, name)
+		} else {
+			entries[name] = object.TreeEntry{Name: name, Mode: filemode.Regular, Hash: hash}
+		}
+	}
+
+	for dir, subChanges := range nested {
+		var subtree *object.Tree
+		if entry, ok := entries[dir]; ok && entry.Mode == filemode.Dir {
+			t, err := g.repo.TreeObject(entry.Hash)
+			if err != nil {
+				return plumbing.ZeroHash, false, err
+			}
+			subtree = t
+		}
+
+		hash, empty, err := g.updateTree(subtree, subChanges)
+		if err != nil {
+			return plumbing.ZeroHash, false, err
+		}
+
+		if empty {
+			delete(entries, dir)
+		} else {
+			entries[dir] = object.TreeEntry{Name: dir, Mode: filemode.Dir, Hash: hash}
+		}
+	}
+
+	var list []object.TreeEntry
+	for name := range entries {
+		list = append(list, entries[name])
+	}
+
+	hash, err := g.storeTree(list)
+	return hash, len(list) == 0, err
+}
+
+// storeTree sorts the given entries and stores them as a tree object, returning its hash.
+func (g *GitBackend) storeTree(entries []object.TreeEntry) (plumbing.Hash, error) {
+	// Git sorts directories as though they had a trailing slash
+	sortName := func(e object.TreeEntry) string {
+		if e.Mode == filemode.Dir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return sortName(entries[i]) < sortName(entries[j])
+	})
+
+	tree := &object.Tree{Entries: entries}
+	obj := g.repo.Storer.NewEncodedObject()
+	if err := tree.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return g.repo.Storer.SetEncodedObject(obj)
+}
+
+// readBlob returns the entire contents of the given blob.
+func (g *GitBackend) readBlob(hash plumbing.Hash) ([]byte, error) {
+	blob, err := g.repo.BlobObject(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// writeBlob stores the given content as a blob object, returning its hash.
+func (g *GitBackend) writeBlob(content io.Reader) (plumbing.Hash, error) {
+	obj := g.repo.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+
+	w, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if _, err := io.Copy(w, content); err != nil {
+		_ = w.Close()
+		return plumbing.ZeroHash, err
+	}
+
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return g.repo.Storer.SetEncodedObject(obj)
+}
+
+// commitTree creates a commit object pointing at the given tree, returning its hash. The commit is not referenced
+// by any branch until the caller updates one.
+func (g *GitBackend) commitTree(tree plumbing.Hash, parents []plumbing.Hash, author, committer object.Signature, message string) (plumbing.Hash, error) {
+	commit := &object.Commit{
+		Author:       author,
+		Committer:    committer,
+		Message:      message,
+		TreeHash:     tree,
+		ParentHashes: parents,
+	}
+
+	obj := g.repo.Storer.NewEncodedObject()
+	if err := commit.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return g.repo.Storer.SetEncodedObject(obj)
+}
+
+// headBranch returns the name of the branch that HEAD points at.
+func (g *GitBackend) headBranch() (plumbing.ReferenceName, error) {
+	ref, err := g.repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return "", err
+	}
+
+	if ref.Type() != plumbing.SymbolicReference {
+		return "", errors.New("HEAD is not attached to a branch")
+	}
+
+	return ref.Target(), nil
+}
+
+// headCommit returns the commit currently referenced by HEAD, or nil if no commits have been made yet.
+func (g *GitBackend) headCommit() (*object.Commit, error) {
+	ref, err := g.repo.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return g.repo.CommitObject(ref.Hash())
+}
+
+// setHead updates the branch that HEAD points at to reference the given commit.
+func (g *GitBackend) setHead(hash plumbing.Hash) error {
+	branch, err := g.headBranch()
+	if err != nil {
+		return err
+	}
+
+	if err := g.repo.Storer.SetReference(plumbing.NewHashReference(branch, hash)); err != nil {
+		return err
+	}
+
+	g.head = hash
+	return nil
+}
+
+// commitTrees returns the trees of the given commits, with nil commits mapping to nil (empty) trees.
+func commitTrees(commits ...*object.Commit) ([]*object.Tree, error) {
+	trees := make([]*object.Tree, len(commits))
+	for i := range commits {
+		if commits[i] == nil {
+			continue
+		}
+
+		tree, err := commits[i].Tree()
+		if err != nil {
+			return nil, err
+		}
+		trees[i] = tree
+	}
+	return trees, nil
+}
+
+// moveHead points HEAD at a new commit and updates the working tree to match.
+func (g *GitBackend) moveHead(from, to *object.Commit) error {
+	trees, err := commitTrees(from, to)
+	if err != nil {
+		return err
+	}
+
+	if err := g.setHead(to.Hash); err != nil {
+		return err
+	}
+
+	if err := g.checkoutChanges(trees[0], trees[1]); err != nil {
+		// Roll back to the previous commit, so we don't end up with a half-applied change in the working tree
+		if from != nil && g.setHead(from.Hash) == nil {
+			_ = g.checkoutChanges(trees[1], trees[0])
+		}
+		return err
+	}
+	return nil
+}
+
+// checkoutChanges updates the working tree and index to reflect the differences between two trees. It should be
+// called whenever HEAD is moved. It does nothing for bare repositories.
+func (g *GitBackend) checkoutChanges(from, to *object.Tree) error {
+	if g.bare {
+		return nil
+	}
+
+	changes, err := object.DiffTree(from, to)
+	if err != nil {
+		return err
+	}
+
+	idx, err := g.repo.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	for i := range changes {
+		action, err := changes[i].Action()
+		if err != nil {
+			return err
+		}
+
+		if action == merkletrie.Delete {
+			err = g.checkoutPath(idx, changes[i].From.Name, nil)
+		} else {
+			err = g.checkoutPath(idx, changes[i].To.Name, &changes[i].To.TreeEntry)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return g.repo.Storer.SetIndex(idx)
+}
+
+// checkoutPath updates a single path in the working tree and index to match the given tree entry, or removes it
+// if the entry is nil. The index must be saved by the caller.
+func (g *GitBackend) checkoutPath(idx *index.Index, name string, entry *object.TreeEntry) error {
+	if entry == nil {
+		filePath := filepath.Join(g.dir, filepath.FromSlash(name))
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		// Tidy up any directories that are now empty; os.Remove will fail for the first non-empty one
+		for dir := filepath.Dir(filePath); dir != filepath.Clean(g.dir) && os.Remove(dir) == nil; dir = filepath.Dir(dir) {
+		}
+
+		_, _ = idx.Remove(name)
+		return nil
+	}
+
+	stat, err := g.checkoutFile(name, entry.Hash)
+	if err != nil {
+		return err
+	}
+
+	e, err := idx.Entry(name)
+	if err == index.ErrEntryNotFound {
+		e = idx.Add(name)
+	} else if err != nil {
+		return err
+	}
+	e.Hash = entry.Hash
+	e.Mode = entry.Mode
+	e.Size = uint32(stat.Size())
+	e.CreatedAt = stat.ModTime()
+	e.ModifiedAt = stat.ModTime()
+	return nil
+}
+
+// checkoutFile writes the contents of a blob to the given path in the working tree.
+func (g *GitBackend) checkoutFile(name string, hash plumbing.Hash) (os.FileInfo, error) {
+	blob, err := g.repo.BlobObject(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	filePath := filepath.Join(g.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(filePath), os.FileMode(0755)); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(f, reader); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	return os.Stat(filePath)
+}
@@ -2,27 +2,93 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
-	"os"
-	"path/filepath"
+	"path"
+	"strings"
 	"time"
 
-	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
-func (g *GitBackend) PutPage(title string, content []byte, user string, message string) error {
+// EditConflictError is returned when a page has been changed since the revision an edit was based on, and the
+// changes overlap with the edit.
+type EditConflictError struct {
+	// Content is the edited page merged with the other changes, with conflict markers around the overlaps.
+	Content []byte
+	// Revision is the revision that Content was merged with.
+	Revision string
+}
+
+func (e *EditConflictError) Error() string {
+	return "page has been changed by someone else"
+}
+
+// PutPage saves the content of a page. If baseRevision is given, any changes made to the page since that
+// revision are merged with the new content; if they overlap an EditConflictError is returned.
+func (g *GitBackend) PutPage(title string, baseRevision string, content []byte, user string, message string) error {
 	g.mutex.Lock()
 	defer g.mutex.Unlock()
 
-	filePath, gitPath, err := g.resolvePath(g.dir, fmt.Sprintf("%s.md", title))
+	_, gitPath, err := g.resolvePath(g.dir, fmt.Sprintf("%s.md", title))
 	if err != nil {
 		return err
 	}
 
-	return g.writeFile(filePath, gitPath, bytes.NewReader(content), user, message)
+	if baseRevision != "" {
+		content, err = g.mergeEdit(gitPath, baseRevision, content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return g.writeFile(gitPath, bytes.NewReader(content), user, message)
+}
+
+// mergeEdit merges content that was based on an older revision of a file with any changes made since, following
+// the file across any renames in between.
+func (g *GitBackend) mergeEdit(gitPath, baseRevision string, content []byte) ([]byte, error) {
+	return g.mergeStagedEdit(nil, gitPath, gitPath, baseRevision, content)
+}
+
+// mergeStagedEdit is like mergeEdit, but merges with the file as it is after the given changes have been made on
+// top of HEAD. headPath is the path the file had at HEAD, which differs from gitPath if the changes moved it.
+func (g *GitBackend) mergeStagedEdit(changes map[string]plumbing.Hash, gitPath, headPath, baseRevision string, content []byte) ([]byte, error) {
+	_, base, err := g.pageAtRevision(headPath, baseRevision)
+	if err != nil && err != object.ErrFileNotFound {
+		return nil, err
+	}
+
+	var current []byte
+	hash, err := g.stagedFile(changes, gitPath)
+	if err == nil {
+		if current, err = g.readBlob(hash); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if bytes.Equal(base, current) {
+		return content, nil
+	}
+
+	merged, clean := mergeText(base, content, current, "your changes", "current version")
+	if !clean {
+		head, err := g.headCommit()
+		if err != nil {
+			return nil, err
+		}
+		return nil, &EditConflictError{
+			Content:  merged,
+			Revision: head.Hash.String(),
+		}
+	}
+	return merged, nil
 }
 
 func (g *GitBackend) PutFile(name string, content io.ReadCloser, user string, message string) error {
@@ -30,63 +96,94 @@ func (g *GitBackend) PutFile(name string, content io.ReadCloser, user string, me
 	defer g.mutex.Unlock()
 	defer content.Close()
 
-	filePath, gitPath, err := g.resolvePath(g.dir, name)
+	_, gitPath, err := g.resolvePath(g.dir, name)
 	if err != nil {
 		return err
 	}
 
-	return g.writeFile(filePath, gitPath, content, user, message)
+	return g.writeFile(gitPath, content, user, message)
 }
 
 func (g *GitBackend) PutConfig(name string, content []byte, user string, message string) error {
 	g.mutex.Lock()
 	defer g.mutex.Unlock()
 
-	filePath := filepath.Join(g.dir, ".wiki", fmt.Sprintf("%s.json.enc", name))
-	gitPath := filepath.Join(".wiki", fmt.Sprintf("%s.json.enc", name))
+	gitPath := path.Join(".wiki", fmt.Sprintf("%s.json.enc", name))
 
-	return g.writeFile(filePath, gitPath, bytes.NewReader(content), user, message)
+	return g.writeFile(gitPath, bytes.NewReader(content), user, message)
 }
 
-func (g *GitBackend) writeFile(filePath, gitPath string, content io.Reader, user, message string) error {
-	if err := os.MkdirAll(filepath.Dir(filePath), os.FileMode(0755)); err != nil {
+func (g *GitBackend) writeFile(gitPath string, content io.Reader, user, message string) error {
+	hash, err := g.writeBlob(content)
+	if err != nil {
 		return err
 	}
 
-	f, err := os.Create(filePath)
+	return g.commitChanges(map[string]plumbing.Hash{gitPath: hash}, user, message)
+}
+
+// commitChanges creates a new commit on top of HEAD with the given changes applied, and updates the working tree
+// to match. Changes map git paths to the hashes of their new blobs; a zero hash deletes the file. If the changes
+// don't alter the tree, no commit is made.
+func (g *GitBackend) commitChanges(changes map[string]plumbing.Hash, user, message string) error {
+	parent, err := g.headCommit()
 	if err != nil {
 		return err
 	}
 
-	if _, err := io.Copy(f, content); err != nil {
-		_ = f.Close()
+	commit, err := g.buildCommit(parent, changes, user, message)
+	if err != nil || commit == nil {
 		return err
 	}
 
-	if err := f.Close(); err != nil {
+	if err := g.moveHead(parent, commit); err != nil {
 		return err
 	}
 
-	worktree, err := g.repo.Worktree()
+	g.publish()
+	return nil
+}
+
+// buildCommit creates a commit on top of the given parent (which may be nil) with the changes applied, without
+// updating any references. If the changes don't alter the tree, nil is returned.
+func (g *GitBackend) buildCommit(parent *object.Commit, changes map[string]plumbing.Hash, user, message string) (*object.Commit, error) {
+	sig := signature(user, time.Now())
+	return g.buildCommitAs(parent, changes, sig, sig, message)
+}
+
+// buildCommitAs is like buildCommit, but with the given author and committer signatures.
+func (g *GitBackend) buildCommitAs(parent *object.Commit, changes map[string]plumbing.Hash, author, committer object.Signature, message string) (*object.Commit, error) {
+	var parentTree *object.Tree
+	var parents []plumbing.Hash
+	if parent != nil {
+		var err error
+		parentTree, err = parent.Tree()
+		if err != nil {
+			return nil, err
+		}
+		parents = append(parents, parent.Hash)
+	}
+
+	tree, _, err := g.updateTree(parentTree, changes)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if _, err := worktree.Add(gitPath); err != nil {
-		return err
+	if parentTree != nil && tree == parentTree.Hash {
+		return nil, nil
 	}
 
-	_, err = worktree.Commit(message, &git.CommitOptions{
-		Author: &object.Signature{
-			Name:  user,
-			Email: user + "@wiki",
-			When:  time.Now(),
-		},
-	})
-	return err
+	hash, err := g.commitTree(tree, parents, author, committer, message)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.repo.CommitObject(hash)
 }
 
-func (g *GitBackend) RenamePage(name string, newName string, message string, user string) error {
+// RenamePage moves a page to a new title. If redirect is true, a redirect to the new title is left in its place.
+// If a page already exists with the new title, an error satisfying errors.Is(err, fs.ErrExist) is returned.
+func (g *GitBackend) RenamePage(name string, newName string, message string, user string, redirect bool) error {
 	g.mutex.Lock()
 	defer g.mutex.Unlock()
 
@@ -100,26 +197,81 @@ func (g *GitBackend) RenamePage(name string, newName string, message string, use
 		log.Printf("Unable to resolve new path: %s -> %s: %s", name, newName, err.Error())
 		return err
 	}
-	worktree, err := g.repo.Worktree()
+	file, err := g.headFile(gitPath)
 	if err != nil {
-		log.Printf("Unable to get worktree: %s", err.Error())
+		log.Printf("Unable to find page to rename: %s -> %s: %s", name, newName, err.Error())
 		return err
 	}
-	_, err = worktree.Move(gitPath, newGitPath)
+	if _, err := g.headFile(newGitPath); err == nil {
+		return &fs.PathError{Op: "rename", Path: newGitPath, Err: fs.ErrExist}
+	}
+	changes := map[string]plumbing.Hash{
+		gitPath:    plumbing.ZeroHash,
+		newGitPath: file.Hash,
+	}
+	if redirect {
+		if changes[gitPath], err = g.writeBlob(bytes.NewReader(redirectContent(newName))); err != nil {
+			return err
+		}
+	}
+	err = g.commitChanges(changes, user, message)
 	if err != nil {
 		log.Printf("Unable to rename git: %s -> %s: %s", name, newName, err.Error())
 		return err
 	}
-	_, err = worktree.Commit(message, &git.CommitOptions{
-		Author: &object.Signature{
-			Name:  user,
-			Email: user + "@wiki",
-			When:  time.Now(),
-		},
-	})
 	return nil
 }
 
+// RenameDirectory moves every page and file within a directory to a new location, in a single commit.
+func (g *GitBackend) RenameDirectory(dir string, newDir string, message string, user string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	_, gitPath, err := g.resolvePath(g.dir, dir)
+	if err != nil {
+		return err
+	}
+	_, newGitPath, err := g.resolvePath(g.dir, newDir)
+	if err != nil {
+		return err
+	}
+
+	if newGitPath == gitPath || strings.HasPrefix(newGitPath, gitPath+"/") {
+		return fmt.Errorf("unable to move %s into itself", dir)
+	}
+
+	files, err := g.directoryFiles(gitPath)
+	if err != nil {
+		return err
+	}
+
+	head, err := g.headCommit()
+	if err != nil {
+		return err
+	}
+
+	trees, err := commitTrees(head)
+	if err != nil {
+		return err
+	}
+
+	changes := make(map[string]plumbing.Hash)
+	for name, entry := range files {
+		target := newGitPath + strings.TrimPrefix(name, gitPath)
+		if treeEntry(trees[0], target) != nil {
+			return &fs.PathError{Op: "rename", Path: target, Err: fs.ErrExist}
+		}
+		changes[name] = plumbing.ZeroHash
+		changes[target] = entry.Hash
+	}
+
+	if message == "" {
+		message = fmt.Sprintf("Moved %s to %s", gitPath, newGitPath)
+	}
+
+	return g.commitChanges(changes, user, message)
+}
+
 func (g *GitBackend) DeletePage(name string, message string, user string) error {
 	g.mutex.Lock()
 	defer g.mutex.Unlock()
@@ -139,20 +291,8 @@ func (g *GitBackend) delete(name, message, user string) error {
 	if err != nil {
 		return err
 	}
-	worktree, err := g.repo.Worktree()
-	if err != nil {
-		return err
-	}
-	_, err = worktree.Remove(gitPath)
-	if err != nil {
+	if _, err := g.headFile(gitPath); err != nil {
 		return err
 	}
-	_, err = worktree.Commit(message, &git.CommitOptions{
-		Author: &object.Signature{
-			Name:  user,
-			Email: user + "@wiki",
-			When:  time.Now(),
-		},
-	})
-	return nil
+	return g.commitChanges(map[string]plumbing.Hash{gitPath: plumbing.ZeroHash}, user, message)
 }
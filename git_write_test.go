@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGitBackend_RenameDirectory(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("projects/alpha/index", "", []byte("index"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutPage("projects/alpha/notes/one", "", []byte("one"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutFile("projects/alpha/diagram.png", io.NopCloser(strings.NewReader("image")), "user", "message"); err != nil {
+		t.Fatalf("PutFile() error = %v", err)
+	}
+	if err := backend.PutPage("projects/alphabet", "", []byte("not in the directory"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	pages, files, err := backend.DirectoryContents("projects/alpha")
+	if err != nil {
+		t.Fatalf("DirectoryContents() error = %v", err)
+	}
+	if !reflect.DeepEqual(pages, []string{"projects/alpha/index", "projects/alpha/notes/one"}) || !reflect.DeepEqual(files, []string{"projects/alpha/diagram.png"}) {
+		t.Errorf("DirectoryContents() = %v, %v", pages, files)
+	}
+
+	before, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+
+	if err := backend.RenameDirectory("projects/alpha", "archive/alpha", "", "user"); err != nil {
+		t.Fatalf("RenameDirectory() error = %v", err)
+	}
+
+	head, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+	if len(head.ParentHashes) != 1 || head.ParentHashes[0] != before.Hash {
+		t.Errorf("RenameDirectory() didn't create a single commit")
+	}
+
+	all, err := backend.ListPages()
+	if err != nil {
+		t.Fatalf("ListPages() error = %v", err)
+	}
+	if !reflect.DeepEqual(all, []string{"archive/alpha/index", "archive/alpha/notes/one", "projects/alphabet"}) {
+		t.Errorf("ListPages() after rename = %v", all)
+	}
+	if file, err := backend.GetFile("archive/alpha/diagram.png"); err != nil {
+		t.Errorf("GetFile() after rename error = %v", err)
+	} else {
+		_ = file.Close()
+	}
+
+	if err := backend.RenameDirectory("archive", "archive/old", "", "user"); err == nil {
+		t.Errorf("RenameDirectory() into itself succeeded")
+	}
+	if err := backend.RenameDirectory("missing", "other", "", "user"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("RenameDirectory() of missing directory error = %v, want ErrNotExist", err)
+	}
+
+	if err := backend.PutPage("projects/alpha/index", "", []byte("new"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.RenameDirectory("projects/alpha", "archive/alpha", "", "user"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("RenameDirectory() over existing files error = %v, want ErrExist", err)
+	}
+}
+
+func TestGitBackend_RenamePageOverExisting(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("first", "", []byte("first"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.PutPage("second", "", []byte("second"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+
+	if err := backend.RenamePage("first", "second", "", "user", false); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("RenamePage() over existing page error = %v, want ErrExist", err)
+	}
+	if err := backend.RenamePage("first", "First", "", "user", true); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("RenamePage() to itself error = %v, want ErrExist", err)
+	}
+
+	for title, want := range map[string]string{"first": "first", "second": "second"} {
+		page, err := backend.GetPage(title)
+		if err != nil {
+			t.Fatalf("GetPage() error = %v", err)
+		}
+		if string(page.Content) != want {
+			t.Errorf("GetPage(%s) = %q, want %q", title, page.Content, want)
+		}
+	}
+}
+
+func TestGitBackend_PutPageMergesEdits(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if err := backend.PutPage("page", "", []byte("one\ntwo\nthree\n"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	base, err := backend.GetPage("page")
+	if err != nil {
+		t.Fatalf("GetPage() error = %v", err)
+	}
+	revision := base.LastModified.ChangeId
+
+	if err := backend.PutPage("page", "", []byte("one\ntwo\nTHREE\n"), "other", "message"); err != nil {
+		t.Fatalf("PutPage() error = %v", err)
+	}
+	if err := backend.RenamePage("page", "renamed", "", "other", false); err != nil {
+		t.Fatalf("RenamePage() error = %v", err)
+	}
+
+	// The edit was based on a revision from before the rename, so has to be followed back to the old name
+	if err := backend.PutPage("renamed", revision, []byte("ONE\ntwo\nthree\n"), "user", "message"); err != nil {
+		t.Fatalf("PutPage() clean merge error = %v", err)
+	}
+	if page, err := backend.GetPage("renamed"); err != nil || string(page.Content) != "ONE\ntwo\nTHREE\n" {
+		t.Errorf("GetPage() after merge = %v, %v, want both changes", page, err)
+	}
+
+	head, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+
+	var conflict *EditConflictError
+	if err := backend.PutPage("renamed", revision, []byte("one\ntwo\nthree!\n"), "user", "message"); !errors.As(err, &conflict) {
+		t.Fatalf("PutPage() overlapping edit error = %v, want EditConflictError", err)
+	}
+	if conflict.Revision != head.Hash.String() || !strings.Contains(string(conflict.Content), "three!") || !strings.Contains(string(conflict.Content), "THREE") {
+		t.Errorf("PutPage() conflict = %s at %s, want both versions at %s", conflict.Content, conflict.Revision, head.Hash)
+	}
+	if page, err := backend.GetPage("renamed"); err != nil || string(page.Content) != "ONE\ntwo\nTHREE\n" {
+		t.Errorf("GetPage() after conflict = %v, %v, want it unchanged", page, err)
+	}
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
 	"log"
 	"net/http"
+	"strings"
 )
 
 type Lister interface {
@@ -51,3 +55,123 @@ func ApiListHandler(l Lister) http.HandlerFunc {
 		_, _ = w.Write(b)
 	}
 }
+
+// ApiChange describes a single change submitted to the changes API.
+type ApiChange struct {
+	// Action is one of "put", "delete" or "rename".
+	Action string `json:"action"`
+	// Type is either "page" or "file".
+	Type string `json:"type"`
+	// Name is the title of the page or name of the file being changed.
+	Name string `json:"name"`
+	// NewName is the new title or name when renaming.
+	NewName string `json:"newName,omitempty"`
+	// Content is the new content of a page.
+	Content string `json:"content,omitempty"`
+	// Revision is the revision a page edit was based on, used to merge in any changes made since.
+	Revision string `json:"revision,omitempty"`
+	// Upload is the name of the multipart form field that contains the new content of a file.
+	Upload string `json:"upload,omitempty"`
+}
+
+// ApiConflict is returned by the changes API when a page edit conflicts with changes made by someone else.
+type ApiConflict struct {
+	Content  string `json:"content"`
+	Revision string `json:"revision"`
+}
+
+type ChangesetCreator interface {
+	NewChangeset() *Changeset
+}
+
+// ApiChangesHandler applies a list of changes to pages and files in a single commit. The request must be a
+// multipart form with a "changes" field containing a JSON list of ApiChange objects, an optional "message"
+// field, and any number of file fields referenced by the changes.
+func ApiChangesHandler(cc ChangesetCreator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 30); err != nil && err != http.ErrNotMultipart {
+			log.Printf("Error parsing form: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var changes []ApiChange
+		if err := json.Unmarshal([]byte(r.FormValue("changes")), &changes); err != nil {
+			log.Printf("Invalid changes submitted: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		changeset := cc.NewChangeset()
+		for i := range changes {
+			if err := stageApiChange(r, changeset, changes[i]); err != nil {
+				log.Printf("Unable to stage change %d (%s %s %s): %v", i, changes[i].Action, changes[i].Type, changes[i].Name, err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+
+		message := r.FormValue("message")
+		username := "Anonymoose"
+		if user := getUserForRequest(r); user != nil {
+			username = user.Name
+		}
+
+		var conflict *EditConflictError
+		if err := changeset.Commit(username, message); errors.As(err, &conflict) {
+			b, err := json.Marshal(ApiConflict{Content: string(conflict.Content), Revision: conflict.Revision})
+			if err != nil {
+				log.Printf("Failed to marshal conflict: %v\n", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write(b)
+		} else if errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Unable to commit changes: %v", err)
+			w.WriteHeader(http.StatusNotFound)
+		} else if errors.Is(err, fs.ErrExist) {
+			log.Printf("Unable to commit changes: %v", err)
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		} else if err != nil {
+			log.Printf("Unable to commit changes: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+func stageApiChange(r *http.Request, changeset *Changeset, change ApiChange) error {
+	switch {
+	case change.Type == "page" && change.Action == "put":
+		return changeset.PutPage(change.Name, change.Revision, []byte(change.Content))
+	case change.Type == "page" && change.Action == "delete":
+		return changeset.DeletePage(change.Name)
+	case change.Type == "page" && change.Action == "rename":
+		return changeset.RenamePage(change.Name, change.NewName)
+	case change.Type == "file" && change.Action == "put":
+		if !strings.ContainsRune(change.Name, '.') {
+			return fmt.Errorf("invalid file name: %s", change.Name)
+		}
+
+		file, _, err := r.FormFile(change.Upload)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		return changeset.PutFile(change.Name, file)
+	case change.Type == "file" && change.Action == "delete":
+		return changeset.DeleteFile(change.Name)
+	case change.Type == "file" && change.Action == "rename":
+		if !strings.ContainsRune(change.NewName, '.') {
+			return fmt.Errorf("invalid file name: %s", change.NewName)
+		}
+		return changeset.RenameFile(change.Name, change.NewName)
+	default:
+		return fmt.Errorf("unknown change: %s %s", change.Action, change.Type)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/mdbot/wiki/config"
+)
+
+type DraftProvider interface {
+	ListDrafts() ([]*Draft, error)
+	PublishDraft(draft, user, message string) error
+	DeleteDraft(draft string) error
+	DraftInfo(draft string) (owner, revision string, err error)
+}
+
+func ListDraftsHandler(t *Templates, dp DraftProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		drafts, err := dp.ListDrafts()
+		if err != nil {
+			log.Printf("Failed to list drafts: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		t.RenderDrafts(w, r, drafts)
+	}
+}
+
+func ModifyDraftHandler(dp DraftProvider) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if err := request.ParseForm(); err != nil {
+			log.Printf("Error parsing form: %v", err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		name := request.FormValue("name")
+		username := "Anonymoose"
+		user := getUserForRequest(request)
+		if user != nil {
+			username = user.Name
+		}
+
+		// Only the user who started a draft, or an admin, may publish or discard it
+		owner, _, err := dp.DraftInfo(name)
+		if err == nil && owner != "" && owner != username && (user == nil || !user.Has(config.PermissionAdmin)) {
+			log.Printf("User %s tried to modify draft %s started by %s", username, name, owner)
+			writer.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		var notice string
+		switch request.FormValue("action") {
+		case "publish":
+			err = dp.PublishDraft(name, username, request.FormValue("message"))
+			notice = fmt.Sprintf("Published draft %s", name)
+		case "discard":
+			err = dp.DeleteDraft(name)
+			notice = fmt.Sprintf("Discarded draft %s", name)
+		default:
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var conflict *MergeConflictError
+		if errors.Is(err, ErrInvalidDraftName) {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		} else if errors.Is(err, fs.ErrNotExist) {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		} else if errors.As(err, &conflict) {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf(
+				"Unable to publish draft %s: these pages have been changed since it was started: %s",
+				name,
+				strings.Join(conflict.Conflicts, ", "),
+			))
+		} else if err != nil {
+			log.Printf("Unable to modify draft %s: %v", name, err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			putSessionKey(writer, request, sessionNoticeKey, notice)
+		}
+
+		writer.Header().Add("location", "/wiki/drafts")
+		writer.WriteHeader(http.StatusSeeOther)
+	}
+}
+
+// defaultDraftName returns the name of the draft a user's changes are saved to if they don't choose one.
+func defaultDraftName(username string) string {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '-' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(username))
+	return strings.TrimLeft(name, "_-")
+}
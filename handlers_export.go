@@ -0,0 +1,75 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+type ExportProvider interface {
+	CurrentView() (*SnapshotView, error)
+}
+
+func ExportHandler(exporter *StaticExporter, provider ExportProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		view, err := provider.CurrentView()
+		if err != nil {
+			log.Printf("Unable to open wiki for export: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/zip")
+		w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"wiki-%s.zip\"", time.Now().Format("2006-01-02")))
+
+		// The response has already started by the time any errors happen, so they can only be logged
+		zw := zip.NewWriter(w)
+		if err := exporter.Export(view, zw); err != nil {
+			log.Printf("Unable to export wiki: %v", err)
+			return
+		}
+		if err := zw.Close(); err != nil {
+			log.Printf("Unable to finish export: %v", err)
+		}
+	}
+}
+
+type ArchiveProvider interface {
+	Archive(revision string) (*Archive, error)
+}
+
+func ArchiveHandler(provider ArchiveProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "zip"
+		}
+		if format != "zip" && format != "tar.gz" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		archive, err := provider.Archive(r.URL.Query().Get("rev"))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		contentType := "application/zip"
+		write := archive.WriteZip
+		if format == "tar.gz" {
+			contentType = "application/gzip"
+			write = archive.WriteTarGz
+		}
+
+		w.Header().Add("Content-Type", contentType)
+		w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"wiki-%s.%s\"", archive.ShortRevision(), format))
+
+		// The response has already started by the time any errors happen, so they can only be logged
+		if err := write(w); err != nil {
+			log.Printf("Unable to write archive of %s: %v", archive.Revision, err)
+		}
+	}
+}
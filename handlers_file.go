@@ -77,30 +77,45 @@ func UploadFormHandler(t *Templates) http.HandlerFunc {
 
 type FileProvider interface {
 	GetFile(name string) (io.ReadCloser, error)
+	GetFileAt(name, revision string) (io.ReadCloser, error)
 }
 
 func FileHandler(provider FileProvider) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		name := strings.TrimPrefix(request.URL.Path, "/files/view/")
-		reader, err := provider.GetFile(name)
+
+		var reader io.ReadCloser
+		var err error
+		if revision := request.URL.Query().Get("rev"); revision != "" {
+			reader, err = provider.GetFileAt(name, revision)
+		} else {
+			reader, err = provider.GetFile(name)
+		}
 		if err != nil {
 			writer.WriteHeader(http.StatusNotFound)
 			return
 		}
-		defer reader.Close()
 
-		mimeType := mime.TypeByExtension(filepath.Ext(name))
-		if mimeType == "" {
-			mimeType = "application/octet-stream"
-		}
+		serveFile(writer, name, reader)
+	}
+}
 
-		writer.Header().Add("Content-Type", mimeType)
-		writer.Header().Add("X-Content-Type-Options", "nosniff")
-		if !markdown.CanEmbed(mimeType) {
-			writer.Header().Add("Content-Disposition", "attachment")
-		}
-		_, _ = io.Copy(writer, reader)
+// serveFile sends the content of an uploaded file, with a content type based on its name. Files that can't be
+// embedded in pages are sent as attachments. The reader is closed afterwards.
+func serveFile(writer http.ResponseWriter, name string, reader io.ReadCloser) {
+	defer reader.Close()
+
+	mimeType := mime.TypeByExtension(filepath.Ext(name))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
 	}
+
+	writer.Header().Add("Content-Type", mimeType)
+	writer.Header().Add("X-Content-Type-Options", "nosniff")
+	if !markdown.CanEmbed(mimeType) {
+		writer.Header().Add("Content-Disposition", "attachment")
+	}
+	_, _ = io.Copy(writer, reader)
 }
 
 type DeleteFileProvider interface {
@@ -142,3 +157,74 @@ func DeleteFileHandler(provider DeleteFileProvider) http.HandlerFunc {
 		http.Redirect(writer, request, "/", http.StatusSeeOther)
 	}
 }
+
+type RevertFileProvider interface {
+	RevertFile(name, revision, user, message string) error
+}
+
+func RevertFileConfirmHandler(t *Templates) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/files/revert/")
+		revision := r.URL.Query().Get("rev")
+		if revision == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		t.RenderRevertFile(w, r, name, revision)
+	}
+}
+
+func RevertFileHandler(provider RevertFileProvider) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if err := request.ParseForm(); err != nil {
+			log.Printf("Error parsing form: %v", err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		name := strings.TrimPrefix(request.URL.Path, "/files/revert/")
+		revision := request.FormValue("rev")
+		if revision == "" {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		message := request.FormValue("message")
+		username := "Anonymoose"
+		if user := getUserForRequest(request); user != nil {
+			username = user.Name
+		}
+
+		if err := provider.RevertFile(name, revision, username, message); err != nil {
+			log.Printf("Unable to revert file %s: %v", name, err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("Reverted file %s", name))
+		http.Redirect(writer, request, fmt.Sprintf("/files/history/%s", name), http.StatusSeeOther)
+	}
+}
+
+func CompareFileHandler(t *Templates) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/files/compare/")
+		startRevision := r.URL.Query().Get("startrev")
+		endRevision := r.URL.Query().Get("endrev")
+		if startRevision == "" || endRevision == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if !isImage(name) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		t.RenderCompareFile(w, r, name, startRevision, endRevision)
+	}
+}
+
+// isImage determines whether a file can be displayed as an image, based on its extension.
+func isImage(name string) bool {
+	return strings.HasPrefix(mime.TypeByExtension(filepath.Ext(name)), "image/")
+}
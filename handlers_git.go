@@ -0,0 +1,92 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+type GitServer interface {
+	AdvertiseReferences(service string, w io.Writer) error
+	UploadPack(r io.Reader, w io.Writer) error
+	ReceivePack(r io.Reader, w io.Writer) error
+}
+
+// GitInfoRefsHandler serves the reference advertisement that starts a smart HTTP clone, fetch or push.
+func GitInfoRefsHandler(gs GitServer, service string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-advertisement", service))
+		w.Header().Set("Cache-Control", "no-cache")
+		if err := gs.AdvertiseReferences(service, w); err != nil {
+			log.Printf("Unable to advertise git references: %v", err)
+		}
+	}
+}
+
+// GitUploadPackHandler sends objects to a git client that is cloning or fetching from the wiki.
+func GitUploadPackHandler(gs GitServer) http.HandlerFunc {
+	return gitServiceHandler(UploadPackService, gs.UploadPack)
+}
+
+// GitReceivePackHandler accepts objects pushed to the wiki by a git client.
+func GitReceivePackHandler(gs GitServer) http.HandlerFunc {
+	return gitServiceHandler(ReceivePackService, gs.ReceivePack)
+}
+
+func gitServiceHandler(service string, handler func(r io.Reader, w io.Writer) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			reader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				log.Printf("Unable to decompress %s request: %v", service, err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			defer reader.Close()
+			body = reader
+		}
+
+		w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-result", service))
+		w.Header().Set("Cache-Control", "no-cache")
+		if err := handler(body, w); err != nil {
+			log.Printf("Unable to handle %s request: %v", service, err)
+		}
+	}
+}
+
+// BasicAuthHandler allows git clients, which can't log in through the wiki's forms, to authenticate using HTTP
+// basic authentication. If a request is rejected as unauthorised, clients are prompted to send credentials.
+func BasicAuthHandler(auth Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w = &basicAuthChallengeWriter{ResponseWriter: w}
+
+			if username, password, ok := r.BasicAuth(); ok && getUserForRequest(r) == nil {
+				user, err := auth.Authenticate(username, password)
+				if err != nil {
+					log.Printf("Failed basic authentication for user %s: %v", username, err)
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				r = r.WithContext(context.WithValue(r.Context(), contextUserKey, user))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type basicAuthChallengeWriter struct {
+	http.ResponseWriter
+}
+
+func (w *basicAuthChallengeWriter) WriteHeader(status int) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="wiki", charset="UTF-8"`)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
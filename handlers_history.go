@@ -1,11 +1,17 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"path"
 	"strings"
+	"time"
 
-	"github.com/sergi/go-diff/diffmatchpatch"
+	"github.com/go-git/go-git/v5/plumbing"
 )
 
 type HistoryProvider interface {
@@ -13,60 +19,112 @@ type HistoryProvider interface {
 }
 
 func PageHistoryHandler(t *Templates, pp HistoryProvider) http.HandlerFunc {
-	const historySize = 50
-
 	return func(w http.ResponseWriter, r *http.Request) {
 		pageTitle := strings.TrimPrefix(r.URL.Path, "/history/")
 
-		var start string
-		var number = historySize + 1
-
-		q := r.URL.Query()["after"]
-		if q != nil {
-			// If the user is paginating, request 22 items so we get the start item, the 20 we want to show, then
-			// an extra one to tell if there's a next page or not.
-			start = q[0]
-			number = historySize + 2
+		entries, next, err := paginateHistory(r, func(start string, count int) (*History, error) {
+			return pp.PageHistory(pageTitle, start, count)
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
 		}
 
-		history, err := pp.PageHistory(pageTitle, start, number)
+		t.RenderHistory(w, r, pageTitle, entries, next)
+	}
+}
+
+type FileHistoryProvider interface {
+	FileHistory(name string, start string, end int) (*History, error)
+}
+
+func FileHistoryHandler(t *Templates, fp FileHistoryProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/files/history/")
+
+		entries, next, err := paginateHistory(r, func(start string, count int) (*History, error) {
+			return fp.FileHistory(name, start, count)
+		})
 		if err != nil {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 
-		var next string
-		if len(history.Entries) == number {
-			next = history.Entries[number-1].ChangeId
-		} else {
-			number = len(history.Entries) + 1
+		t.RenderFileHistory(w, r, name, entries, next)
+	}
+}
+
+// paginateHistory fetches one page of history entries, starting after the revision given in the request, and
+// returns them along with the revision to start the next page from (if there is one).
+func paginateHistory(r *http.Request, fetch func(start string, count int) (*History, error)) ([]*HistoryEntry, string, error) {
+	const historySize = 50
+
+	var start string
+	var number = historySize + 1
+
+	q := r.URL.Query()["after"]
+	if q != nil {
+		// If the user is paginating, request 22 items so we get the start item, the 20 we want to show, then
+		// an extra one to tell if there's a next page or not.
+		start = q[0]
+		number = historySize + 2
+	}
+
+	history, err := fetch(start, number)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(history.Entries) == number {
+		next = history.Entries[number-1].ChangeId
+	} else {
+		number = len(history.Entries) + 1
+	}
+
+	var entries []*HistoryEntry
+	for i := range history.Entries[:number-1] {
+		e := history.Entries[i]
+
+		var previousChange = ""
+		if i+1 < len(history.Entries) {
+			previousChange = history.Entries[i+1].ChangeId
 		}
 
-		var entries []*HistoryEntry
-		for i := range history.Entries[:number-1] {
-			e := history.Entries[i]
+		entries = append(entries, &HistoryEntry{
+			Latest:           start == "" && i == 0,
+			ChangeId:         e.ChangeId,
+			PreviousChangeId: previousChange,
+			User:             e.User,
+			Time:             e.Time,
+			Message:          e.Message,
+			RenamedFrom:      e.RenamedFrom,
+		})
+	}
+
+	return entries, next, nil
+}
+
+type BlameProvider interface {
+	BlamePage(title string) ([]*BlameLine, error)
+}
 
-			var previousChange = ""
-			if i+1 < len(history.Entries) {
-				previousChange = history.Entries[i+1].ChangeId
-			}
+func BlameHandler(t *Templates, bp BlameProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pageTitle := strings.TrimPrefix(r.URL.Path, "/blame/")
 
-			entries = append(entries, &HistoryEntry{
-				Latest:           start == "" && i == 0,
-				ChangeId:         e.ChangeId,
-				PreviousChangeId: previousChange,
-				User:             e.User,
-				Time:             e.Time,
-				Message:          e.Message,
-			})
+		lines, err := bp.BlamePage(pageTitle)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
 		}
 
-		t.RenderHistory(w, r, pageTitle, entries, next)
+		t.RenderBlame(w, r, pageTitle, lines)
 	}
 }
 
 type RecentChangesProvider interface {
-	RecentChanges(start string, count int) ([]*RecentChange, error)
+	RecentChanges(start string, count int, filter ChangeFilter) ([]*RecentChange, error)
 }
 
 func RecentChangesHandler(t *Templates, rp RecentChangesProvider) http.HandlerFunc {
@@ -84,7 +142,13 @@ func RecentChangesHandler(t *Templates, rp RecentChangesProvider) http.HandlerFu
 			number = historySize + 2
 		}
 
-		history, err := rp.RecentChanges(start, number)
+		filter, query, err := changeFilter(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		history, err := rp.RecentChanges(start, number, filter)
 		if err != nil {
 			w.WriteHeader(http.StatusNotFound)
 			return
@@ -97,7 +161,7 @@ func RecentChangesHandler(t *Templates, rp RecentChangesProvider) http.HandlerFu
 			number = len(history) + 1
 		}
 
-		t.RenderRecentChanges(w, r, history[:number-1], next)
+		t.RenderRecentChanges(w, r, history[:number-1], next, query)
 	}
 }
 
@@ -105,7 +169,13 @@ func RecentChangesFeed(t *Templates, rp RecentChangesProvider) http.HandlerFunc
 	const historySize = 50
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		history, err := rp.RecentChanges("", historySize)
+		filter, _, err := changeFilter(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		history, err := rp.RecentChanges("", historySize, filter)
 		if err != nil {
 			w.WriteHeader(http.StatusNotFound)
 			return
@@ -115,8 +185,110 @@ func RecentChangesFeed(t *Templates, rp RecentChangesProvider) http.HandlerFunc
 	}
 }
 
+// changeFilter reads the filters for recent changes from a request, returning them along with the query
+// parameters that were used so they can be carried over to other links. Dates are given as YYYY-MM-DD in UTC, and
+// both ends of the range are inclusive.
+func changeFilter(r *http.Request) (ChangeFilter, url.Values, error) {
+	const dateFormat = "2006-01-02"
+
+	q := r.URL.Query()
+	filter := ChangeFilter{
+		User:   strings.TrimSpace(q.Get("user")),
+		Prefix: strings.TrimSpace(q.Get("path")),
+		Type:   ChangeType(q.Get("type")),
+	}
+
+	switch filter.Type {
+	case "", ChangeTypePages, ChangeTypeFiles, ChangeTypeConfig:
+	default:
+		return filter, nil, fmt.Errorf("invalid change type: %s", filter.Type)
+	}
+
+	if since := q.Get("since"); since != "" {
+		date, err := time.Parse(dateFormat, since)
+		if err != nil {
+			return filter, nil, err
+		}
+		filter.Since = date
+	}
+
+	if until := q.Get("until"); until != "" {
+		date, err := time.Parse(dateFormat, until)
+		if err != nil {
+			return filter, nil, err
+		}
+		filter.Until = date.AddDate(0, 0, 1)
+	}
+
+	query := make(url.Values)
+	for _, key := range []string{"user", "path", "type", "since", "until"} {
+		if value := strings.TrimSpace(q.Get(key)); value != "" {
+			query.Set(key, value)
+		}
+	}
+	return filter, query, nil
+}
+
+type RevertChangeProvider interface {
+	RevertChange(revision, user, message string) error
+}
+
+func RevertChangeConfirmHandler(t *Templates) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		revision := r.URL.Query().Get("rev")
+		if revision == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		t.RenderRevertChange(w, r, revision)
+	}
+}
+
+func RevertChangeHandler(provider RevertChangeProvider) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if err := request.ParseForm(); err != nil {
+			log.Printf("Error parsing form: %v", err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		revision := request.FormValue("rev")
+		if revision == "" {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		username := "Anonymoose"
+		if user := getUserForRequest(request); user != nil {
+			username = user.Name
+		}
+
+		var conflict *MergeConflictError
+		if err := provider.RevertChange(revision, username, request.FormValue("message")); errors.Is(err, plumbing.ErrReferenceNotFound) || errors.Is(err, plumbing.ErrObjectNotFound) {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		} else if errors.As(err, &conflict) {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf(
+				"Unable to revert change %s: these have been changed since: %s",
+				revision,
+				strings.Join(conflict.Conflicts, ", "),
+			))
+		} else if errors.Is(err, ErrNoChanges) {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Change %s has already been undone", revision))
+		} else if err != nil {
+			log.Printf("Unable to revert change %s: %v", revision, err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("Reverted change %s", revision))
+		}
+
+		http.Redirect(writer, request, "/wiki/changes", http.StatusSeeOther)
+	}
+}
+
 type DiffProvider interface {
-	PathDiff(path string, startRevision string, endRevision string) ([]diffmatchpatch.Diff, error)
+	PathDiff(path string, startRevision string, endRevision string, options DiffOptions) (*TextDiff, error)
 }
 
 func DiffPageHandler(templates *Templates, backend DiffProvider) http.HandlerFunc {
@@ -126,7 +298,7 @@ func DiffPageHandler(templates *Templates, backend DiffProvider) http.HandlerFun
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		
+
 		pageTitle := strings.TrimPrefix(r.URL.Path, "/diff/")
 		startRevision := r.FormValue("startrev")
 		endRevision := r.FormValue("endrev")
@@ -134,12 +306,60 @@ func DiffPageHandler(templates *Templates, backend DiffProvider) http.HandlerFun
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		diff, err := backend.PathDiff(pageTitle, startRevision, endRevision)
+
+		options := diffOptions(r)
+		diff, err := backend.PathDiff(pageTitle, startRevision, endRevision, options)
+		if err != nil {
+			log.Printf("Error getting diff: %+s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		templates.RenderDiff(w, r, pageTitle, startRevision, endRevision, diff, options, r.FormValue("view") == "split")
+	}
+}
+
+func PatchHandler(backend DiffProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			log.Printf("Error parsing form: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		pageTitle := strings.TrimPrefix(r.URL.Path, "/patch/")
+		startRevision := r.FormValue("startrev")
+		endRevision := r.FormValue("endrev")
+		if startRevision == "" || endRevision == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Patches must include whitespace changes, or they won't apply to the old text and reproduce the new one
+		options := diffOptions(r)
+		options.IgnoreWhitespace = false
+
+		diff, err := backend.PathDiff(pageTitle, startRevision, endRevision, options)
 		if err != nil {
 			log.Printf("Error getting diff: %+s", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		templates.RenderDiff(w, r, diff)
+
+		w.Header().Add("Content-Type", "text/x-diff; charset=utf-8")
+		w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(pageTitle)+".patch"))
+		_, _ = io.WriteString(w, diff.Patch())
+	}
+}
+
+// diffOptions reads the diff options from a request. Unless the full context is requested, changes are shown with a
+// few lines either side.
+func diffOptions(r *http.Request) DiffOptions {
+	options := DiffOptions{
+		Context:          defaultDiffContext,
+		IgnoreWhitespace: r.FormValue("whitespace") == "ignore",
+	}
+	if r.FormValue("context") == "all" {
+		options.Context = -1
 	}
+	return options
 }
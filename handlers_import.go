@@ -0,0 +1,113 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+func ImportFormHandler(t *Templates) http.HandlerFunc {
+	return t.RenderImportForm
+}
+
+func ImportHandler(target ImportTarget) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if err := request.ParseMultipartForm(1 << 30); err != nil {
+			log.Printf("Import failed: couldn't parse multipart data: %v", err)
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		file, header, err := request.FormFile("file")
+		if err != nil {
+			log.Printf("Import failed: couldn't read file: %v", err)
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		username := "Anonymoose"
+		if user := getUserForRequest(request); user != nil {
+			username = user.Name
+		}
+
+		var result *ImportResult
+		zr, err := zip.NewReader(file, header.Size)
+		if err == nil {
+			var files []*ImportedFile
+			if files, err = readImportZip(zr); err == nil {
+				dir := strings.Trim(strings.TrimSpace(request.FormValue("dir")), "/")
+				result, err = Import(target, files, dir, username, request.FormValue("message"))
+			}
+		}
+
+		if errors.Is(err, zip.ErrFormat) || errors.Is(err, ErrInvalidImport) {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to import %s: %v", header.Filename, err))
+			http.Redirect(writer, request, "/wiki/import", http.StatusSeeOther)
+			return
+		} else if err != nil {
+			log.Printf("Import failed: %v", err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf(
+			"Imported %d pages and %d files from %s",
+			len(result.Pages),
+			len(result.Files),
+			header.Filename,
+		))
+		http.Redirect(writer, request, "/wiki/index", http.StatusSeeOther)
+	}
+}
+
+func MediaWikiImportHandler(target MediaWikiImportTarget) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if err := request.ParseMultipartForm(1 << 30); err != nil {
+			log.Printf("MediaWiki import failed: couldn't parse multipart data: %v", err)
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		file, header, err := request.FormFile("file")
+		if err != nil {
+			log.Printf("MediaWiki import failed: couldn't read file: %v", err)
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		username := "Anonymoose"
+		if user := getUserForRequest(request); user != nil {
+			username = user.Name
+		}
+
+		dir := strings.Trim(strings.TrimSpace(request.FormValue("dir")), "/")
+		result, err := ImportMediaWiki(target, file, nil, dir, username)
+		if errors.Is(err, ErrInvalidImport) {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to import %s: %v", header.Filename, err))
+			http.Redirect(writer, request, "/wiki/import", http.StatusSeeOther)
+			return
+		} else if err != nil {
+			log.Printf("MediaWiki import failed: %v", err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		notice := fmt.Sprintf(
+			"Imported %d revisions of %d pages and %d files from %s",
+			result.Revisions,
+			len(result.Pages),
+			len(result.Files),
+			header.Filename,
+		)
+		if len(result.MissingFiles) > 0 {
+			notice += fmt.Sprintf(". The contents of %d files weren't included in the export", len(result.MissingFiles))
+		}
+		putSessionKey(writer, request, sessionNoticeKey, notice)
+		http.Redirect(writer, request, "/wiki/index", http.StatusSeeOther)
+	}
+}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"strings"
+
+	"github.com/mdbot/wiki/config"
 )
 
 type PageProvider interface {
 	GetPage(title string) (*Page, error)
 	GetPageAt(title, revision string) (*Page, error)
+	GetDraftPage(draft, title string) (*Page, error)
+	DraftInfo(draft string) (owner, revision string, err error)
 }
 
 type PageExists interface {
@@ -31,10 +39,13 @@ func ViewPageHandler(t *Templates, renderer ContentRenderer, pp PageProvider) ht
 		}
 
 		revision := r.FormValue("rev")
+		draft := r.FormValue("draft")
 		var page *Page
 		var err error
 
-		if revision == "" {
+		if draft != "" {
+			page, err = pp.GetDraftPage(draft, pageTitle)
+		} else if revision == "" {
 			page, err = pp.GetPage(pageTitle)
 		} else {
 			page, err = pp.GetPageAt(pageTitle, revision)
@@ -45,6 +56,15 @@ func ViewPageHandler(t *Templates, renderer ContentRenderer, pp PageProvider) ht
 			return
 		}
 
+		// Old revisions and drafts are shown as they are, so redirects can be inspected and edited
+		if draft == "" && revision == "" && r.FormValue("redirect") != "no" {
+			if target, ok := parseRedirect(page.Content); ok {
+				putSessionKey(w, r, sessionNoticeKey, fmt.Sprintf("Redirected from %s", pageTitle))
+				http.Redirect(w, r, redirectLocation("", target), http.StatusSeeOther)
+				return
+			}
+		}
+
 		content, err := renderer.Render(page.Content)
 		if err != nil {
 			log.Printf("Failed to render markdown: %v\n", err)
@@ -52,48 +72,146 @@ func ViewPageHandler(t *Templates, renderer ContentRenderer, pp PageProvider) ht
 			return
 		}
 
-		t.RenderPage(w, r, pageTitle, content, &LastModifiedDetails{
+		t.RenderPage(w, r, pageTitle, content, draft, &LastModifiedDetails{
 			User: page.LastModified.User,
 			Time: page.LastModified.Time,
 		})
 	}
 }
 
+// redirectLocation returns the URL that a redirect to the target page sends visitors to, within the given prefix.
+// The target is viewed without following any redirect it contains, so a chain of redirects can't loop forever.
+func redirectLocation(prefix, target string) string {
+	target, section, _ := strings.Cut(target, "#")
+	location := fmt.Sprintf("%s/view/%s?redirect=no", prefix, (&url.URL{Path: target}).EscapedPath())
+	if section != "" {
+		location += "#" + (&url.URL{Fragment: section}).EscapedFragment()
+	}
+	return location
+}
+
 func EditPageHandler(t *Templates, pp PageProvider) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		pageTitle := strings.TrimPrefix(r.URL.Path, "/edit/")
 
-		var content string
-		if page, err := pp.GetPage(pageTitle); err == nil {
+		if err := r.ParseForm(); err != nil {
+			log.Printf("Error parsing form: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		draft := r.FormValue("draft")
+		var draftPage *Page
+		if draft != "" {
+			// Pages that haven't been changed in the draft yet are started from the published version
+			draftPage, _ = pp.GetDraftPage(draft, pageTitle)
+		} else if user := getUserForRequest(r); user != nil {
+			draft = defaultDraftName(user.Name)
+		}
+
+		var content, revision string
+		if draftPage != nil {
+			// Edits are based on the published version the draft was started from, so that publishing this edit
+			// directly merges in anything that's been published since
+			content = string(draftPage.Content)
+			_, revision, _ = pp.DraftInfo(draft)
+		} else if page, err := pp.GetPage(pageTitle); err == nil {
 			content = string(page.Content)
+			revision = page.LastModified.ChangeId
 		}
 
-		t.RenderEditPage(w, r, pageTitle, content)
+		t.RenderEditPage(w, r, pageTitle, content, revision, draft)
 	}
 }
 
 type PageEditor interface {
-	PutPage(title string, content []byte, user string, message string) error
+	PutPage(title string, baseRevision string, content []byte, user string, message string) error
+	PutDraftPage(draft, title string, content []byte, user, message string) error
+	DraftInfo(draft string) (owner, revision string, err error)
+	ProposePage(title, baseRevision string, content []byte, user, message string) (int, error)
+	NewChangeset() *Changeset
 }
 
-func SubmitPageHandler(pe PageEditor) http.HandlerFunc {
+// PathProtector determines whether a page may only be changed directly by admins.
+type PathProtector interface {
+	IsProtected(name string) bool
+}
+
+// needsReview determines whether a user's changes to the given pages must be submitted as a change request rather
+// than published directly.
+func needsReview(pp PathProtector, user *config.User, titles ...string) bool {
+	if user != nil && user.Has(config.PermissionAdmin) {
+		return false
+	}
+
+	for i := range titles {
+		if pp.IsProtected(titles[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func SubmitPageHandler(t *Templates, pe PageEditor, ul UserLister, pp PathProtector) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		pageTitle := strings.TrimPrefix(request.URL.Path, "/edit/")
 
-		if err := request.ParseForm(); err != nil {
+		if err := request.ParseMultipartForm(1 << 30); err != nil && err != http.ErrNotMultipart {
 			log.Printf("Error parsing form: %v", err)
 			writer.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
 		content := request.FormValue("content")
+		revision := request.FormValue("revision")
 		message := request.FormValue("message")
 		username := "Anonymoose"
-		if user := getUserForRequest(request); user != nil {
+		user := getUserForRequest(request)
+		if user != nil {
 			username = user.Name
 		}
 
-		if err := pe.PutPage(pageTitle, []byte(content), username, message); err != nil {
+		var attachments []*multipart.FileHeader
+		if request.MultipartForm != nil {
+			attachments = request.MultipartForm.File["attachments"]
+		}
+
+		switch request.FormValue("action") {
+		case "draft":
+			saveDraft(writer, request, pe, ul, user, pageTitle, attachments, []byte(content), username, message)
+			return
+		case "request":
+			proposeEdit(writer, request, pe, pageTitle, attachments, revision, []byte(content), username, message)
+			return
+		}
+
+		if needsReview(pp, user, pageTitle) {
+			proposeEdit(writer, request, pe, pageTitle, attachments, revision, []byte(content), username, message)
+			return
+		}
+
+		var err error
+		if len(attachments) == 0 {
+			err = pe.PutPage(pageTitle, revision, []byte(content), username, message)
+		} else {
+			// Commit the page along with its attachments, so they all appear at once
+			changeset := pe.NewChangeset()
+			if err := stageAttachments(changeset, attachments); err != nil {
+				log.Printf("Unable to save attachments: %v", err)
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			err = changeset.PutPage(pageTitle, revision, []byte(content))
+			if err == nil {
+				err = changeset.Commit(username, message)
+			}
+		}
+
+		var conflict *EditConflictError
+		if errors.As(err, &conflict) {
+			t.RenderEditConflict(writer, request, pageTitle, string(conflict.Content), conflict.Revision, message)
+		} else if err != nil {
 			// TODO: We should probably send an error to the client
 			log.Printf("Error saving page: %v\n", err)
 		} else {
@@ -103,8 +221,126 @@ func SubmitPageHandler(pe PageEditor) http.HandlerFunc {
 	}
 }
 
+// saveDraft stores an edit in a draft instead of publishing it, then shows the draft version of the page.
+func saveDraft(w http.ResponseWriter, r *http.Request, pe PageEditor, ul UserLister, user *config.User, pageTitle string, attachments []*multipart.FileHeader, content []byte, username, message string) {
+	if len(attachments) > 0 {
+		// Files aren't covered by drafts, so they'd be visible before the page that uses them
+		log.Printf("Unable to attach files to a draft of %s", pageTitle)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	draft := r.FormValue("draft")
+	if draft == "" {
+		draft = defaultDraftName(username)
+	}
+
+	if allowed, err := canWriteDraft(pe, ul, user, username, draft); errors.Is(err, ErrInvalidDraftName) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Printf("Error checking draft %s: %v\n", draft, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !allowed {
+		log.Printf("User %s tried to save to draft %s", username, draft)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if err := pe.PutDraftPage(draft, pageTitle, content, username, message); errors.Is(err, ErrInvalidDraftName) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Printf("Error saving draft: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	putSessionKey(w, r, sessionNoticeKey, fmt.Sprintf("Saved to draft %s", draft))
+	w.Header().Add("Location", fmt.Sprintf("/view/%s?draft=%s", pageTitle, url.QueryEscape(draft)))
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+// canWriteDraft determines whether a user may save changes to a draft. Drafts belong to the user who started
+// them, although admins may change any draft. Anyone may start a new draft, except under the name that another
+// user's changes are saved to by default.
+func canWriteDraft(pe PageEditor, ul UserLister, user *config.User, username, draft string) (bool, error) {
+	if user != nil && user.Has(config.PermissionAdmin) {
+		return true, nil
+	}
+
+	owner, _, err := pe.DraftInfo(draft)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	} else if owner != "" {
+		return owner == username, nil
+	} else if draft == defaultDraftName(username) {
+		return true, nil
+	}
+
+	for _, other := range ul.Users() {
+		if defaultDraftName(other.Name) == draft {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// proposeEdit creates a change request for an edit instead of publishing it, then shows the request for review.
+func proposeEdit(w http.ResponseWriter, r *http.Request, pe PageEditor, pageTitle string, attachments []*multipart.FileHeader, revision string, content []byte, username, message string) {
+	if len(attachments) > 0 {
+		log.Printf("Unable to attach files to a change request for %s", pageTitle)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	id, err := pe.ProposePage(pageTitle, revision, content, username, message)
+	if errors.Is(err, ErrNoChanges) {
+		putSessionKey(w, r, sessionErrorKey, "The page wasn't changed, so there is nothing to review")
+		w.Header().Add("Location", fmt.Sprintf("/view/%s", pageTitle))
+		w.WriteHeader(http.StatusSeeOther)
+		return
+	} else if err != nil {
+		log.Printf("Error creating change request: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	showChangeRequest(w, r, id)
+}
+
+// showChangeRequest tells the user that their change has been submitted for review, and shows them the request.
+func showChangeRequest(w http.ResponseWriter, r *http.Request, id int) {
+	putSessionKey(w, r, sessionNoticeKey, "Your change has been submitted for review")
+	w.Header().Add("Location", fmt.Sprintf("/wiki/requests/%d", id))
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+func stageAttachments(changeset *Changeset, attachments []*multipart.FileHeader) error {
+	for i := range attachments {
+		name := attachments[i].Filename
+		if !strings.ContainsRune(name, '.') {
+			return fmt.Errorf("invalid file name: %s", name)
+		}
+
+		file, err := attachments[i].Open()
+		if err != nil {
+			return err
+		}
+
+		err = changeset.PutFile(name, file)
+		_ = file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type DeletePageProvider interface {
 	DeletePage(name string, message string, user string) error
+	NewChangeset() *Changeset
 }
 
 func DeletePageConfirmHandler(t *Templates) http.HandlerFunc {
@@ -114,7 +350,7 @@ func DeletePageConfirmHandler(t *Templates) http.HandlerFunc {
 	}
 }
 
-func DeletePageHandler(provider DeletePageProvider) http.HandlerFunc {
+func DeletePageHandler(provider DeletePageProvider, pp PathProtector) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		name := strings.TrimPrefix(request.URL.Path, "/delete/")
 
@@ -131,9 +367,31 @@ func DeletePageHandler(provider DeletePageProvider) http.HandlerFunc {
 		}
 		message := request.FormValue("message")
 		username := "Anonymoose"
-		if user := getUserForRequest(request); user != nil {
+		user := getUserForRequest(request)
+		if user != nil {
 			username = user.Name
 		}
+
+		if needsReview(pp, user, name) {
+			if message == "" {
+				message = fmt.Sprintf("Delete %s", name)
+			}
+
+			var id int
+			changeset := provider.NewChangeset()
+			err := changeset.DeletePage(name)
+			if err == nil {
+				id, err = changeset.Propose(username, message)
+			}
+			if err != nil {
+				log.Printf("Unable to propose deleting %s: %v", name, err)
+				writer.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			showChangeRequest(writer, request, id)
+			return
+		}
+
 		err := provider.DeletePage(name, message, username)
 		if err != nil {
 			writer.WriteHeader(http.StatusInternalServerError)
@@ -145,7 +403,14 @@ func DeletePageHandler(provider DeletePageProvider) http.HandlerFunc {
 }
 
 type RenamePageProvider interface {
-	RenamePage(name string, newName string, message string, user string) error
+	RenamePage(name string, newName string, message string, user string, redirect bool) error
+	ListPages() ([]string, error)
+	GetPage(title string) (*Page, error)
+	NewChangeset() *Changeset
+}
+
+type LinkRewriter interface {
+	RewriteWikiLinks(markdown []byte, from, to string) ([]byte, int)
 }
 
 func RenamePageConfirmHandler(backend PageExists, t *Templates) http.HandlerFunc {
@@ -159,7 +424,7 @@ func RenamePageConfirmHandler(backend PageExists, t *Templates) http.HandlerFunc
 	}
 }
 
-func RenamePageHandler(provider RenamePageProvider) http.HandlerFunc {
+func RenamePageHandler(t *Templates, provider RenamePageProvider, rewriter LinkRewriter, pp PathProtector) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		if err := request.ParseForm(); err != nil {
 			log.Printf("Error parsing form: %v", err)
@@ -175,18 +440,192 @@ func RenamePageHandler(provider RenamePageProvider) http.HandlerFunc {
 		}
 		message := request.FormValue("message")
 		username := "Anonymoose"
-		if user := getUserForRequest(request); user != nil {
+		user := getUserForRequest(request)
+		if user != nil {
 			username = user.Name
 		}
-		err := provider.RenamePage(name, newName, message, username)
-		if err != nil {
+
+		redirect := request.FormValue("redirect") != ""
+		review := needsReview(pp, user, name, newName)
+
+		var id int
+		var err error
+		if request.FormValue("updateLinks") == "" && !review {
+			err = provider.RenamePage(name, newName, message, username, redirect)
+		} else {
+			var rewrites []*LinkRewrite
+			if request.FormValue("updateLinks") != "" {
+				rewrites, err = findLinkRewrites(provider, rewriter, name, newName)
+				if err != nil {
+					log.Printf("Unable to find links to %s: %v", name, err)
+					writer.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+
+				if request.FormValue("confirm") == "" {
+					t.RenderRenamePreview(writer, request, name, newName, message, redirect, rewrites)
+					return
+				}
+			}
+
+			// Rename the page and update the links to it in one commit, so there's no point at which they're broken
+			changeset := provider.NewChangeset()
+			err = changeset.RenamePage(name, newName)
+			for i := 0; err == nil && i < len(rewrites); i++ {
+				review = review || needsReview(pp, user, rewrites[i].Page)
+				err = changeset.PutPage(rewrites[i].Page, rewrites[i].Revision, rewrites[i].Content)
+			}
+			if err == nil && redirect {
+				err = changeset.PutPage(name, "", redirectContent(newName))
+			}
+			if err == nil && review {
+				if message == "" {
+					message = fmt.Sprintf("Rename %s to %s", name, newName)
+				}
+				id, err = changeset.Propose(username, message)
+			} else if err == nil {
+				err = changeset.Commit(username, message)
+			}
+		}
+
+		var conflict *EditConflictError
+		if errors.As(err, &conflict) {
+			putSessionKey(writer, request, sessionErrorKey, "A page linking to this one was changed at the same time; please try again")
+			http.Redirect(writer, request, "/rename/"+name, http.StatusSeeOther)
+			return
+		} else if errors.Is(err, fs.ErrExist) {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to rename %s: %s already exists", name, newName))
+			http.Redirect(writer, request, "/rename/"+name, http.StatusSeeOther)
+			return
+		} else if err != nil {
 			writer.WriteHeader(http.StatusInternalServerError)
 			return
+		} else if review {
+			showChangeRequest(writer, request, id)
+			return
 		}
 		http.Redirect(writer, request, "/view/"+newName, http.StatusSeeOther)
 	}
 }
 
+// LinkRewrite describes the changes needed to a page so that its links follow a renamed page.
+type LinkRewrite struct {
+	// Page is the title of the page once the rename has happened.
+	Page     string
+	Links    int
+	Content  []byte
+	Revision string
+}
+
+// findLinkRewrites looks for every page that links to the given page, and works out its content with the links
+// updated to point to the page's new name.
+func findLinkRewrites(provider RenamePageProvider, rewriter LinkRewriter, name, newName string) ([]*LinkRewrite, error) {
+	pages, err := provider.ListPages()
+	if err != nil {
+		return nil, err
+	}
+
+	var rewrites []*LinkRewrite
+	for i := range pages {
+		page, err := provider.GetPage(pages[i])
+		if err != nil {
+			return nil, err
+		}
+
+		content, count := rewriter.RewriteWikiLinks(page.Content, name, newName)
+		if count == 0 {
+			continue
+		}
+
+		rewrite := &LinkRewrite{
+			Page:     pages[i],
+			Links:    count,
+			Content:  content,
+			Revision: page.LastModified.ChangeId,
+		}
+		if strings.EqualFold(pages[i], name) {
+			// The page links to itself, so the links need updating after it has been moved
+			rewrite.Page = newName
+			rewrite.Revision = ""
+		}
+		rewrites = append(rewrites, rewrite)
+	}
+	return rewrites, nil
+}
+
+type RenameDirectoryProvider interface {
+	DirectoryContents(dir string) ([]string, []string, error)
+	RenameDirectory(dir string, newDir string, message string, user string) error
+}
+
+func RenameDirectoryConfirmHandler(t *Templates, provider RenameDirectoryProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dir := strings.TrimPrefix(r.URL.Path, "/renamedir/")
+		pages, files, err := provider.DirectoryContents(dir)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		t.RenderRenameDirectory(w, r, dir, pages, files)
+	}
+}
+
+func RenameDirectoryHandler(provider RenameDirectoryProvider) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if err := request.ParseForm(); err != nil {
+			log.Printf("Error parsing form: %v", err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		dir := strings.TrimPrefix(request.URL.Path, "/renamedir/")
+		newDir := request.FormValue("newName")
+		if newDir == "" {
+			http.Redirect(writer, request, "/renamedir/"+dir, http.StatusSeeOther)
+			return
+		}
+		message := request.FormValue("message")
+		username := "Anonymoose"
+		if user := getUserForRequest(request); user != nil {
+			username = user.Name
+		}
+
+		err := provider.RenameDirectory(dir, newDir, message, username)
+		if errors.Is(err, fs.ErrNotExist) {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		} else if errors.Is(err, fs.ErrExist) {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to move %s: %v", dir, err))
+			http.Redirect(writer, request, "/renamedir/"+dir, http.StatusSeeOther)
+			return
+		} else if err != nil {
+			log.Printf("Unable to move directory %s to %s: %v", dir, newDir, err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("Moved %s to %s", dir, newDir))
+		http.Redirect(writer, request, "/wiki/index", http.StatusSeeOther)
+	}
+}
+
+type RedirectProvider interface {
+	Redirects() ([]*Redirect, error)
+}
+
+func RedirectsHandler(t *Templates, rp RedirectProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		redirects, err := rp.Redirects()
+		if err != nil {
+			log.Printf("Failed to list redirects: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		t.RenderRedirects(w, r, redirects)
+	}
+}
+
 type RevertPageProvider interface {
 	RevertPage(name, revision, user, message string) error
 }
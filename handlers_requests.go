@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type ChangeRequestProvider interface {
+	ChangeRequestDiff(id int, title string, options DiffOptions) (*TextDiff, error)
+	ListChangeRequests() ([]*ChangeRequest, error)
+	GetChangeRequest(id int) (*ChangeRequest, error)
+	CommentOnChangeRequest(id int, user, text string) error
+	ApproveChangeRequest(id int, user, message string) error
+	RejectChangeRequest(id int, user, reason string) error
+}
+
+func ListChangeRequestsHandler(t *Templates, crp ChangeRequestProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requests, err := crp.ListChangeRequests()
+		if err != nil {
+			log.Printf("Failed to list change requests: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		t.RenderChangeRequests(w, r, requests)
+	}
+}
+
+func ViewChangeRequestHandler(t *Templates, crp ChangeRequestProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		request, err := crp.GetChangeRequest(id)
+		if errors.Is(err, fs.ErrNotExist) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Failed to get change request %d: %v\n", id, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var diffs []*PageDiff
+		for i := range request.Pages {
+			diff, err := crp.ChangeRequestDiff(id, request.Pages[i], DiffOptions{Context: defaultDiffContext})
+			if err != nil {
+				log.Printf("Error getting diff: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			diffs = append(diffs, &PageDiff{Page: request.Pages[i], Diff: diff})
+		}
+
+		t.RenderChangeRequest(w, r, request, diffs)
+	}
+}
+
+func CommentOnChangeRequestHandler(crp ChangeRequestProvider) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(request)["id"])
+		if err != nil {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if err := request.ParseForm(); err != nil {
+			log.Printf("Error parsing form: %v", err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		text := strings.TrimSpace(request.FormValue("comment"))
+		if text == "" {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		username := "Anonymoose"
+		if user := getUserForRequest(request); user != nil {
+			username = user.Name
+		}
+
+		if err := crp.CommentOnChangeRequest(id, username, text); errors.Is(err, fs.ErrNotExist) {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Unable to comment on change request %d: %v", id, err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writer.Header().Add("location", fmt.Sprintf("/wiki/requests/%d", id))
+		writer.WriteHeader(http.StatusSeeOther)
+	}
+}
+
+func ReviewChangeRequestHandler(crp ChangeRequestProvider) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(request)["id"])
+		if err != nil {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if err := request.ParseForm(); err != nil {
+			log.Printf("Error parsing form: %v", err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		username := "Anonymoose"
+		if user := getUserForRequest(request); user != nil {
+			username = user.Name
+		}
+
+		var notice string
+		switch request.FormValue("action") {
+		case "approve":
+			err = crp.ApproveChangeRequest(id, username, request.FormValue("message"))
+			notice = fmt.Sprintf("Approved and published change request #%d", id)
+		case "reject":
+			err = crp.RejectChangeRequest(id, username, strings.TrimSpace(request.FormValue("message")))
+			notice = fmt.Sprintf("Rejected change request #%d", id)
+		default:
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var conflict *MergeConflictError
+		if errors.Is(err, fs.ErrNotExist) {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		} else if errors.Is(err, ErrChangeRequestClosed) {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Change request #%d has already been closed", id))
+		} else if errors.As(err, &conflict) {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf(
+				"Unable to approve change request #%d: these pages have been changed since it was made: %s",
+				id,
+				strings.Join(conflict.Conflicts, ", "),
+			))
+		} else if err != nil {
+			log.Printf("Unable to review change request %d: %v", id, err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			putSessionKey(writer, request, sessionNoticeKey, notice)
+		}
+
+		writer.Header().Add("location", fmt.Sprintf("/wiki/requests/%d", id))
+		writer.WriteHeader(http.StatusSeeOther)
+	}
+}
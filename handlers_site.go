@@ -4,6 +4,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/mdbot/wiki/config"
 )
@@ -58,16 +59,24 @@ func UpdateSiteConfigHandler(updater SiteUpdater) http.HandlerFunc {
 			return
 		}
 
+		protectedPaths := []string{}
+		for _, line := range strings.Split(request.FormValue("protected"), "\n") {
+			if line = strings.Trim(strings.TrimSpace(line), "/"); line != "" {
+				protectedPaths = append(protectedPaths, line)
+			}
+		}
+
 		username := "Anonymoose"
 		if user := getUserForRequest(request); user != nil {
 			username = user.Name
 		}
 
 		if err := updater.Update(&config.Site{
-			Name:     siteName,
-			Favicon:  favicon,
-			MainLogo: mainLogo,
-			DarkLogo: darkLogo,
+			Name:           siteName,
+			Favicon:        favicon,
+			MainLogo:       mainLogo,
+			DarkLogo:       darkLogo,
+			ProtectedPaths: protectedPaths,
 		}, username); err != nil {
 			log.Printf("Manage site: unable to save new config: %v", err)
 			writer.WriteHeader(http.StatusInternalServerError)
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/gorilla/mux"
+	"github.com/mdbot/wiki/markdown"
+)
+
+type SnapshotProvider interface {
+	ListSnapshots() ([]*Snapshot, error)
+	CreateSnapshot(name, revision, user, message string) error
+	DeleteSnapshot(name string) error
+}
+
+type SnapshotViewer interface {
+	Snapshot(name string) (*SnapshotView, error)
+}
+
+type SnapshotRenderer interface {
+	RenderAt(markdown []byte, checker markdown.PageChecker, linkPrefix string) (string, error)
+}
+
+// snapshotPrefix returns the prefix of the URLs used to browse the given snapshot.
+func snapshotPrefix(name string) string {
+	return fmt.Sprintf("/snapshot/%s", name)
+}
+
+func ListSnapshotsHandler(t *Templates, sp SnapshotProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if at := r.URL.Query().Get("at"); at != "" {
+			http.Redirect(w, r, fmt.Sprintf("%s/wiki/index", snapshotPrefix(at)), http.StatusSeeOther)
+			return
+		}
+
+		snapshots, err := sp.ListSnapshots()
+		if err != nil {
+			log.Printf("Failed to list snapshots: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		t.RenderSnapshots(w, r, snapshots)
+	}
+}
+
+func ModifySnapshotHandler(sp SnapshotProvider) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if err := request.ParseForm(); err != nil {
+			log.Printf("Error parsing form: %v", err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		name := strings.ToLower(strings.TrimSpace(request.FormValue("name")))
+		username := "Anonymoose"
+		if user := getUserForRequest(request); user != nil {
+			username = user.Name
+		}
+
+		var err error
+		var notice string
+		switch request.FormValue("action") {
+		case "create":
+			err = sp.CreateSnapshot(name, strings.TrimSpace(request.FormValue("rev")), username, request.FormValue("message"))
+			notice = fmt.Sprintf("Created snapshot %s", name)
+		case "delete":
+			err = sp.DeleteSnapshot(name)
+			notice = fmt.Sprintf("Deleted snapshot %s", name)
+		default:
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if errors.Is(err, ErrInvalidSnapshotName) {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf(
+				"Unable to create snapshot %s: names may only contain lower case letters, numbers, dots, hyphens and underscores",
+				name,
+			))
+		} else if errors.Is(err, fs.ErrExist) {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to create snapshot %s: it already exists", name))
+		} else if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to create snapshot %s: no such change", name))
+		} else if errors.Is(err, fs.ErrNotExist) {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Unable to modify snapshot %s: %v", name, err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			putSessionKey(writer, request, sessionNoticeKey, notice)
+		}
+
+		writer.Header().Add("location", "/wiki/snapshots")
+		writer.WriteHeader(http.StatusSeeOther)
+	}
+}
+
+func SnapshotPageHandler(t *Templates, renderer SnapshotRenderer, sv SnapshotViewer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["snapshot"]
+		view, err := sv.Snapshot(name)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		prefix := snapshotPrefix(name)
+		pageTitle := strings.TrimPrefix(r.URL.Path, fmt.Sprintf("%s/view/", prefix))
+		page, err := view.GetPage(pageTitle)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.URL.Query().Get("redirect") != "no" {
+			if target, ok := parseRedirect(page.Content); ok {
+				putSessionKey(w, r, sessionNoticeKey, fmt.Sprintf("Redirected from %s", pageTitle))
+				http.Redirect(w, r, redirectLocation(prefix, target), http.StatusSeeOther)
+				return
+			}
+		}
+
+		content, err := renderer.RenderAt(page.Content, view, prefix)
+		if err != nil {
+			log.Printf("Failed to render markdown: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		t.RenderSnapshotPage(w, r, name, pageTitle, content, &LastModifiedDetails{
+			User: page.LastModified.User,
+			Time: page.LastModified.Time,
+		})
+	}
+}
+
+func SnapshotFileHandler(sv SnapshotViewer) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		name := mux.Vars(request)["snapshot"]
+		view, err := sv.Snapshot(name)
+		if err != nil {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		fileName := strings.TrimPrefix(request.URL.Path, fmt.Sprintf("%s/files/view/", snapshotPrefix(name)))
+		reader, err := view.GetFile(fileName)
+		if err != nil {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		serveFile(writer, fileName, reader)
+	}
+}
+
+func SnapshotPageListHandler(t *Templates, sv SnapshotViewer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["snapshot"]
+		view, err := sv.Snapshot(name)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		pages, err := view.ListPages()
+		if err != nil {
+			log.Printf("Failed to list pages in snapshot %s: %v\n", name, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		t.RenderSnapshotPageList(w, r, name, pages)
+	}
+}
+
+func SnapshotFileListHandler(t *Templates, sv SnapshotViewer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["snapshot"]
+		view, err := sv.Snapshot(name)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		files, err := view.ListFiles()
+		if err != nil {
+			log.Printf("Failed to list files in snapshot %s: %v\n", name, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		t.RenderSnapshotFileList(w, r, name, files)
+	}
+}
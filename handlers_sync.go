@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+type SyncProvider interface {
+	SyncStatus() *SyncStatus
+	Sync() error
+	ResolveSyncConflicts(keepLocal bool, user string) error
+}
+
+func ViewSyncHandler(t *Templates, sp SyncProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t.RenderSync(w, r, sp.SyncStatus())
+	}
+}
+
+func SyncHandler(sp SyncProvider) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if err := request.ParseForm(); err != nil {
+			log.Printf("Error parsing form: %v", err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if sp.SyncStatus() == nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		username := "Anonymoose"
+		if user := getUserForRequest(request); user != nil {
+			username = user.Name
+		}
+
+		var err error
+		switch request.FormValue("action") {
+		case "sync":
+			err = sp.Sync()
+		case "keeplocal":
+			err = sp.ResolveSyncConflicts(true, username)
+		case "keepremote":
+			err = sp.ResolveSyncConflicts(false, username)
+		default:
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if err != nil {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to synchronise: %v", err))
+		} else {
+			putSessionKey(writer, request, sessionNoticeKey, "Synchronised with remote repository")
+		}
+
+		writer.Header().Add("location", "/wiki/sync")
+		writer.WriteHeader(http.StatusSeeOther)
+	}
+}
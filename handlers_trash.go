@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type TrashProvider interface {
+	DeletedItems() ([]*DeletedItem, error)
+	Undelete(name string, isPage bool, user, message string) error
+}
+
+func TrashHandler(t *Templates, tp TrashProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		items, err := tp.DeletedItems()
+		if err != nil {
+			log.Printf("Failed to list deleted items: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		t.RenderTrash(w, r, items)
+	}
+}
+
+func UndeleteHandler(tp TrashProvider) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if err := request.ParseForm(); err != nil {
+			log.Printf("Error parsing form: %v", err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		name := strings.TrimSpace(request.FormValue("name"))
+		if name == "" {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		isPage := request.FormValue("type") == "page"
+
+		username := "Anonymoose"
+		if user := getUserForRequest(request); user != nil {
+			username = user.Name
+		}
+
+		location := "/wiki/trash"
+		if err := tp.Undelete(name, isPage, username, request.FormValue("message")); errors.Is(err, fs.ErrExist) {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to restore %s: it has already been recreated", name))
+		} else if errors.Is(err, fs.ErrNotExist) {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Unable to restore %s: %v", name, err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("Restored %s", name))
+			if isPage {
+				location = fmt.Sprintf("/view/%s", name)
+			} else {
+				location = "/wiki/files"
+			}
+		}
+
+		writer.Header().Add("location", location)
+		writer.WriteHeader(http.StatusSeeOther)
+	}
+}
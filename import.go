@@ -0,0 +1,250 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// importLinkPattern matches inline markdown links and images: [text](target "title") or ![alt](target).
+	importLinkPattern = regexp.MustCompile(`(!?)\[([^\]]*)\]\(<?([^)\s>]+)>?(\s+"[^"]*")?\)`)
+	// importFencePattern matches the start or end of a fenced code block, where links shouldn't be converted.
+	importFencePattern = regexp.MustCompile("^\\s*(```|~~~)")
+
+	// ErrInvalidImport is returned if the pages and files being imported can't be added to the wiki, for example
+	// because a path isn't allowed or two names are the same once lower cased.
+	ErrInvalidImport = errors.New("invalid import")
+)
+
+// ImportedFile is a page or file to be added to the wiki by a bulk import. Name is the path within the import.
+type ImportedFile struct {
+	Name    string
+	Content []byte
+}
+
+// ImportTarget is where imported pages and files are committed.
+type ImportTarget interface {
+	NewChangeset() *Changeset
+	PageExists(title string) bool
+}
+
+// ImportResult describes what was added to the wiki by an import.
+type ImportResult struct {
+	Pages []string
+	Files []string
+}
+
+// readImportDir reads every file within a directory to be imported. Hidden files and directories are skipped.
+func readImportDir(dir string) ([]*ImportedFile, error) {
+	var files []*ImportedFile
+	return files, filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if p != dir && isHiddenImport(entry.Name()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, &ImportedFile{Name: filepath.ToSlash(rel), Content: content})
+		return nil
+	})
+}
+
+// readImportZip reads every file within a zip archive to be imported. Hidden files and directories are skipped.
+func readImportZip(r *zip.Reader) ([]*ImportedFile, error) {
+	var files []*ImportedFile
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || hasHiddenImportPart(f.Name) {
+			continue
+		}
+
+		reader, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(reader)
+		_ = reader.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, &ImportedFile{Name: f.Name, Content: content})
+	}
+	return files, nil
+}
+
+// isHiddenImport determines whether a file or directory should be left out of an import, such as version control
+// directories and the metadata added to zip files by macOS.
+func isHiddenImport(name string) bool {
+	return strings.HasPrefix(name, ".") || name == "__MACOSX"
+}
+
+func hasHiddenImportPart(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if isHiddenImport(part) {
+			return true
+		}
+	}
+	return false
+}
+
+// importName converts a path within an import to the name it will have in the wiki, inside the given directory.
+// Names are lower cased, and markdown files are given the .md extension used for pages.
+func importName(dir, name string) string {
+	name = strings.ToLower(path.Join(dir, name))
+	if path.Ext(name) == ".markdown" {
+		name = strings.TrimSuffix(name, ".markdown") + ".md"
+	}
+	return name
+}
+
+// Import adds the given pages and files to the wiki in a single commit, inside the given directory (which may be
+// empty for the top level). Relative links between the imported pages are converted to wiki links, and relative
+// links to imported files are made to point at the uploaded files. If any of the names isn't allowed, or two of
+// them clash once lower cased, an error wrapping ErrInvalidImport is returned and nothing is imported.
+func Import(target ImportTarget, files []*ImportedFile, dir, user, message string) (*ImportResult, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: there's nothing to import", ErrInvalidImport)
+	}
+
+	names := make(map[string]string)
+	for _, f := range files {
+		name := importName(dir, f.Name)
+		if previous, ok := names[name]; ok {
+			return nil, fmt.Errorf("%w: %s and %s would have the same name", ErrInvalidImport, previous, f.Name)
+		}
+		names[name] = f.Name
+	}
+
+	result := &ImportResult{}
+	changes := target.NewChangeset()
+	for _, f := range files {
+		name := importName(dir, f.Name)
+
+		var err error
+		if path.Ext(name) == ".md" {
+			title := strings.TrimSuffix(name, ".md")
+			content := convertImportLinks(f.Content, path.Dir(name), func(name string) bool {
+				_, ok := names[name]
+				return ok || (path.Ext(name) == ".md" && target.PageExists(strings.TrimSuffix(name, ".md")))
+			})
+			err = changes.PutPage(title, "", content)
+			result.Pages = append(result.Pages, title)
+		} else {
+			err = changes.PutFile(name, bytes.NewReader(f.Content))
+			result.Files = append(result.Files, name)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidImport, f.Name, err)
+		}
+	}
+
+	if message == "" {
+		message = fmt.Sprintf("Imported %d pages and %d files", len(result.Pages), len(result.Files))
+	}
+	if err := changes.Commit(user, message); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(result.Pages)
+	sort.Strings(result.Files)
+	return result, nil
+}
+
+// convertImportLinks rewrites relative markdown links in a page being imported into the given directory. Links to
+// pages become wiki links, and links to files point to where they're served from. Links that don't refer to
+// anything that exists are left alone, as are links within code.
+func convertImportLinks(content []byte, dir string, exists func(name string) bool) []byte {
+	lines := strings.SplitAfter(string(content), "\n")
+	inFence := false
+	for i := range lines {
+		if importFencePattern.MatchString(lines[i]) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+
+		// Odd numbered parts are within inline code
+		parts := strings.Split(lines[i], "`")
+		for j := 0; j < len(parts); j += 2 {
+			parts[j] = importLinkPattern.ReplaceAllStringFunc(parts[j], func(match string) string {
+				return convertImportLink(match, dir, exists)
+			})
+		}
+		lines[i] = strings.Join(parts, "`")
+	}
+	return []byte(strings.Join(lines, ""))
+}
+
+func convertImportLink(match, dir string, exists func(name string) bool) string {
+	parts := importLinkPattern.FindStringSubmatch(match)
+	image, text, link := parts[1] == "!", parts[2], parts[3]
+
+	if strings.Contains(link, ":") || strings.HasPrefix(link, "/") || strings.HasPrefix(link, "#") {
+		return match
+	}
+
+	link, fragment, hasFragment := strings.Cut(link, "#")
+	if hasFragment {
+		fragment = "#" + fragment
+	}
+	link, err := url.PathUnescape(link)
+	if err != nil {
+		return match
+	}
+
+	name := importName(dir, link)
+	if strings.HasPrefix(name, "../") || !exists(name) {
+		return match
+	}
+
+	if path.Ext(name) != ".md" {
+		return fmt.Sprintf("%s[%s](%s%s)", parts[1], text, escapeImportPath("/files/view/"+name), parts[4])
+	}
+
+	title := strings.TrimSuffix(name, ".md")
+	switch {
+	case image || fragment != "" || strings.ContainsAny(text, "|[]"):
+		return fmt.Sprintf("%s[%s](%s%s%s)", parts[1], text, escapeImportPath("/view/"+title), fragment, parts[4])
+	case text == "" || strings.EqualFold(text, title):
+		return fmt.Sprintf("[[%s]]", title)
+	default:
+		return fmt.Sprintf("[[%s|%s]]", title, text)
+	}
+}
+
+// escapeImportPath escapes a path for use as a markdown link destination.
+func escapeImportPath(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// mediaWikiSiteInfo is the part of a MediaWiki XML export's site information that's imported.
+type mediaWikiSiteInfo struct {
+	Namespaces []struct {
+		Key  int    `xml:"key,attr"`
+		Name string `xml:",chardata"`
+	} `xml:"namespaces>namespace"`
+}
+
+type mediaWikiPage struct {
+	Title     string               `xml:"title"`
+	Namespace int                  `xml:"ns"`
+	Revisions []*mediaWikiRevision `xml:"revision"`
+	Uploads   []*mediaWikiUpload   `xml:"upload"`
+}
+
+type mediaWikiContributor struct {
+	Username string `xml:"username"`
+	IP       string `xml:"ip"`
+}
+
+// name returns the name that changes made by the contributor are attributed to. Edits made without logging in are
+// attributed to the IP address they came from.
+func (c mediaWikiContributor) name() string {
+	switch {
+	case c.Username != "":
+		return c.Username
+	case c.IP != "":
+		return c.IP
+	default:
+		return "Unknown"
+	}
+}
+
+type mediaWikiRevision struct {
+	Timestamp   time.Time            `xml:"timestamp"`
+	Contributor mediaWikiContributor `xml:"contributor"`
+	Comment     string               `xml:"comment"`
+	Model       string               `xml:"model"`
+	Text        struct {
+		Deleted string `xml:"deleted,attr"`
+		Content string `xml:",chardata"`
+	} `xml:"text"`
+}
+
+type mediaWikiUpload struct {
+	Timestamp   time.Time            `xml:"timestamp"`
+	Contributor mediaWikiContributor `xml:"contributor"`
+	Comment     string               `xml:"comment"`
+	Filename    string               `xml:"filename"`
+	Contents    struct {
+		Encoding string `xml:"encoding,attr"`
+		Data     string `xml:",chardata"`
+	} `xml:"contents"`
+}
+
+// mediaWikiChange is a single revision of a page, or upload of a file, to be replayed. The contents of files found
+// in the uploads directory are read from upload when the change is made.
+type mediaWikiChange struct {
+	time    time.Time
+	user    string
+	message string
+	page    string
+	file    string
+	content []byte
+	upload  string
+}
+
+// MediaWikiImportTarget is where the history in a MediaWiki export is replayed.
+type MediaWikiImportTarget interface {
+	ImportTarget
+	ReplayChanges(changes []*ReplayedChange, committer string) (int, error)
+}
+
+// MediaWikiImportResult describes what was added to the wiki by a MediaWiki import.
+type MediaWikiImportResult struct {
+	Pages     []string
+	Files     []string
+	Revisions int
+	// MissingFiles are the names of uploaded files whose contents weren't included in the export or found in the
+	// uploads directory.
+	MissingFiles []string
+}
+
+// ImportMediaWiki imports the pages and files in a MediaWiki XML export into the given directory (which may be
+// empty for the top level). Each revision of each page, and each upload of each file, is replayed in the order
+// they were made as a separate commit, attributed to the original author and dated at the original time, so the
+// pages' histories match those on the old wiki. The commits are signed by user as the committer. Pages are
+// converted to markdown as they're imported.
+//
+// Exports only contain the contents of uploaded files if they were made with the --include-files option. For other
+// exports, uploads may be given an FS containing the files (such as MediaWiki's images directory), which will be
+// searched for them by name. The File pages that describe uploaded files aren't imported.
+//
+// If the export can't be read, or any of the titles aren't allowed, an error wrapping ErrInvalidImport is returned
+// and nothing is imported.
+func ImportMediaWiki(target MediaWikiImportTarget, r io.Reader, uploads fs.FS, dir, user string) (*MediaWikiImportResult, error) {
+	uploaded, err := findMediaWikiUploads(uploads)
+	if err != nil {
+		return nil, err
+	}
+
+	importer := &mediaWikiImport{
+		dir:      dir,
+		uploaded: uploaded,
+		pages:    make(map[string]string),
+		files:    make(map[string]string),
+		result:   &MediaWikiImportResult{},
+	}
+
+	// Exports can be far larger than the wiki they describe, so each page is read and converted in turn rather
+	// than decoding the whole export at once.
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: unable to read MediaWiki export: %v", ErrInvalidImport, err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "siteinfo":
+			info := &mediaWikiSiteInfo{}
+			if err := decoder.DecodeElement(info, &start); err != nil {
+				return nil, fmt.Errorf("%w: unable to read MediaWiki export: %v", ErrInvalidImport, err)
+			}
+			namespaces := make(map[int]string)
+			for _, ns := range info.Namespaces {
+				namespaces[ns.Key] = ns.Name
+			}
+			importer.converter = newWikitextConverter(dir, namespaces)
+		case "page":
+			page := &mediaWikiPage{}
+			if err := decoder.DecodeElement(page, &start); err != nil {
+				return nil, fmt.Errorf("%w: unable to read MediaWiki export: %v", ErrInvalidImport, err)
+			}
+			if err := importer.addPage(page); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	result := importer.result
+	if len(importer.changes) == 0 {
+		return nil, fmt.Errorf("%w: there's nothing to import", ErrInvalidImport)
+	}
+
+	// Stage everything once before replaying anything, so that invalid names are reported as such rather than
+	// as a failure part way through the history. The staged changes are never committed.
+	check := target.NewChangeset()
+	for _, title := range result.Pages {
+		if err := check.PutPage(title, "", nil); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidImport, title, err)
+		}
+	}
+	for _, name := range result.Files {
+		if err := check.PutFile(name, bytes.NewReader(nil)); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidImport, name, err)
+		}
+	}
+
+	sort.SliceStable(importer.changes, func(i, j int) bool {
+		return importer.changes[i].time.Before(importer.changes[j].time)
+	})
+
+	replayed := make([]*ReplayedChange, len(importer.changes))
+	for i, change := range importer.changes {
+		replayed[i] = change.replayed(uploads)
+	}
+
+	if result.Revisions, err = target.ReplayChanges(replayed, user); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(result.Pages)
+	sort.Strings(result.Files)
+	sort.Strings(result.MissingFiles)
+	return result, nil
+}
+
+// replayed returns the change to be made to the wiki, reading the contents of files from the uploads directory
+// only when it's made.
+func (c *mediaWikiChange) replayed(uploads fs.FS) *ReplayedChange {
+	change := &ReplayedChange{
+		Page:    c.page,
+		File:    c.file,
+		Author:  c.user,
+		Time:    c.time,
+		Message: c.message,
+	}
+	if c.upload != "" {
+		change.Open = func() (io.ReadCloser, error) {
+			return uploads.Open(c.upload)
+		}
+	} else {
+		change.Open = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(c.content)), nil
+		}
+	}
+	return change
+}
+
+// mediaWikiImport collects the changes to be replayed from each page of a MediaWiki export as it's read.
+type mediaWikiImport struct {
+	dir       string
+	converter *wikitextConverter
+	uploaded  map[string]string
+	// pages and files map the names that have been imported to the titles they were imported from.
+	pages   map[string]string
+	files   map[string]string
+	changes []*mediaWikiChange
+	result  *MediaWikiImportResult
+}
+
+func (i *mediaWikiImport) addPage(page *mediaWikiPage) error {
+	if i.converter == nil {
+		i.converter = newWikitextConverter(i.dir, nil)
+	}
+
+	if page.Namespace == mediaWikiFileNamespace {
+		name := i.converter.fileName(page.Title)
+		if previous, ok := i.files[name]; ok {
+			return fmt.Errorf("%w: %s and %s would have the same name", ErrInvalidImport, previous, page.Title)
+		}
+		i.files[name] = page.Title
+
+		fileChanges, err := mediaWikiFileChanges(name, page, i.uploaded)
+		if err != nil {
+			return err
+		}
+		if len(fileChanges) == 0 {
+			i.result.MissingFiles = append(i.result.MissingFiles, name)
+			return nil
+		}
+		i.result.Files = append(i.result.Files, name)
+		i.changes = append(i.changes, fileChanges...)
+		return nil
+	}
+
+	title := i.converter.pageTitle(page.Title)
+	if previous, ok := i.pages[title]; ok {
+		return fmt.Errorf("%w: %s and %s would have the same name", ErrInvalidImport, previous, page.Title)
+	}
+	i.pages[title] = page.Title
+
+	var imported bool
+	for _, revision := range page.Revisions {
+		if revision.Text.Deleted != "" || (revision.Model != "" && revision.Model != "wikitext") {
+			continue
+		}
+
+		message := revision.Comment
+		if message == "" {
+			message = fmt.Sprintf("Imported %s from MediaWiki", page.Title)
+		}
+		i.changes = append(i.changes, &mediaWikiChange{
+			time:    revision.Timestamp,
+			user:    revision.Contributor.name(),
+			message: message,
+			page:    title,
+			content: []byte(i.converter.Convert(revision.Text.Content)),
+		})
+		imported = true
+	}
+	if imported {
+		i.result.Pages = append(i.result.Pages, title)
+	}
+	return nil
+}
+
+// mediaWikiFileChanges returns the changes needed to add the file described by a page in the File namespace.
+// Every upload included in the export is returned; if the export doesn't include the file's contents but it's
+// found in the uploads directory, it's added at the time of the last upload, or of the page's first revision if
+// there are no uploads listed.
+func mediaWikiFileChanges(name string, page *mediaWikiPage, uploaded map[string]string) ([]*mediaWikiChange, error) {
+	var changes []*mediaWikiChange
+	for _, upload := range page.Uploads {
+		if upload.Contents.Data == "" {
+			continue
+		}
+		if upload.Contents.Encoding != "base64" {
+			return nil, fmt.Errorf("%w: %s: unknown encoding %s", ErrInvalidImport, page.Title, upload.Contents.Encoding)
+		}
+
+		content, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(upload.Contents.Data), ""))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidImport, page.Title, err)
+		}
+		change := mediaWikiUploadChange(name, upload.Timestamp, upload.Contributor, upload.Comment)
+		change.content = content
+		changes = append(changes, change)
+	}
+
+	source, ok := uploaded[path.Base(name)]
+	if len(changes) > 0 || !ok {
+		return changes, nil
+	}
+
+	var change *mediaWikiChange
+	switch {
+	case len(page.Uploads) > 0:
+		upload := page.Uploads[len(page.Uploads)-1]
+		change = mediaWikiUploadChange(name, upload.Timestamp, upload.Contributor, upload.Comment)
+	case len(page.Revisions) > 0:
+		revision := page.Revisions[0]
+		change = mediaWikiUploadChange(name, revision.Timestamp, revision.Contributor, revision.Comment)
+	default:
+		change = mediaWikiUploadChange(name, time.Now(), mediaWikiContributor{}, "")
+	}
+	change.upload = source
+	return []*mediaWikiChange{change}, nil
+}
+
+func mediaWikiUploadChange(name string, when time.Time, contributor mediaWikiContributor, comment string) *mediaWikiChange {
+	if comment == "" {
+		comment = fmt.Sprintf("Uploaded %s", path.Base(name))
+	}
+	return &mediaWikiChange{
+		time:    when,
+		user:    contributor.name(),
+		message: comment,
+		file:    name,
+	}
+}
+
+// findMediaWikiUploads finds the files within a MediaWiki uploads directory, returning their paths keyed by the
+// lower cased file name. MediaWiki keeps thumbnails and old versions of files in directories of their own, which
+// are skipped.
+func findMediaWikiUploads(uploads fs.FS) (map[string]string, error) {
+	files := make(map[string]string)
+	if uploads == nil {
+		return files, nil
+	}
+
+	return files, fs.WalkDir(uploads, ".", func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			switch entry.Name() {
+			case "thumb", "archive", "deleted", "temp", "lockdir":
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if !isHiddenImport(entry.Name()) {
+			files[strings.ToLower(entry.Name())] = p
+		}
+		return nil
+	})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+const testMediaWikiExport = `<mediawiki xmlns="http://www.mediawiki.org/xml/export-0.11/" version="0.11" xml:lang="en">
+  <siteinfo>
+    <sitename>Old wiki</sitename>
+    <namespaces>
+      <namespace key="0" case="first-letter" />
+      <namespace key="1" case="first-letter">Talk</namespace>
+      <namespace key="6" case="first-letter">File</namespace>
+    </namespaces>
+  </siteinfo>
+  <page>
+    <title>Main Page</title>
+    <ns>0</ns>
+    <revision>
+      <timestamp>2015-03-01T10:00:00Z</timestamp>
+      <contributor><username>Alice</username><id>1</id></contributor>
+      <comment>First version</comment>
+      <model>wikitext</model>
+      <text xml:space="preserve">Hello</text>
+    </revision>
+    <revision>
+      <timestamp>2016-07-12T08:30:00Z</timestamp>
+      <contributor><ip>192.0.2.1</ip></contributor>
+      <model>wikitext</model>
+      <text xml:space="preserve">== Welcome ==
+See [[Talk:Main Page|the talk page]] and [[File:Logo.png|thumb|The logo]]</text>
+    </revision>
+  </page>
+  <page>
+    <title>Talk:Main Page</title>
+    <ns>1</ns>
+    <revision>
+      <timestamp>2015-06-01T12:00:00Z</timestamp>
+      <contributor><username>Bob</username><id>2</id></contributor>
+      <comment>Question</comment>
+      <text xml:space="preserve">'''Why?'''</text>
+    </revision>
+    <revision>
+      <timestamp>2015-06-02T12:00:00Z</timestamp>
+      <contributor><username>Bob</username><id>2</id></contributor>
+      <comment>Null edit</comment>
+      <text xml:space="preserve">'''Why?'''</text>
+    </revision>
+  </page>
+  <page>
+    <title>File:Logo.png</title>
+    <ns>6</ns>
+    <revision>
+      <timestamp>2015-04-01T09:00:00Z</timestamp>
+      <contributor><username>Alice</username><id>1</id></contributor>
+      <text xml:space="preserve">Our logo</text>
+    </revision>
+    <upload>
+      <timestamp>2015-04-01T09:00:00Z</timestamp>
+      <contributor><username>Alice</username><id>1</id></contributor>
+      <comment>Logo</comment>
+      <filename>Logo.png</filename>
+      <contents encoding="base64">bG9nbw==</contents>
+    </upload>
+  </page>
+  <page>
+    <title>File:Other file.pdf</title>
+    <ns>6</ns>
+    <revision>
+      <timestamp>2015-05-01T09:00:00Z</timestamp>
+      <contributor><username>Bob</username><id>2</id></contributor>
+      <text xml:space="preserve">A document</text>
+    </revision>
+  </page>
+  <page>
+    <title>File:Missing.png</title>
+    <ns>6</ns>
+    <revision>
+      <timestamp>2015-05-01T09:00:00Z</timestamp>
+      <contributor><username>Bob</username><id>2</id></contributor>
+      <text xml:space="preserve">Gone</text>
+    </revision>
+  </page>
+</mediawiki>`
+
+func TestImportMediaWiki(t *testing.T) {
+	backend := newTestBackend(t)
+
+	uploads := fstest.MapFS{
+		"a/ab/Other_file.pdf":                {Data: []byte("pdf")},
+		"thumb/a/ab/Missing.png/Missing.png": {Data: []byte("thumbnail")},
+	}
+	result, err := ImportMediaWiki(backend, strings.NewReader(testMediaWikiExport), uploads, "old", "importer")
+	if err != nil {
+		t.Fatalf("ImportMediaWiki() error = %v", err)
+	}
+
+	if strings.Join(result.Pages, ",") != "old/main page,old/talk/main page" {
+		t.Errorf("ImportMediaWiki() pages = %v", result.Pages)
+	}
+	if strings.Join(result.Files, ",") != "old/logo.png,old/other_file.pdf" {
+		t.Errorf("ImportMediaWiki() files = %v", result.Files)
+	}
+	if strings.Join(result.MissingFiles, ",") != "old/missing.png" || result.Revisions != 5 {
+		t.Errorf("ImportMediaWiki() missing files = %v, revisions = %d", result.MissingFiles, result.Revisions)
+	}
+
+	page, err := backend.GetPage("old/main page")
+	if err != nil {
+		t.Fatalf("GetPage() error = %v", err)
+	}
+	if want := "## Welcome\n\nSee [[old/talk/main page|the talk page]] and ![[old/logo.png]] *The logo*\n"; string(page.Content) != want {
+		t.Errorf("GetPage() content = %q, want %q", page.Content, want)
+	}
+
+	history, err := backend.PageHistory("old/main page", "", 10)
+	if err != nil {
+		t.Fatalf("PageHistory() error = %v", err)
+	}
+	want := []struct {
+		user    string
+		time    time.Time
+		message string
+	}{
+		{"192.0.2.1", time.Date(2016, 7, 12, 8, 30, 0, 0, time.UTC), "Imported Main Page from MediaWiki"},
+		{"Alice", time.Date(2015, 3, 1, 10, 0, 0, 0, time.UTC), "First version"},
+	}
+	if len(history.Entries) != len(want) {
+		t.Fatalf("PageHistory() = %d entries, want %d", len(history.Entries), len(want))
+	}
+	for i := range want {
+		entry := history.Entries[i]
+		if entry.User != want[i].user || !entry.Time.Equal(want[i].time) || entry.Message != want[i].message {
+			t.Errorf("PageHistory() entry %d = %s at %v: %q, want %s at %v: %q", i, entry.User, entry.Time, entry.Message, want[i].user, want[i].time, want[i].message)
+		}
+	}
+
+	head, err := backend.headCommit()
+	if err != nil {
+		t.Fatalf("headCommit() error = %v", err)
+	}
+	if head.Committer.Name != "importer" || time.Since(head.Committer.When) > time.Minute {
+		t.Errorf("ImportMediaWiki() committer = %s at %v, want importer now", head.Committer.Name, head.Committer.When)
+	}
+
+	file, err := backend.GetFile("old/logo.png")
+	if err != nil {
+		t.Fatalf("GetFile() error = %v", err)
+	}
+	content, _ := io.ReadAll(file)
+	_ = file.Close()
+	if string(content) != "logo" {
+		t.Errorf("GetFile() = %q, want logo", content)
+	}
+
+	fileHistory, err := backend.FileHistory("old/other_file.pdf", "", 10)
+	if err != nil {
+		t.Fatalf("FileHistory() error = %v", err)
+	}
+	if len(fileHistory.Entries) != 1 || fileHistory.Entries[0].User != "Bob" {
+		t.Errorf("FileHistory() = %v", fileHistory.Entries)
+	}
+}
+
+func TestImportMediaWiki_Invalid(t *testing.T) {
+	backend := newTestBackend(t)
+
+	page := func(title string) string {
+		return `<page><title>` + title + `</title><ns>0</ns><revision><timestamp>2015-03-01T10:00:00Z</timestamp><text>x</text></revision></page>`
+	}
+	for _, export := range []string{
+		`<mediawiki><page>`,
+		`<mediawiki></mediawiki>`,
+		`<mediawiki>` + page("Valid") + page("100%") + `</mediawiki>`,
+		`<mediawiki>` + page("Foo bar") + page("Foo_Bar") + `</mediawiki>`,
+		`<mediawiki>` + page("Valid") + `<page><title>File:A.png</title><ns>6</ns></page><page><title>File:a.png</title><ns>6</ns></page></mediawiki>`,
+	} {
+		if _, err := ImportMediaWiki(backend, strings.NewReader(export), nil, "", "importer"); !errors.Is(err, ErrInvalidImport) {
+			t.Errorf("ImportMediaWiki(%s) error = %v, want ErrInvalidImport", export, err)
+		}
+	}
+	if backend.PageExists("valid") || backend.PageExists("foo bar") {
+		t.Error("Invalid import added pages")
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConvertImportLinks(t *testing.T) {
+	exists := func(name string) bool {
+		return name == "docs/other.md" || name == "readme.md" || name == "docs/images/my pic.png"
+	}
+
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"See [Other](other.md).", "See [[docs/other|Other]]."},
+		{"See [docs/other](Other.md)", "See [[docs/other]]"},
+		{"Up [readme](../README.md)", "Up [[readme]]"},
+		{"[Section](other.md#usage \"Title\")", "[Section](/view/docs/other#usage \"Title\")"},
+		{"![Picture](images/my%20pic.png)", "![Picture](/files/view/docs/images/my%20pic.png)"},
+		{"[Missing](missing.md) [Web](https://example.com/other.md) [Abs](/view/x)", "[Missing](missing.md) [Web](https://example.com/other.md) [Abs](/view/x)"},
+		{"`[Code](other.md)` [Other](other.md)", "`[Code](other.md)` [[docs/other|Other]]"},
+		{"```\n[Code](other.md)\n```\n[Other](other.md)\n", "```\n[Code](other.md)\n```\n[[docs/other|Other]]\n"},
+	}
+	for _, tt := range tests {
+		if got := string(convertImportLinks([]byte(tt.content), "docs", exists)); got != tt.want {
+			t.Errorf("convertImportLinks(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	backend := newTestBackend(t)
+
+	files := []*ImportedFile{
+		{Name: "Guide/Intro.markdown", Content: []byte("Read [the setup](Setup.md) and ![logo](Logo.PNG)")},
+		{Name: "Guide/Setup.md", Content: []byte("Setup")},
+		{Name: "Guide/Logo.PNG", Content: []byte("image")},
+	}
+	result, err := Import(backend, files, "imported", "importer", "")
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if len(result.Pages) != 2 || result.Pages[0] != "imported/guide/intro" || len(result.Files) != 1 || result.Files[0] != "imported/guide/logo.png" {
+		t.Errorf("Import() = %v, %v", result.Pages, result.Files)
+	}
+
+	page, err := backend.GetPage("imported/guide/intro")
+	if err != nil {
+		t.Fatalf("GetPage() error = %v", err)
+	}
+	if want := "Read [[imported/guide/setup|the setup]] and ![logo](/files/view/imported/guide/logo.png)"; string(page.Content) != want {
+		t.Errorf("GetPage() = %q, want %q", page.Content, want)
+	}
+	if page.LastModified.User != "importer" || page.LastModified.Message != "Imported 2 pages and 1 files" {
+		t.Errorf("GetPage() last modified = %v", page.LastModified)
+	}
+
+	history, err := backend.PageHistory("imported/guide/setup", "", 10)
+	if err != nil {
+		t.Fatalf("PageHistory() error = %v", err)
+	}
+	if len(history.Entries) != 1 || history.Entries[0].ChangeId != page.LastModified.ChangeId {
+		t.Errorf("Import() made more than one commit: %v", history.Entries)
+	}
+
+	file, err := backend.GetFile("imported/guide/logo.png")
+	if err != nil {
+		t.Fatalf("GetFile() error = %v", err)
+	}
+	_ = file.Close()
+
+	for _, bad := range [][]*ImportedFile{
+		{{Name: "../escape.md", Content: []byte("x")}},
+		{{Name: ".wiki/users.json.enc", Content: []byte("x")}},
+		{{Name: "Page.md", Content: []byte("x")}, {Name: "page.md", Content: []byte("y")}},
+		{{Name: "100%.md", Content: []byte("x")}},
+		nil,
+	} {
+		if _, err := Import(backend, bad, "", "importer", ""); !errors.Is(err, ErrInvalidImport) {
+			t.Errorf("Import(%v) error = %v, want ErrInvalidImport", bad, err)
+		}
+	}
+	if backend.PageExists("escape") || backend.PageExists("page") {
+		t.Error("Invalid import added pages")
+	}
+}
@@ -42,6 +42,10 @@ var configKey = flag.String("key", "", "Key to use to encrypt config data (32 by
 var requireAuthForWrites = flag.Bool("authenticated-writes", true, "Whether to require authentication to make changes to pages/files")
 var requireAuthForReads = flag.Bool("authenticated-reads", false, "Whether to require authentication to read pages/files")
 var dangerousHtml = flag.Bool("allow-dangerous-html", false, "Whether to allow dangerous HTML such as script tags")
+var bare = flag.Bool("bare", false, "Whether to store data in a bare git repository, with no working tree, when creating a new one")
+var remote = flag.String("remote", "", "URL of a git repository to push changes to and pull changes from")
+var syncInterval = flag.Duration("sync-interval", 5*time.Minute, "How often to pull changes from the remote repository")
+var watchInterval = flag.Duration("watch-interval", 10*time.Second, "How often to check for commits made directly in the data directory")
 
 func main() {
 	err := envflag.Parse()
@@ -55,7 +59,12 @@ func main() {
 
 	initFileSystem()
 
-	gitBackend, err := NewGitBackend(*workDir)
+	gitBackend, err := NewGitBackend(*workDir, GitOptions{
+		Bare:          *bare,
+		Remote:        *remote,
+		SyncInterval:  *syncInterval,
+		WatchInterval: *watchInterval,
+	})
 	if err != nil {
 		log.Fatalf("Unable to open working directory: %s", err.Error())
 	}
@@ -96,7 +105,11 @@ func main() {
 		siteConfig: siteConfig,
 		checker:    pm,
 		version:    version,
-		sidebarProvider: func() string {
+		sidebarProvider: func(snapshot string) string {
+			if snapshot != "" {
+				return snapshotSidebar(gitBackend, renderer, snapshot)
+			}
+
 			p, err := gitBackend.GetPage("_sidebar")
 			if err != nil {
 				log.Printf("Unable to load sidebar content: %v", err)
@@ -111,30 +124,87 @@ func main() {
 
 			return s
 		},
+		syncStatus: gitBackend.SyncStatus,
+	}
+
+	exporter := NewStaticExporter(templates, renderer, siteConfig, staticFiles)
+
+	if flag.NArg() > 0 {
+		err := runCommand(&commandEnv{
+			backend:  gitBackend,
+			exporter: exporter,
+		}, flag.Args())
+		if closeErr := gitBackend.Close(); closeErr != nil {
+			log.Printf("Unable to close working directory: %s", closeErr.Error())
+		}
+		if err != nil {
+			log.Fatalf("Unable to run %s: %v", flag.Arg(0), err)
+		}
+		return
 	}
 
 	wikiRouter := mux.NewRouter()
 	wikiRouter.Use(LowerCaseCanonical)
 
+	gitRouter := wikiRouter.PathPrefix("/wiki.git").Subrouter()
+	gitRouter.Use(BasicAuthHandler(userManager))
+	// The repository includes the wiki's config, such as the users and their password hashes, so only admins may clone it
+	gitRouter.Path("/info/refs").Queries("service", UploadPackService).Handler(pm.RequireAdmin(GitInfoRefsHandler(gitBackend, UploadPackService))).Methods(http.MethodGet)
+	gitRouter.Path("/info/refs").Queries("service", ReceivePackService).Handler(pm.RequireWrite(GitInfoRefsHandler(gitBackend, ReceivePackService))).Methods(http.MethodGet)
+	gitRouter.Path("/" + UploadPackService).Handler(pm.RequireAdmin(GitUploadPackHandler(gitBackend))).Methods(http.MethodPost)
+	gitRouter.Path("/" + ReceivePackService).Handler(pm.RequireWrite(GitReceivePackHandler(gitBackend))).Methods(http.MethodPost)
+
 	wikiRouter.PathPrefix("/edit/").Handler(pm.RequireWrite(EditPageHandler(templates, gitBackend))).Methods(http.MethodGet)
-	wikiRouter.PathPrefix("/edit/").Handler(pm.RequireWrite(SubmitPageHandler(gitBackend))).Methods(http.MethodPost)
+	wikiRouter.PathPrefix("/edit/").Handler(pm.RequireWrite(SubmitPageHandler(templates, gitBackend, userManager, siteConfig))).Methods(http.MethodPost)
+	wikiRouter.PathPrefix("/view/").Queries("draft", "{draft}").Handler(pm.RequireWrite(ViewPageHandler(templates, renderer, gitBackend))).Methods(http.MethodGet)
 	wikiRouter.PathPrefix("/view/").Handler(pm.RequireRead(ViewPageHandler(templates, renderer, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix("/blame/").Handler(pm.RequireRead(BlameHandler(templates, gitBackend))).Methods(http.MethodGet)
 	wikiRouter.PathPrefix("/history/").Handler(pm.RequireRead(PageHistoryHandler(templates, gitBackend))).Methods(http.MethodGet)
 	wikiRouter.PathPrefix("/files/view/").Handler(pm.RequireRead(FileHandler(gitBackend))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix("/files/history/").Handler(pm.RequireRead(FileHistoryHandler(templates, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix("/files/compare/").Handler(pm.RequireRead(CompareFileHandler(templates))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix("/files/revert/").Handler(pm.RequireWrite(RevertFileConfirmHandler(templates))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix("/files/revert/").Handler(pm.RequireWrite(RevertFileHandler(gitBackend))).Methods(http.MethodPost)
 	wikiRouter.PathPrefix("/files/delete/").Handler(pm.RequireWrite(DeleteFileConfirmHandler(templates))).Methods(http.MethodGet)
 	wikiRouter.PathPrefix("/files/delete/").Handler(pm.RequireWrite(DeleteFileHandler(gitBackend))).Methods(http.MethodPost)
 	wikiRouter.PathPrefix("/delete/").Handler(pm.RequireWrite(DeletePageConfirmHandler(templates))).Methods(http.MethodGet)
-	wikiRouter.PathPrefix("/delete/").Handler(pm.RequireWrite(DeletePageHandler(gitBackend))).Methods(http.MethodPost)
+	wikiRouter.PathPrefix("/delete/").Handler(pm.RequireWrite(DeletePageHandler(gitBackend, siteConfig))).Methods(http.MethodPost)
 	wikiRouter.PathPrefix("/rename/").Handler(pm.RequireWrite(RenamePageConfirmHandler(gitBackend, templates))).Methods(http.MethodGet)
-	wikiRouter.PathPrefix("/rename/").Handler(pm.RequireWrite(RenamePageHandler(gitBackend))).Methods(http.MethodPost)
+	wikiRouter.PathPrefix("/rename/").Handler(pm.RequireWrite(RenamePageHandler(templates, gitBackend, renderer, siteConfig))).Methods(http.MethodPost)
+	wikiRouter.PathPrefix("/renamedir/").Handler(pm.RequireWrite(RenameDirectoryConfirmHandler(templates, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix("/renamedir/").Handler(pm.RequireWrite(RenameDirectoryHandler(gitBackend))).Methods(http.MethodPost)
 	wikiRouter.PathPrefix("/revert/").Handler(pm.RequireWrite(RevertPageConfirmHandler(templates))).Methods(http.MethodGet)
 	wikiRouter.PathPrefix("/revert/").Handler(pm.RequireWrite(RevertPageHandler(gitBackend))).Methods(http.MethodPost)
 	wikiRouter.PathPrefix("/diff/").Handler(pm.RequireRead(DiffPageHandler(templates, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix("/patch/").Handler(pm.RequireRead(PatchHandler(gitBackend))).Methods(http.MethodGet)
+	wikiRouter.Path("/api/changes").Handler(pm.RequireWrite(ApiChangesHandler(gitBackend))).Methods(http.MethodPost)
 	wikiRouter.Path("/api/list").Handler(pm.RequireRead(ApiListHandler(gitBackend))).Methods(http.MethodGet)
 	wikiRouter.Path("/wiki/account").Handler(pm.RequireAccount(AccountHandler(templates))).Methods(http.MethodGet)
 	wikiRouter.Path("/wiki/account").Handler(pm.RequireAccount(ModifyAccountHandler(userManager))).Methods(http.MethodPost)
 	wikiRouter.Path("/wiki/index").Handler(pm.RequireRead(ListPagesHandler(templates, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/snapshots").Handler(pm.RequireRead(ListSnapshotsHandler(templates, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/snapshots").Handler(pm.RequireAdmin(ModifySnapshotHandler(gitBackend))).Methods(http.MethodPost)
+	wikiRouter.PathPrefix("/snapshot/{snapshot}/view/").Handler(pm.RequireRead(SnapshotPageHandler(templates, renderer, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix("/snapshot/{snapshot}/files/view/").Handler(pm.RequireRead(SnapshotFileHandler(gitBackend))).Methods(http.MethodGet)
+	wikiRouter.Path("/snapshot/{snapshot}/wiki/index").Handler(pm.RequireRead(SnapshotPageListHandler(templates, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.Path("/snapshot/{snapshot}/wiki/files").Handler(pm.RequireRead(SnapshotFileListHandler(templates, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/drafts").Handler(pm.RequireWrite(ListDraftsHandler(templates, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/drafts").Handler(pm.RequireWrite(ModifyDraftHandler(gitBackend))).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/trash").Handler(pm.RequireWrite(TrashHandler(templates, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/trash").Handler(pm.RequireWrite(UndeleteHandler(gitBackend))).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/redirects").Handler(pm.RequireAdmin(RedirectsHandler(templates, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/requests").Handler(pm.RequireWrite(ListChangeRequestsHandler(templates, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/requests/{id:[0-9]+}").Handler(pm.RequireWrite(ViewChangeRequestHandler(templates, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/requests/{id:[0-9]+}/comment").Handler(pm.RequireWrite(CommentOnChangeRequestHandler(gitBackend))).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/requests/{id:[0-9]+}/review").Handler(pm.RequireAdmin(ReviewChangeRequestHandler(gitBackend))).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/archive").Handler(pm.RequireRead(ArchiveHandler(gitBackend))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/import").Handler(pm.RequireAdmin(ImportFormHandler(templates))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/import").Handler(pm.RequireAdmin(ImportHandler(gitBackend))).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/import/mediawiki").Handler(pm.RequireAdmin(MediaWikiImportHandler(gitBackend))).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/export").Handler(pm.RequireAdmin(ExportHandler(exporter, gitBackend))).Methods(http.MethodGet)
 	wikiRouter.Path("/wiki/files").Handler(pm.RequireRead(ListFilesHandler(templates, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/changes/revert").Handler(pm.RequireWrite(RevertChangeConfirmHandler(templates))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/changes/revert").Handler(pm.RequireWrite(RevertChangeHandler(gitBackend))).Methods(http.MethodPost)
 	wikiRouter.Path("/wiki/changes").Handler(pm.RequireRead(RecentChangesHandler(templates, gitBackend))).Methods(http.MethodGet)
 	wikiRouter.Path("/wiki/changes.xml").Handler(pm.RequireRead(RecentChangesFeed(templates, gitBackend))).Methods(http.MethodGet)
 	wikiRouter.Path("/wiki/logo/favicon").Handler(ServeFavicon(siteConfig)).Methods(http.MethodGet)
@@ -147,6 +217,8 @@ func main() {
 	wikiRouter.Path("/wiki/search").Handler(pm.RequireRead(SearchHandler(templates, gitBackend))).Methods(http.MethodGet)
 	wikiRouter.Path("/wiki/site").Handler(pm.RequireAdmin(ViewSiteConfigHandler(templates))).Methods(http.MethodGet)
 	wikiRouter.Path("/wiki/site").Handler(pm.RequireAdmin(UpdateSiteConfigHandler(siteConfig))).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/sync").Handler(pm.RequireAdmin(ViewSyncHandler(templates, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/sync").Handler(pm.RequireAdmin(SyncHandler(gitBackend))).Methods(http.MethodPost)
 	wikiRouter.Path("/wiki/users").Handler(pm.RequireAdmin(ManageUsersHandler(templates, userManager))).Methods(http.MethodGet)
 	wikiRouter.Path("/wiki/users").Handler(pm.RequireAdmin(ModifyUserHandler(userManager))).Methods(http.MethodPost)
 
@@ -179,9 +251,35 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Unable to shutdown: %s", err.Error())
 	}
+	if err := gitBackend.Close(); err != nil {
+		log.Printf("Unable to close working directory: %s", err.Error())
+	}
 	log.Print("Finishing server.")
 }
 
+// snapshotSidebar renders the sidebar as it was in the given snapshot, with its links kept within the snapshot.
+func snapshotSidebar(b *GitBackend, renderer *markdown.Renderer, snapshot string) string {
+	view, err := b.Snapshot(snapshot)
+	if err != nil {
+		log.Printf("Unable to open snapshot %s for sidebar: %v", snapshot, err)
+		return "Error loading sidebar"
+	}
+
+	p, err := view.GetPage("_sidebar")
+	if err != nil {
+		// The sidebar may not have existed yet
+		return ""
+	}
+
+	s, err := renderer.RenderAt(p.Content, view, snapshotPrefix(snapshot))
+	if err != nil {
+		log.Printf("Unable to render sidebar content: %v", err)
+		return "Error rendering sidebar"
+	}
+
+	return s
+}
+
 func initFileSystem() {
 	staticFs, _ := fs.Sub(embeddedFiles, "resources/static")
 	staticFiles = merged_fs.NewMergedFS(os.DirFS("resources/static"), staticFs)
@@ -212,7 +310,7 @@ func createDefaultPages(b *GitBackend) error {
 					return err
 				}
 
-				if err := b.PutPage(name, bs, "system", "Creating default page"); err != nil {
+				if err := b.PutPage(name, "", bs, "system", "Creating default page"); err != nil {
 					return err
 				}
 			}
@@ -27,7 +27,7 @@ func (w *embedParser) Trigger() []byte {
 	return []byte{'!'}
 }
 
-func (w *embedParser) Parse(_ ast.Node, block text.Reader, _ parser.Context) ast.Node {
+func (w *embedParser) Parse(_ ast.Node, block text.Reader, pc parser.Context) ast.Node {
 	line, _ := block.PeekLine()
 
 	if len(line) < 2 || line[1] != '[' || line[2] != '[' {
@@ -45,7 +45,7 @@ func (w *embedParser) Parse(_ ast.Node, block text.Reader, _ parser.Context) ast
 	for m, v := range mimePrefixes {
 		if strings.HasPrefix(mimeType, m) {
 			block.Advance(endIndex + 2)
-			element := newMediaEmbed(v, fmt.Sprintf("/files/view/%s", target))
+			element := newMediaEmbed(v, fmt.Sprintf("%s/files/view/%s", linkPrefix(pc), target))
 			return element
 		}
 	}
@@ -17,6 +17,19 @@ type PageChecker interface {
 	PageExists(name string) bool
 }
 
+var (
+	// checkerKey overrides the PageChecker used for wiki links while rendering.
+	checkerKey = parser.NewContextKey()
+	// linkPrefixKey holds a prefix to add to links to pages and files while rendering.
+	linkPrefixKey = parser.NewContextKey()
+)
+
+// linkPrefix returns the prefix to add to links to pages and files, if one was given for this render.
+func linkPrefix(pc parser.Context) string {
+	prefix, _ := pc.Get(linkPrefixKey).(string)
+	return prefix
+}
+
 type Renderer struct {
 	checker    PageChecker
 	gm         goldmark.Markdown
@@ -52,8 +65,22 @@ func NewRenderer(checker PageChecker, dangerousHtml bool, codeStyle string) *Ren
 }
 
 func (r *Renderer) Render(markdown []byte) (string, error) {
+	return r.render(markdown)
+}
+
+// RenderAt renders markdown as it appeared in a snapshot of the wiki. Wiki links are checked against the given
+// checker instead of the current pages, and links to pages and embedded files are prefixed with linkPrefix so that
+// they stay within the snapshot.
+func (r *Renderer) RenderAt(markdown []byte, checker PageChecker, linkPrefix string) (string, error) {
+	pc := parser.NewContext()
+	pc.Set(checkerKey, checker)
+	pc.Set(linkPrefixKey, linkPrefix)
+	return r.render(markdown, parser.WithContext(pc))
+}
+
+func (r *Renderer) render(markdown []byte, opts ...parser.ParseOption) (string, error) {
 	b := &bytes.Buffer{}
-	if err := r.gm.Convert(markdown, b); err != nil {
+	if err := r.gm.Convert(markdown, b, opts...); err != nil {
 		return "", err
 	}
 	if r.htmlPolicy != nil {
@@ -3,6 +3,7 @@ package markdown
 import (
 	"bytes"
 	"fmt"
+	"strings"
 
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/ast"
@@ -11,6 +12,17 @@ import (
 	"github.com/yuin/goldmark/util"
 )
 
+// WikiLink describes a [[Target]] or [[Target|Label]] link found in a page.
+type WikiLink struct {
+	Target string
+	// Start and End are the byte offsets of the link's target within the page source.
+	Start int
+	End   int
+}
+
+// wikiLinksKey is used to collect the wiki links found while parsing, if the context has a slice to collect into.
+var wikiLinksKey = parser.NewContextKey()
+
 type wikiLinkParser struct {
 	checker PageChecker
 }
@@ -25,7 +37,7 @@ func (w *wikiLinkParser) Trigger() []byte {
 	return []byte{'['}
 }
 
-func (w *wikiLinkParser) Parse(_ ast.Node, block text.Reader, _ parser.Context) ast.Node {
+func (w *wikiLinkParser) Parse(_ ast.Node, block text.Reader, pc parser.Context) ast.Node {
 	line, segment := block.PeekLine()
 
 	if len(line) == 0 || line[1] != '[' {
@@ -48,10 +60,23 @@ func (w *wikiLinkParser) Parse(_ ast.Node, block text.Reader, _ parser.Context)
 		target = line[2:pipeIndex]
 	}
 
+	if links, ok := pc.Get(wikiLinksKey).(*[]WikiLink); ok {
+		*links = append(*links, WikiLink{
+			Target: string(target),
+			Start:  segment.Start + 2,
+			End:    segment.Start + 2 + len(target),
+		})
+	}
+
 	link := ast.NewLink()
 	link.Title = target
-	link.Destination = []byte(fmt.Sprintf("/view/%s", target))
-	if w.checker.PageExists(string(target)) {
+	checker := w.checker
+	if c, ok := pc.Get(checkerKey).(PageChecker); ok {
+		checker = c
+	}
+
+	link.Destination = []byte(fmt.Sprintf("%s/view/%s", linkPrefix(pc), target))
+	if checker.PageExists(string(target)) {
 		link.SetAttributeString("class", []byte("wikilink"))
 	} else {
 		link.SetAttributeString("class", []byte("wikilink newpage"))
@@ -81,3 +106,35 @@ func (e *wikiLinkExtension) Extend(m goldmark.Markdown) {
 		util.Prioritized(newWikiLinkParser(e.checker), 102),
 	))
 }
+
+// WikiLinks returns all the wiki links in the given markdown, parsed in exactly the same way as when rendering, so
+// that things that look like links in code blocks or embeds are ignored.
+func (r *Renderer) WikiLinks(markdown []byte) []WikiLink {
+	var links []WikiLink
+	pc := parser.NewContext()
+	pc.Set(wikiLinksKey, &links)
+	r.gm.Parser().Parse(text.NewReader(markdown), parser.WithContext(pc))
+	return links
+}
+
+// RewriteWikiLinks changes the target of every wiki link to the page from so that it points to the page to instead,
+// leaving any labels alone. It returns the new markdown and the number of links that were changed.
+func (r *Renderer) RewriteWikiLinks(markdown []byte, from, to string) ([]byte, int) {
+	var result []byte
+	var count, last int
+	for _, link := range r.WikiLinks(markdown) {
+		if !strings.EqualFold(link.Target, from) {
+			continue
+		}
+
+		result = append(result, markdown[last:link.Start]...)
+		result = append(result, to...)
+		last = link.End
+		count++
+	}
+
+	if count == 0 {
+		return markdown, 0
+	}
+	return append(result, markdown[last:]...), count
+}
@@ -0,0 +1,63 @@
+package markdown
+
+import "testing"
+
+type noPages struct{}
+
+func (noPages) PageExists(string) bool {
+	return false
+}
+
+func TestRenderer_RewriteWikiLinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+		count    int
+	}{
+		{"plain link", "See [[Old]] for more", "See [[new/page]] for more", 1},
+		{"labelled link", "See [[old|the old page]].", "See [[new/page|the old page]].", 1},
+		{"several links", "[[old]] and [[Old]] but not [[older]]", "[[new/page]] and [[new/page]] but not [[older]]", 2},
+		{"code is ignored", "`[[old]]`\n\n    [[old]]\n", "`[[old]]`\n\n    [[old]]\n", 0},
+		{"embeds are ignored", "![[old.png]] [[old]]", "![[old.png]] [[new/page]]", 1},
+	}
+
+	r := NewRenderer(noPages{}, false, "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, count := r.RewriteWikiLinks([]byte(tt.markdown), "old", "new/page")
+			if string(got) != tt.want || count != tt.count {
+				t.Errorf("RewriteWikiLinks() = %q, %d, want %q, %d", got, count, tt.want, tt.count)
+			}
+		})
+	}
+}
+
+type allPages struct{}
+
+func (allPages) PageExists(string) bool {
+	return true
+}
+
+func TestRenderer_RenderAt(t *testing.T) {
+	// Allow HTML through unsanitised, so the link classes can be checked
+	r := NewRenderer(noPages{}, true, "")
+
+	got, err := r.RenderAt([]byte("[[page]] ![[image.png]]"), allPages{}, "/snapshot/v1")
+	if err != nil {
+		t.Fatalf("RenderAt() error = %v", err)
+	}
+	want := `<p><a href="/snapshot/v1/view/page" title="page" class="wikilink">page</a> <img src="/snapshot/v1/files/view/image.png" class="embed"></p>` + "\n"
+	if got != want {
+		t.Errorf("RenderAt() = %q, want %q", got, want)
+	}
+
+	// Rendering normally afterwards shouldn't be affected
+	got, err = r.Render([]byte("[[page]]"))
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if want := `<p><a href="/view/page" title="page" class="wikilink newpage">page</a></p>` + "\n"; got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+}
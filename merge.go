@@ -0,0 +1,145 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// hunk describes a change between two versions of a text, replacing the lines [start, end) of the original
+// with new lines.
+type hunk struct {
+	start int
+	end   int
+	lines []string
+}
+
+// splitLines splits text into lines, retaining the line endings.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// lineHunks calculates the changes needed to turn the original text into the modified one, line by line.
+func lineHunks(original, modified string) []hunk {
+	dmp := diffmatchpatch.New()
+	a, b, lineArray := dmp.DiffLinesToRunes(original, modified)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMainRunes(a, b, false), lineArray)
+
+	var hunks []hunk
+	var current *hunk
+	pos := 0
+	for i := range diffs {
+		lines := splitLines(diffs[i].Text)
+		if diffs[i].Type == diffmatchpatch.DiffEqual {
+			if current != nil {
+				hunks = append(hunks, *current)
+				current = nil
+			}
+			pos += len(lines)
+			continue
+		}
+
+		if current == nil {
+			current = &hunk{start: pos, end: pos}
+		}
+
+		if diffs[i].Type == diffmatchpatch.DiffDelete {
+			current.end += len(lines)
+			pos += len(lines)
+		} else {
+			current.lines = append(current.lines, lines...)
+		}
+	}
+
+	if current != nil {
+		hunks = append(hunks, *current)
+	}
+	return hunks
+}
+
+// applyHunks returns the lines [start, end) of the original with the given hunks applied.
+func applyHunks(original []string, hunks []hunk, start, end int) []string {
+	var res []string
+	pos := start
+	for i := range hunks {
+		res = append(res, original[pos:hunks[i].start]...)
+		res = append(res, hunks[i].lines...)
+		pos = hunks[i].end
+	}
+	return append(res, original[pos:end]...)
+}
+
+// mergeText performs a three-way merge of two modified versions of the same base text. Changes that don't touch
+// the same lines are combined; changes that do are included in the output between conflict markers using the
+// given labels, and the returned bool is false.
+func mergeText(base, ours, theirs []byte, ourLabel, theirLabel string) ([]byte, bool) {
+	baseLines := splitLines(string(base))
+	ourHunks := lineHunks(string(base), string(ours))
+	theirHunks := lineHunks(string(base), string(theirs))
+
+	var out []string
+	clean := true
+	pos, i, j := 0, 0, 0
+	for i < len(ourHunks) || j < len(theirHunks) {
+		var groupOurs, groupTheirs []hunk
+		var start, end int
+
+		// Start a group with whichever change comes first, then pull in any changes from either side that touch it.
+		if j >= len(theirHunks) || (i < len(ourHunks) && ourHunks[i].start <= theirHunks[j].start) {
+			start, end = ourHunks[i].start, ourHunks[i].end
+			groupOurs = append(groupOurs, ourHunks[i])
+			i++
+		} else {
+			start, end = theirHunks[j].start, theirHunks[j].end
+			groupTheirs = append(groupTheirs, theirHunks[j])
+			j++
+		}
+
+		for {
+			if i < len(ourHunks) && ourHunks[i].start <= end {
+				end = max(end, ourHunks[i].end)
+				groupOurs = append(groupOurs, ourHunks[i])
+				i++
+			} else if j < len(theirHunks) && theirHunks[j].start <= end {
+				end = max(end, theirHunks[j].end)
+				groupTheirs = append(groupTheirs, theirHunks[j])
+				j++
+			} else {
+				break
+			}
+		}
+
+		out = append(out, baseLines[pos:start]...)
+		pos = end
+
+		ourLines := applyHunks(baseLines, groupOurs, start, end)
+		theirLines := applyHunks(baseLines, groupTheirs, start, end)
+		if len(groupTheirs) == 0 || strings.Join(ourLines, "") == strings.Join(theirLines, "") {
+			out = append(out, ourLines...)
+		} else if len(groupOurs) == 0 {
+			out = append(out, theirLines...)
+		} else {
+			clean = false
+			out = append(out, "<<<<<<< "+ourLabel+"\n")
+			out = append(out, terminateLines(ourLines)...)
+			out = append(out, "=======\n")
+			out = append(out, terminateLines(theirLines)...)
+			out = append(out, ">>>>>>> "+theirLabel+"\n")
+		}
+	}
+
+	out = append(out, baseLines[pos:]...)
+	return []byte(strings.Join(out, "")), clean
+}
+
+// terminateLines ensures the last of the given lines ends with a line break.
+func terminateLines(lines []string) []string {
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		lines[len(lines)-1] += "\n"
+	}
+	return lines
+}
@@ -0,0 +1,106 @@
+package main
+
+import "testing"
+
+func Test_mergeText(t *testing.T) {
+	tests := []struct {
+		name      string
+		base      string
+		ours      string
+		theirs    string
+		want      string
+		wantClean bool
+	}{
+		{
+			"no changes",
+			"a\nb\nc\n",
+			"a\nb\nc\n",
+			"a\nb\nc\n",
+			"a\nb\nc\n",
+			true,
+		},
+		{
+			"only our changes",
+			"a\nb\nc\n",
+			"a\nB\nc\n",
+			"a\nb\nc\n",
+			"a\nB\nc\n",
+			true,
+		},
+		{
+			"only their changes",
+			"a\nb\nc\n",
+			"a\nb\nc\n",
+			"a\nb\nC\n",
+			"a\nb\nC\n",
+			true,
+		},
+		{
+			"separate changes",
+			"a\nb\nc\nd\ne\n",
+			"A\nb\nc\nd\ne\n",
+			"a\nb\nc\nd\nE\n",
+			"A\nb\nc\nd\nE\n",
+			true,
+		},
+		{
+			"identical changes",
+			"a\nb\nc\n",
+			"a\nB\nc\n",
+			"a\nB\nc\n",
+			"a\nB\nc\n",
+			true,
+		},
+		{
+			"insertion and deletion",
+			"a\nb\nc\nd\ne\n",
+			"a\nnew\nb\nc\nd\ne\n",
+			"a\nb\nc\nd\n",
+			"a\nnew\nb\nc\nd\n",
+			true,
+		},
+		{
+			"overlapping changes",
+			"a\nb\nc\n",
+			"a\nours\nc\n",
+			"a\ntheirs\nc\n",
+			"a\n<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\nc\n",
+			false,
+		},
+		{
+			"both appending",
+			"a\n",
+			"a\nours",
+			"a\ntheirs\n",
+			"a\n<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\n",
+			false,
+		},
+		{
+			"edit and delete",
+			"a\nb\nc\n",
+			"a\nB\nc\n",
+			"a\nc\n",
+			"a\n<<<<<<< ours\nB\n=======\n>>>>>>> theirs\nc\n",
+			false,
+		},
+		{
+			"empty base",
+			"",
+			"ours\n",
+			"",
+			"ours\n",
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, clean := mergeText([]byte(tt.base), []byte(tt.ours), []byte(tt.theirs), "ours", "theirs")
+			if string(got) != tt.want {
+				t.Errorf("mergeText() got = %q, want %q", got, tt.want)
+			}
+			if clean != tt.wantClean {
+				t.Errorf("mergeText() clean = %v, want %v", clean, tt.wantClean)
+			}
+		})
+	}
+}
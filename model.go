@@ -3,10 +3,52 @@ package main
 import "time"
 
 type RecentChange struct {
+	Paths []*ChangedPath
+	LogEntry
+}
+
+// ChangeAction describes what a change did to a path. The values double as CSS classes.
+type ChangeAction string
+
+const (
+	ChangeAdded    ChangeAction = "added"
+	ChangeModified ChangeAction = "modified"
+	ChangeDeleted  ChangeAction = "deleted"
+	ChangeRenamed  ChangeAction = "renamed"
+)
+
+// ChangedPath is a single page, file or config setting affected by a change. Exactly one of Page, File and Config
+// is set.
+type ChangedPath struct {
+	Action ChangeAction
 	Page   string
 	File   string
 	Config string
-	LogEntry
+	// RenamedFrom is the previous page title or file name, if the change renamed it.
+	RenamedFrom string
+	// SizeDelta is the number of bytes added to (or, if negative, removed from) the path by the change.
+	SizeDelta int64
+}
+
+// ChangeType selects whether recent changes to pages, uploaded files or config settings are shown.
+type ChangeType string
+
+const (
+	ChangeTypePages  ChangeType = "pages"
+	ChangeTypeFiles  ChangeType = "files"
+	ChangeTypeConfig ChangeType = "config"
+)
+
+// ChangeFilter restricts the changes returned by RecentChanges. Fields left empty match every change.
+type ChangeFilter struct {
+	// User is the name of the user who made the change.
+	User string
+	// Prefix is a directory that changed pages or files must be within.
+	Prefix string
+	Type   ChangeType
+	// Since and Until are the start (inclusive) and end (exclusive) of the time window the change was made in.
+	Since time.Time
+	Until time.Time
 }
 
 type LogEntry struct {
@@ -14,6 +56,8 @@ type LogEntry struct {
 	User     string
 	Time     time.Time
 	Message  string
+	// RenamedFrom is set in page histories to the page's previous title, if the change renamed it.
+	RenamedFrom string
 }
 
 type Page struct {
@@ -1,14 +1,16 @@
 (function () {
+    // Load MathJax from alongside this script, so it can be found wherever the wiki's static files are served from
+    const base = document.currentScript.src.replace(/[^/]*$/, '');
     if (document.body.textContent.match(/\$|\\\(|\\\[|\\begin{.*?}/)) {
         if (!window.MathJax) {
             window.MathJax = {
                 chtml: {
-                    fontURL: '/static/mathjax/fonts'
+                    fontURL: base + 'fonts'
                 }
             };
         }
         let script = document.createElement('script');
-        script.src = '/static/mathjax/mathjax-3.1.2.js';
+        script.src = base + 'mathjax-3.1.2.js';
         script.onload = function() {
         [].forEach.call(document.querySelectorAll('.math'), function (el) {
                 el.classList.remove('math');
@@ -3,13 +3,16 @@ package main
 import (
 	"fmt"
 	"html/template"
+	"io"
 	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/mdbot/wiki/config"
-	"github.com/sergi/go-diff/diffmatchpatch"
 )
 
 type Templates struct {
@@ -17,7 +20,8 @@ type Templates struct {
 	siteConfig      *config.Site
 	checker         *PermissionChecker
 	version         string
-	sidebarProvider func() string
+	sidebarProvider func(snapshot string) string
+	syncStatus      func() *SyncStatus
 }
 
 type SiteArgs struct {
@@ -43,6 +47,12 @@ type CommonArgs struct {
 	Sidebar        template.HTML
 	User           *config.User
 	LastModified   *LastModifiedDetails
+	SyncConflict   bool
+	Draft          string
+	// Snapshot is the name of the snapshot being viewed, if the page shows the wiki as it was in the past.
+	Snapshot string
+	// StaticExport indicates the page is part of a static export, so anything that needs the server is left out.
+	StaticExport bool
 }
 
 type LastModifiedDetails struct {
@@ -55,12 +65,13 @@ type ViewPageArgs struct {
 	PageContent template.HTML
 }
 
-func (t *Templates) RenderPage(w http.ResponseWriter, r *http.Request, title, content string, log *LastModifiedDetails) {
+func (t *Templates) RenderPage(w http.ResponseWriter, r *http.Request, title, content, draft string, log *LastModifiedDetails) {
 	t.render("index.gohtml", http.StatusOK, w, &ViewPageArgs{
 		Common: t.populateArgs(w, r, CommonArgs{
 			PageTitle:    title,
 			IsWikiPage:   true,
 			LastModified: log,
+			Draft:        draft,
 		}),
 		PageContent: template.HTML(content),
 	})
@@ -69,15 +80,83 @@ func (t *Templates) RenderPage(w http.ResponseWriter, r *http.Request, title, co
 type EditPageArgs struct {
 	Common      CommonArgs
 	PageContent string
+	Revision    string
+	Message     string
+	Conflict    bool
+	Draft       string
 }
 
-func (t *Templates) RenderEditPage(w http.ResponseWriter, r *http.Request, title, content string) {
+func (t *Templates) RenderEditPage(w http.ResponseWriter, r *http.Request, title, content, revision, draft string) {
 	t.render("edit.gohtml", http.StatusOK, w, &EditPageArgs{
 		Common: t.populateArgs(w, r, CommonArgs{
 			PageTitle:      title,
 			ShowLinkToView: true,
 		}),
 		PageContent: content,
+		Revision:    revision,
+		Draft:       draft,
+	})
+}
+
+func (t *Templates) RenderEditConflict(w http.ResponseWriter, r *http.Request, title, content, revision, message string) {
+	t.render("edit.gohtml", http.StatusConflict, w, &EditPageArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle:      title,
+			ShowLinkToView: true,
+		}),
+		PageContent: content,
+		Revision:    revision,
+		Message:     message,
+		Conflict:    true,
+	})
+}
+
+type DraftsArgs struct {
+	Common CommonArgs
+	Drafts []*Draft
+}
+
+func (t *Templates) RenderDrafts(w http.ResponseWriter, r *http.Request, drafts []*Draft) {
+	t.render("drafts.gohtml", http.StatusOK, w, &DraftsArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Drafts",
+		}),
+		Drafts: drafts,
+	})
+}
+
+type ChangeRequestsArgs struct {
+	Common   CommonArgs
+	Requests []*ChangeRequest
+}
+
+func (t *Templates) RenderChangeRequests(w http.ResponseWriter, r *http.Request, requests []*ChangeRequest) {
+	t.render("requests.gohtml", http.StatusOK, w, &ChangeRequestsArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Change requests",
+		}),
+		Requests: requests,
+	})
+}
+
+type ChangeRequestArgs struct {
+	Common  CommonArgs
+	Request *ChangeRequest
+	Diffs   []*PageDiff
+}
+
+type PageDiff struct {
+	Page string
+	Diff *TextDiff
+}
+
+func (t *Templates) RenderChangeRequest(w http.ResponseWriter, r *http.Request, request *ChangeRequest, diffs []*PageDiff) {
+	t.render("request.gohtml", http.StatusOK, w, &ChangeRequestArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: fmt.Sprintf("Change request #%d", request.Id),
+		}),
+		Request: request,
+		Diffs:   diffs,
 	})
 }
 
@@ -109,16 +188,113 @@ func (t *Templates) RenderRevertPage(w http.ResponseWriter, r *http.Request, pag
 	})
 }
 
+type RevertChangeArgs struct {
+	Common   CommonArgs
+	Revision string
+}
+
+func (t *Templates) RenderRevertChange(w http.ResponseWriter, r *http.Request, revision string) {
+	t.render("revert_change.gohtml", http.StatusOK, w, &RevertChangeArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Revert change",
+		}),
+		Revision: revision,
+	})
+}
+
+func (t *Templates) RenderRevertFile(w http.ResponseWriter, r *http.Request, name, revision string) {
+	t.render("revert_file.gohtml", http.StatusOK, w, &RevertPageArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: name,
+		}),
+		Revision: revision,
+	})
+}
+
 type RenamePageArgs struct {
-	Common CommonArgs
+	Common    CommonArgs
+	Directory string
 }
 
 func (t *Templates) RenderRenamePage(w http.ResponseWriter, r *http.Request, oldName string) {
+	dir := path.Dir(oldName)
+	if dir == "." {
+		dir = ""
+	}
+
 	t.render("rename.gohtml", http.StatusOK, w, &RenamePageArgs{
 		Common: t.populateArgs(w, r, CommonArgs{
 			PageTitle:      oldName,
 			ShowLinkToView: true,
 		}),
+		Directory: dir,
+	})
+}
+
+type RenamePreviewArgs struct {
+	Common   CommonArgs
+	NewName  string
+	Message  string
+	Redirect bool
+	Rewrites []*LinkRewrite
+}
+
+func (t *Templates) RenderRenamePreview(w http.ResponseWriter, r *http.Request, oldName, newName, message string, redirect bool, rewrites []*LinkRewrite) {
+	t.render("rename_preview.gohtml", http.StatusOK, w, &RenamePreviewArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle:      oldName,
+			ShowLinkToView: true,
+		}),
+		NewName:  newName,
+		Message:  message,
+		Redirect: redirect,
+		Rewrites: rewrites,
+	})
+}
+
+type RedirectsArgs struct {
+	Common    CommonArgs
+	Redirects []*Redirect
+}
+
+func (t *Templates) RenderRedirects(w http.ResponseWriter, r *http.Request, redirects []*Redirect) {
+	t.render("redirects.gohtml", http.StatusOK, w, &RedirectsArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Redirects",
+		}),
+		Redirects: redirects,
+	})
+}
+
+type TrashArgs struct {
+	Common CommonArgs
+	Items  []*DeletedItem
+}
+
+func (t *Templates) RenderTrash(w http.ResponseWriter, r *http.Request, items []*DeletedItem) {
+	t.render("trash.gohtml", http.StatusOK, w, &TrashArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Deleted pages and files",
+		}),
+		Items: items,
+	})
+}
+
+type RenameDirectoryArgs struct {
+	Common    CommonArgs
+	Directory string
+	Pages     []string
+	Files     []string
+}
+
+func (t *Templates) RenderRenameDirectory(w http.ResponseWriter, r *http.Request, dir string, pages, files []string) {
+	t.render("renamedir.gohtml", http.StatusOK, w, &RenameDirectoryArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: fmt.Sprintf("Rename %s", dir),
+		}),
+		Directory: dir,
+		Pages:     pages,
+		Files:     files,
 	})
 }
 
@@ -150,6 +326,78 @@ func (t *Templates) RenderFileList(w http.ResponseWriter, r *http.Request, files
 	})
 }
 
+func (t *Templates) RenderSnapshotPage(w http.ResponseWriter, r *http.Request, snapshot, title, content string, log *LastModifiedDetails) {
+	t.render("index.gohtml", http.StatusOK, w, &ViewPageArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle:      title,
+			ShowLinkToView: true,
+			LastModified:   log,
+			Snapshot:       snapshot,
+		}),
+		PageContent: template.HTML(content),
+	})
+}
+
+func (t *Templates) RenderSnapshotPageList(w http.ResponseWriter, r *http.Request, snapshot string, pages []string) {
+	t.render("list.gohtml", http.StatusOK, w, &ListPagesArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Pages",
+			Snapshot:  snapshot,
+		}),
+		Pages: pages,
+	})
+}
+
+func (t *Templates) RenderSnapshotFileList(w http.ResponseWriter, r *http.Request, snapshot string, files []File) {
+	t.render("listfiles.gohtml", http.StatusOK, w, &ListFilesArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Files",
+			Snapshot:  snapshot,
+		}),
+		Files: files,
+	})
+}
+
+func (t *Templates) ExportPage(w io.Writer, title, content string, log *LastModifiedDetails) error {
+	return t.execute("index.gohtml", w, &ViewPageArgs{
+		Common: t.exportArgs(CommonArgs{
+			PageTitle:    title,
+			LastModified: log,
+		}),
+		PageContent: template.HTML(content),
+	})
+}
+
+type ExportIndexArgs struct {
+	Common CommonArgs
+	Pages  []string
+	Files  []File
+}
+
+func (t *Templates) ExportIndex(w io.Writer, pages []string, files []File) error {
+	return t.execute("export_index.gohtml", w, &ExportIndexArgs{
+		Common: t.exportArgs(CommonArgs{
+			PageTitle: "Index",
+		}),
+		Pages: pages,
+		Files: files,
+	})
+}
+
+type SnapshotsArgs struct {
+	Common    CommonArgs
+	Snapshots []*Snapshot
+}
+
+func (t *Templates) RenderSnapshots(w http.ResponseWriter, r *http.Request, snapshots []*Snapshot) {
+	t.render("snapshots.gohtml", http.StatusOK, w, &SnapshotsArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Snapshots",
+		}),
+		Snapshots: snapshots,
+	})
+}
+
 type DeleteFileArgs struct {
 	Common CommonArgs
 }
@@ -174,6 +422,18 @@ func (t *Templates) RenderUploadForm(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+type ImportArgs struct {
+	Common CommonArgs
+}
+
+func (t *Templates) RenderImportForm(w http.ResponseWriter, r *http.Request) {
+	t.render("import.gohtml", http.StatusOK, w, &ImportArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Import",
+		}),
+	})
+}
+
 type HistoryPageArgs struct {
 	Common  CommonArgs
 	History []*HistoryEntry
@@ -187,6 +447,7 @@ type HistoryEntry struct {
 	User             string
 	Time             time.Time
 	Message          string
+	RenamedFrom      string
 }
 
 func (t *Templates) RenderHistory(w http.ResponseWriter, r *http.Request, title string, entries []*HistoryEntry, next string) {
@@ -201,22 +462,114 @@ func (t *Templates) RenderHistory(w http.ResponseWriter, r *http.Request, title
 	})
 }
 
+type BlameArgs struct {
+	Common CommonArgs
+	Lines  []*BlameLine
+}
+
+func (t *Templates) RenderBlame(w http.ResponseWriter, r *http.Request, title string, lines []*BlameLine) {
+	t.render("blame.gohtml", http.StatusOK, w, &BlameArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle:      title,
+			IsWikiPage:     true,
+			ShowLinkToView: true,
+		}),
+		Lines: lines,
+	})
+}
+
+type FileHistoryArgs struct {
+	Common  CommonArgs
+	History []*HistoryEntry
+	Next    string
+	Image   bool
+}
+
+func (t *Templates) RenderFileHistory(w http.ResponseWriter, r *http.Request, name string, entries []*HistoryEntry, next string) {
+	t.render("file_history.gohtml", http.StatusOK, w, &FileHistoryArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: name,
+		}),
+		History: entries,
+		Next:    next,
+		Image:   isImage(name),
+	})
+}
+
+type CompareFileArgs struct {
+	Common        CommonArgs
+	StartRevision string
+	EndRevision   string
+}
+
+func (t *Templates) RenderCompareFile(w http.ResponseWriter, r *http.Request, name, startRevision, endRevision string) {
+	t.render("file_compare.gohtml", http.StatusOK, w, &CompareFileArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: name,
+		}),
+		StartRevision: startRevision,
+		EndRevision:   endRevision,
+	})
+}
+
 type RecentChangesArgs struct {
 	Common  CommonArgs
 	Changes []*RecentChange
+	Days    []*ChangeDay
 	Next    string
+	// Filter holds the query parameters used to filter the changes.
+	Filter url.Values
+}
+
+// NextLink returns the link to the next page of changes, keeping the current filters.
+func (a *RecentChangesArgs) NextLink() template.URL {
+	query := url.Values{"after": {a.Next}}
+	for key := range a.Filter {
+		query.Set(key, a.Filter.Get(key))
+	}
+	return template.URL("?" + query.Encode())
+}
+
+// FeedLink returns the link to the RSS feed of changes matching the current filters.
+func (a *RecentChangesArgs) FeedLink() template.URL {
+	if len(a.Filter) == 0 {
+		return "/wiki/changes.xml"
+	}
+	return template.URL("/wiki/changes.xml?" + a.Filter.Encode())
+}
+
+// ChangeDay is a group of changes made on the same day.
+type ChangeDay struct {
+	Date    time.Time
+	Changes []*RecentChange
 }
 
-func (t *Templates) RenderRecentChanges(w http.ResponseWriter, r *http.Request, entries []*RecentChange, next string) {
+func (t *Templates) RenderRecentChanges(w http.ResponseWriter, r *http.Request, entries []*RecentChange, next string, filter url.Values) {
 	t.render("changes.gohtml", http.StatusOK, w, &RecentChangesArgs{
 		Common: t.populateArgs(w, r, CommonArgs{
 			PageTitle: "Recent changes",
 		}),
 		Changes: entries,
+		Days:    groupChangesByDay(entries),
 		Next:    next,
+		Filter:  filter,
 	})
 }
 
+// groupChangesByDay splits a list of changes into runs made on the same day, in the time zone they were made in.
+func groupChangesByDay(entries []*RecentChange) []*ChangeDay {
+	var days []*ChangeDay
+	for i := range entries {
+		y, m, d := entries[i].Time.Date()
+		date := time.Date(y, m, d, 0, 0, 0, 0, entries[i].Time.Location())
+		if len(days) == 0 || !days[len(days)-1].Date.Equal(date) {
+			days = append(days, &ChangeDay{Date: date})
+		}
+		days[len(days)-1].Changes = append(days[len(days)-1].Changes, entries[i])
+	}
+	return days
+}
+
 func (t *Templates) RenderRecentChangesFeed(w http.ResponseWriter, r *http.Request, entries []*RecentChange) {
 	w.Header().Set("Content-Type", "application/rss+xml")
 	t.render("changes.goxml", http.StatusOK, w, &RecentChangesArgs{
@@ -247,7 +600,8 @@ func (t *Templates) RenderManageUsers(w http.ResponseWriter, r *http.Request, us
 }
 
 type ViewSiteArgs struct {
-	Common CommonArgs
+	Common         CommonArgs
+	ProtectedPaths string
 }
 
 func (t *Templates) RenderViewSiteConfig(w http.ResponseWriter, r *http.Request) {
@@ -255,6 +609,7 @@ func (t *Templates) RenderViewSiteConfig(w http.ResponseWriter, r *http.Request)
 		Common: t.populateArgs(w, r, CommonArgs{
 			PageTitle: "Manage site",
 		}),
+		ProtectedPaths: strings.Join(t.siteConfig.ProtectedPaths, "\n"),
 	})
 }
 
@@ -350,34 +705,65 @@ func (t *Templates) RenderSearch(w http.ResponseWriter, r *http.Request, pattern
 	})
 }
 
-type DiffPageArgs struct {
+type SyncPageArgs struct {
 	Common CommonArgs
-	Diff   []diffmatchpatch.Diff
+	Status *SyncStatus
 }
 
-func (t *Templates) RenderDiff(w http.ResponseWriter, r *http.Request, diff []diffmatchpatch.Diff) {
+func (t *Templates) RenderSync(w http.ResponseWriter, r *http.Request, status *SyncStatus) {
+	t.render("sync.gohtml", http.StatusOK, w, &SyncPageArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Remote synchronisation",
+		}),
+		Status: status,
+	})
+}
+
+type DiffPageArgs struct {
+	Common           CommonArgs
+	StartRevision    string
+	EndRevision      string
+	Diff             *TextDiff
+	SideBySide       bool
+	FullContext      bool
+	IgnoreWhitespace bool
+}
+
+func (t *Templates) RenderDiff(w http.ResponseWriter, r *http.Request, title, startRevision, endRevision string, diff *TextDiff, options DiffOptions, sideBySide bool) {
 	t.render("diff.gohtml", http.StatusOK, w, &DiffPageArgs{
 		Common: t.populateArgs(w, r, CommonArgs{
-			PageTitle: "Diff",
+			PageTitle:      title,
+			IsWikiPage:     true,
+			ShowLinkToView: true,
 		}),
-		Diff: diff,
+		StartRevision:    startRevision,
+		EndRevision:      endRevision,
+		Diff:             diff,
+		SideBySide:       sideBySide,
+		FullContext:      options.Context < 0,
+		IgnoreWhitespace: options.IgnoreWhitespace,
 	})
 }
 
 func (t *Templates) render(name string, statusCode int, w http.ResponseWriter, data interface{}) {
 	w.WriteHeader(statusCode)
+	if err := t.execute(name, w, data); err != nil {
+		// TODO: We should probably send an error to the client
+		log.Printf("Error rendering template: %v\n", err)
+	}
+}
+
+func (t *Templates) execute(name string, w io.Writer, data interface{}) error {
 	tpl := template.New(name)
 	tpl.Funcs(map[string]interface{}{
 		"bytes": t.formatBytes,
+		"delta": t.formatDelta,
 		"unsafeHtml": func(html string) template.HTML {
 			return template.HTML(html)
 		},
 	})
 	template.Must(tpl.ParseFS(t.fs, name, "partials/*.gohtml"))
-	if err := tpl.Execute(w, data); err != nil {
-		// TODO: We should probably send an error to the client
-		log.Printf("Error rendering template: %v\n", err)
-	}
+	return tpl.Execute(w, data)
 }
 
 func (t *Templates) formatBytes(size int64) string {
@@ -395,6 +781,34 @@ func (t *Templates) formatBytes(size int64) string {
 	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(denominator), "KMGTPE"[power])
 }
 
+// formatDelta formats a change in size, with a sign to show whether it grew or shrank.
+func (t *Templates) formatDelta(size int64) string {
+	switch {
+	case size > 0:
+		return "+" + t.formatBytes(size)
+	case size < 0:
+		return "-" + t.formatBytes(-size)
+	default:
+		return "±0 B"
+	}
+}
+
+// exportArgs fills in the common arguments for a page in a static export, which is shown as it would be to an
+// anonymous reader.
+func (t *Templates) exportArgs(args CommonArgs) CommonArgs {
+	args.Site = &SiteArgs{
+		SiteName:    t.siteConfig.Name,
+		HasMainLogo: t.siteConfig.MainLogo != nil,
+		HasDarkLogo: t.siteConfig.DarkLogo != nil,
+		HasFavicon:  t.siteConfig.Favicon != nil,
+		CanRead:     true,
+		WikiVersion: t.version,
+	}
+	args.StaticExport = true
+	args.Sidebar = template.HTML(t.sidebarProvider(""))
+	return args
+}
+
 func (t *Templates) populateArgs(w http.ResponseWriter, r *http.Request, args CommonArgs) CommonArgs {
 	user := getUserForRequest(r)
 	args.Site = &SiteArgs{
@@ -409,6 +823,12 @@ func (t *Templates) populateArgs(w http.ResponseWriter, r *http.Request, args Co
 	}
 	args.User = user
 
+	if args.Site.CanAdmin && t.syncStatus != nil {
+		if status := t.syncStatus(); status != nil && len(status.Conflicts) > 0 {
+			args.SyncConflict = true
+		}
+	}
+
 	if args.Error = getErrorForRequest(r); args.Error != "" {
 		clearSessionKey(w, r, sessionErrorKey)
 	}
@@ -418,6 +838,6 @@ func (t *Templates) populateArgs(w http.ResponseWriter, r *http.Request, args Co
 	}
 
 	args.RequestedUrl = r.URL.String()
-	args.Sidebar = template.HTML(t.sidebarProvider())
+	args.Sidebar = template.HTML(t.sidebarProvider(args.Snapshot))
 	return args
 }
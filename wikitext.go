@@ -0,0 +1,571 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"mime"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mdbot/wiki/markdown"
+)
+
+// Keys of the MediaWiki namespaces that are treated specially when importing.
+const (
+	mediaWikiMediaNamespace    = -2
+	mediaWikiMainNamespace     = 0
+	mediaWikiFileNamespace     = 6
+	mediaWikiCategoryNamespace = 14
+)
+
+// defaultMediaWikiNamespaces are the namespaces that every MediaWiki install has, used in addition to any listed in
+// an export. Image is an old alias of File that's still accepted in links.
+var defaultMediaWikiNamespaces = map[string]int{
+	"media":          mediaWikiMediaNamespace,
+	"special":        -1,
+	"talk":           1,
+	"user":           2,
+	"user talk":      3,
+	"project":        4,
+	"project talk":   5,
+	"file":           mediaWikiFileNamespace,
+	"file talk":      7,
+	"image":          mediaWikiFileNamespace,
+	"image talk":     7,
+	"mediawiki":      8,
+	"mediawiki talk": 9,
+	"template":       10,
+	"template talk":  11,
+	"help":           12,
+	"help talk":      13,
+	"category":       mediaWikiCategoryNamespace,
+	"category talk":  15,
+}
+
+var (
+	wikitextRedirectPattern = regexp.MustCompile(`(?i)^\s*#REDIRECT\s*:?\s*\[\[([^\]|]+)(\|[^\]]*)?\]\]`)
+	wikitextCommentPattern  = regexp.MustCompile(`(?s)<!--.*?-->`)
+	wikitextCodePattern     = regexp.MustCompile(`(?is)<(pre|syntaxhighlight|source)(\s[^>]*)?>(.*?)</(?:pre|syntaxhighlight|source)>[ \t]*`)
+	wikitextLangPattern     = regexp.MustCompile(`(?i)\blang\s*=\s*"?([a-z0-9+#-]+)`)
+	wikitextNowikiPattern   = regexp.MustCompile(`(?is)<nowiki>(.*?)</nowiki>|<nowiki\s*/>`)
+	wikitextMathPattern     = regexp.MustCompile(`(?is)<math(\s[^>]*)?>(.*?)</math>`)
+	wikitextBlockMath       = regexp.MustCompile(`(?i)display\s*=\s*"?block`)
+	wikitextMagicPattern    = regexp.MustCompile(`__(NO)?(TOC|FORCETOC|EDITSECTION|NEWSECTIONLINK|INDEX|GALLERY|TITLECONVERT|CONTENTCONVERT)__`)
+	wikitextHeadingPattern  = regexp.MustCompile(`^(=+)(.+?)(=+)\s*$`)
+	wikitextRulePattern     = regexp.MustCompile(`^-{4,}\s*$`)
+	wikitextBlockPattern    = regexp.MustCompile(`^\s*([-+>]|[0-9]+[.)])(\s|$)`)
+	wikitextListPattern     = regexp.MustCompile(`^[*#;:]+`)
+	wikitextExternalPattern = regexp.MustCompile(`\[((?:https?:|ftp:|mailto:|//)[^\s\]]+)(?:\s+([^\]]*))?\]`)
+	wikitextBoldItalic      = regexp.MustCompile(`'''''(.+?)'''''`)
+	wikitextBold            = regexp.MustCompile(`'''(.+?)'''`)
+	wikitextItalic          = regexp.MustCompile(`''(.+?)''`)
+	wikitextLinkTrail       = regexp.MustCompile(`^[a-zA-Z]+`)
+	wikitextPlaceholder     = regexp.MustCompile("\x00([0-9]+)\x00")
+	wikitextImageOption     = regexp.MustCompile(`^(thumb|thumbnail|frame|framed|frameless|border|left|right|center|centre|none|upright|baseline|sub|super|top|text-top|middle|bottom|text-bottom|[0-9]*(x[0-9]+)?px|(upright|alt|link|page|class|lang|thumb|thumbnail)=.*)$`)
+)
+
+// wikitextConverter converts MediaWiki markup to the wiki's markdown dialect, and MediaWiki page and file names to
+// the names they're imported as.
+type wikitextConverter struct {
+	// dir is the directory in the wiki that everything is imported into.
+	dir string
+	// namespaces maps the lower cased names and aliases of namespaces to their keys.
+	namespaces map[string]int
+	// dirs maps namespace keys to the directory that pages in that namespace are imported into.
+	dirs map[int]string
+}
+
+func newWikitextConverter(dir string, namespaces map[int]string) *wikitextConverter {
+	c := &wikitextConverter{
+		dir:        dir,
+		namespaces: make(map[string]int),
+		dirs:       make(map[int]string),
+	}
+	for name, key := range defaultMediaWikiNamespaces {
+		c.namespaces[name] = key
+		if _, ok := c.dirs[key]; !ok || !strings.HasPrefix(name, "image") {
+			c.dirs[key] = name
+		}
+	}
+	for key, name := range namespaces {
+		name = strings.ToLower(normaliseWikitextTitle(name))
+		if name != "" {
+			c.namespaces[name] = key
+			c.dirs[key] = name
+		}
+	}
+	return c
+}
+
+// normaliseWikitextTitle converts underscores in a MediaWiki title to spaces, as MediaWiki treats them the same,
+// and collapses runs of whitespace.
+func normaliseWikitextTitle(title string) string {
+	return strings.Join(strings.Fields(strings.ReplaceAll(title, "_", " ")), " ")
+}
+
+// splitTitle splits a MediaWiki title into its namespace and the rest of the title.
+func (c *wikitextConverter) splitTitle(title string) (int, string) {
+	title = normaliseWikitextTitle(title)
+	if prefix, rest, ok := strings.Cut(title, ":"); ok {
+		if key, ok := c.namespaces[strings.ToLower(strings.TrimSpace(prefix))]; ok {
+			return key, strings.TrimSpace(rest)
+		}
+	}
+	return mediaWikiMainNamespace, title
+}
+
+// pageTitle returns the title that a MediaWiki page is imported as. Pages outside the main namespace are put in a
+// directory named after their namespace, so "Help:Contents" becomes "help/contents".
+func (c *wikitextConverter) pageTitle(title string) string {
+	key, name := c.splitTitle(title)
+	if key != mediaWikiMainNamespace {
+		name = c.dirs[key] + "/" + name
+	}
+	return strings.ToLower(path.Join(c.dir, name))
+}
+
+// fileName returns the name that a file uploaded to MediaWiki is imported as. Spaces are replaced with underscores,
+// as they are in the names MediaWiki stores files under.
+func (c *wikitextConverter) fileName(name string) string {
+	_, name = c.splitTitle(name)
+	return strings.ToLower(path.Join(c.dir, strings.ReplaceAll(name, " ", "_")))
+}
+
+// wikitextPage holds the state used while converting a single page.
+type wikitextPage struct {
+	*wikitextConverter
+	// placeholders hold markdown that has already been produced, so that it isn't converted again. They're
+	// referred to in the text being converted by their index, surrounded by NUL characters.
+	placeholders []string
+}
+
+// Convert converts a page of MediaWiki markup to markdown. Headings, formatting, links, lists, tables, images and
+// code are converted; templates are left as they are, as there's no way to expand them.
+func (c *wikitextConverter) Convert(text string) string {
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\x00", "")
+	if match := wikitextRedirectPattern.FindStringSubmatch(text); match != nil {
+		target, _, _ := strings.Cut(match[1], "#")
+		return string(redirectContent(c.pageTitle(target)))
+	}
+
+	p := &wikitextPage{wikitextConverter: c}
+	text = wikitextCommentPattern.ReplaceAllString(text, "")
+	text = wikitextCodePattern.ReplaceAllStringFunc(text, func(match string) string {
+		parts := wikitextCodePattern.FindStringSubmatch(match)
+		code, lang := parts[3], ""
+		if strings.EqualFold(parts[1], "pre") {
+			// Unlike the other tags, pre allows HTML entities
+			code = html.UnescapeString(code)
+		} else if l := wikitextLangPattern.FindStringSubmatch(parts[2]); l != nil {
+			lang = strings.ToLower(l[1])
+		}
+		return "\n" + p.protect(fencedCode(lang, strings.Split(strings.Trim(code, "\n"), "\n"))) + "\n"
+	})
+	text = wikitextNowikiPattern.ReplaceAllStringFunc(text, func(match string) string {
+		return p.protect(escapeMarkdown(wikitextNowikiPattern.FindStringSubmatch(match)[1]))
+	})
+	text = wikitextMathPattern.ReplaceAllStringFunc(text, func(match string) string {
+		parts := wikitextMathPattern.FindStringSubmatch(match)
+		if wikitextBlockMath.MatchString(parts[1]) {
+			return "\n" + p.protect(fmt.Sprintf("$$\n%s\n$$", strings.TrimSpace(parts[2]))) + "\n"
+		}
+		return p.protect(fmt.Sprintf("$%s$", strings.TrimSpace(parts[2])))
+	})
+	text = wikitextMagicPattern.ReplaceAllString(text, "")
+
+	text = strings.Join(p.convertBlocks(strings.Split(text, "\n")), "\n")
+
+	// Placeholders can be nested, for example a link with some code in its label
+	for i := 0; i <= len(p.placeholders) && strings.ContainsRune(text, 0); i++ {
+		text = wikitextPlaceholder.ReplaceAllStringFunc(text, func(match string) string {
+			index, _ := strconv.Atoi(strings.Trim(match, "\x00"))
+			return p.placeholders[index]
+		})
+	}
+	return strings.TrimSpace(text) + "\n"
+}
+
+// protect stores converted markdown, returning a placeholder for it.
+func (p *wikitextPage) protect(markdown string) string {
+	p.placeholders = append(p.placeholders, markdown)
+	return fmt.Sprintf("\x00%d\x00", len(p.placeholders)-1)
+}
+
+// isBlockPlaceholder determines whether a line consists of a placeholder for a code block or displayed maths.
+func (p *wikitextPage) isBlockPlaceholder(line string) bool {
+	match := wikitextPlaceholder.FindStringSubmatch(line)
+	if match == nil || match[0] != line {
+		return false
+	}
+	index, _ := strconv.Atoi(match[1])
+	return strings.HasPrefix(p.placeholders[index], "```") || strings.HasPrefix(p.placeholders[index], "$$")
+}
+
+// fencedCode returns the given lines as a fenced code block.
+func fencedCode(lang string, lines []string) string {
+	fence := "```"
+	for strings.Contains(strings.Join(lines, "\n"), fence) {
+		fence += "`"
+	}
+	return fmt.Sprintf("%s%s\n%s\n%s", fence, lang, strings.Join(lines, "\n"), fence)
+}
+
+// escapeMarkdown escapes characters that would otherwise be treated as markdown or wiki link syntax.
+func escapeMarkdown(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if strings.ContainsRune("\\`*_[]<>#|$!", r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// convertBlocks converts the block level structure of a page: headings, lists, tables, rules and preformatted text.
+// Blank lines are added where the markdown equivalents would otherwise run together.
+func (p *wikitextPage) convertBlocks(lines []string) []string {
+	var out []string
+	var previous string
+	add := func(kind string, converted ...string) {
+		if kind != previous && previous != "" && kind != "" && len(out) > 0 && out[len(out)-1] != "" {
+			out = append(out, "")
+		}
+		out = append(out, converted...)
+		previous = kind
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			if len(out) > 0 && out[len(out)-1] != "" {
+				out = append(out, "")
+			}
+			previous = ""
+		case p.isBlockPlaceholder(trimmed):
+			add("pre", trimmed)
+		case strings.HasPrefix(trimmed, "{|"):
+			end := i + 1
+			for depth := 1; end < len(lines) && depth > 0; end++ {
+				if t := strings.TrimSpace(lines[end]); strings.HasPrefix(t, "{|") {
+					depth++
+				} else if strings.HasPrefix(t, "|}") {
+					depth--
+				}
+			}
+			add("table", p.convertTable(lines[i+1:end])...)
+			i = end - 1
+		case strings.HasPrefix(line, " "):
+			var code []string
+			for ; i < len(lines) && strings.HasPrefix(lines[i], " ") && strings.TrimSpace(lines[i]) != ""; i++ {
+				code = append(code, lines[i][1:])
+			}
+			i--
+			add("pre", fencedCode("", code))
+		case wikitextHeadingPattern.MatchString(trimmed):
+			parts := wikitextHeadingPattern.FindStringSubmatch(trimmed)
+			level := min(len(parts[1]), len(parts[3]), 6)
+			heading := parts[1][level:] + parts[2] + parts[3][level:]
+			add("heading", strings.Repeat("#", level)+" "+p.inline(strings.TrimSpace(heading), false))
+		case wikitextRulePattern.MatchString(trimmed):
+			add("rule", "---")
+		case wikitextListPattern.MatchString(line):
+			kind, converted := p.convertListItem(line)
+			add(kind, converted)
+		default:
+			add("text", escapeBlockStart(p.inline(line, false)))
+		}
+	}
+	return out
+}
+
+// escapeBlockStart escapes the start of a line of text that markdown would otherwise treat as a list item or block
+// quote.
+func escapeBlockStart(line string) string {
+	if match := wikitextBlockPattern.FindStringSubmatchIndex(line); match != nil {
+		return line[:match[3]-1] + `\` + line[match[3]-1:]
+	}
+	return line
+}
+
+// convertListItem converts a line of a bulleted, numbered or definition list, or an indented line. Lines indented
+// with colons outside of a list are converted to block quotes.
+func (p *wikitextPage) convertListItem(line string) (string, string) {
+	prefix := wikitextListPattern.FindString(line)
+	text := p.inline(strings.TrimSpace(line[len(prefix):]), false)
+
+	var indent string
+	for _, r := range prefix[:len(prefix)-1] {
+		if r == '#' {
+			indent += "   "
+		} else {
+			indent += "  "
+		}
+	}
+
+	switch prefix[len(prefix)-1] {
+	case '*':
+		return "list", indent + "- " + text
+	case '#':
+		return "list", indent + "1. " + text
+	case ';':
+		// A definition can follow the term on the same line
+		term, definition, ok := strings.Cut(text, " : ")
+		if ok {
+			return "list", fmt.Sprintf("%s**%s**\n%s> %s", indent, strings.TrimSpace(term), indent, strings.TrimSpace(definition))
+		}
+		return "list", indent + "**" + text + "**"
+	default:
+		if strings.Trim(prefix, ":") == "" {
+			return "quote", strings.Repeat("> ", len(prefix)) + text
+		}
+		return "list", indent + text
+	}
+}
+
+// convertTable converts the lines of a table, after the opening {| line, to a markdown table. The first row is
+// used as the header, and attributes of the table, its rows and cells are dropped.
+func (p *wikitextPage) convertTable(lines []string) []string {
+	var caption string
+	var rows [][]string
+	var row []string
+	endRow := func() {
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+		row = nil
+	}
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "|}"):
+			endRow()
+		case strings.HasPrefix(line, "|+"):
+			caption = p.tableCell(line[2:])
+		case strings.HasPrefix(line, "|-"):
+			endRow()
+		case strings.HasPrefix(line, "!"):
+			for _, cell := range splitWikitext(strings.ReplaceAll(line[1:], "||", "!!"), "!!") {
+				row = append(row, p.tableCell(cell))
+			}
+		case strings.HasPrefix(line, "|"):
+			for _, cell := range splitWikitext(line[1:], "||") {
+				row = append(row, p.tableCell(cell))
+			}
+		case line != "" && len(row) > 0:
+			row[len(row)-1] = strings.TrimSpace(row[len(row)-1] + " " + p.tableCell(line))
+		}
+	}
+	endRow()
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	width := 0
+	for _, r := range rows {
+		width = max(width, len(r))
+	}
+
+	var out []string
+	if caption != "" {
+		out = append(out, "**"+caption+"**", "")
+	}
+	for i, r := range rows {
+		for len(r) < width {
+			r = append(r, "")
+		}
+		out = append(out, "| "+strings.Join(r, " | ")+" |")
+		if i == 0 {
+			out = append(out, "|"+strings.Repeat(" --- |", width))
+		}
+	}
+	return out
+}
+
+// tableCell converts the content of a table cell, dropping any attributes.
+func (p *wikitextPage) tableCell(cell string) string {
+	if parts := splitWikitext(cell, "|"); len(parts) == 2 && strings.Contains(parts[0], "=") {
+		cell = parts[1]
+	}
+	return strings.ReplaceAll(p.inline(strings.TrimSpace(cell), true), "|", `\|`)
+}
+
+// splitWikitext splits text on the given separator, ignoring any separators within links or templates.
+func splitWikitext(text, sep string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(text); i++ {
+		switch {
+		case strings.HasPrefix(text[i:], "[[") || strings.HasPrefix(text[i:], "{{"):
+			depth++
+			i++
+		case depth > 0 && (strings.HasPrefix(text[i:], "]]") || strings.HasPrefix(text[i:], "}}")):
+			depth--
+			i++
+		case depth == 0 && strings.HasPrefix(text[i:], sep):
+			parts = append(parts, text[start:i])
+			start = i + len(sep)
+			i += len(sep) - 1
+		}
+	}
+	return append(parts, text[start:])
+}
+
+// inline converts the formatting and links within a line. In tables, links are written so that they don't
+// contain pipes, which would split the cell.
+func (p *wikitextPage) inline(text string, table bool) string {
+	text = p.convertLinks(text, table)
+	text = wikitextExternalPattern.ReplaceAllStringFunc(text, func(match string) string {
+		parts := wikitextExternalPattern.FindStringSubmatch(match)
+		link := parts[1]
+		if strings.HasPrefix(link, "//") {
+			link = "https:" + link
+		}
+		if label := strings.TrimSpace(parts[2]); label != "" {
+			return p.protect(fmt.Sprintf("[%s](%s)", label, link))
+		}
+		return p.protect("<" + link + ">")
+	})
+	text = wikitextBoldItalic.ReplaceAllString(text, "***$1***")
+	text = wikitextBold.ReplaceAllString(text, "**$1**")
+	return wikitextItalic.ReplaceAllString(text, "*$1*")
+}
+
+// convertLinks converts all the [[...]] links within some text, which may be nested within image captions.
+func (p *wikitextPage) convertLinks(text string, table bool) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(text, "[[")
+		if start == -1 {
+			break
+		}
+
+		end, depth := -1, 0
+		for i := start; i < len(text)-1; i++ {
+			if text[i:i+2] == "[[" {
+				depth++
+				i++
+			} else if text[i:i+2] == "]]" {
+				depth--
+				i++
+				if depth == 0 {
+					end = i + 1
+					break
+				}
+			}
+		}
+		if end == -1 {
+			break
+		}
+
+		trail := wikitextLinkTrail.FindString(text[end:])
+		b.WriteString(text[:start])
+		b.WriteString(p.convertLink(text[start+2:end-2], trail, table))
+		text = text[end+len(trail):]
+	}
+	b.WriteString(text)
+	return b.String()
+}
+
+// convertLink converts the contents of a single [[...]] link, along with any letters directly following it that
+// MediaWiki includes in the link's label.
+func (p *wikitextPage) convertLink(link, trail string, table bool) string {
+	parts := splitWikitext(link, "|")
+	target := strings.TrimSpace(parts[0])
+	colon := strings.HasPrefix(target, ":")
+	target = strings.TrimPrefix(target, ":")
+	key, name := p.splitTitle(target)
+
+	switch {
+	case key == mediaWikiCategoryNamespace && !colon:
+		// Category links only put the page in the category, so there's nothing to show
+		return trail
+	case key == mediaWikiFileNamespace && !colon:
+		return p.convertImage(p.fileName(name), parts[1:]) + trail
+	case key == mediaWikiFileNamespace || key == mediaWikiMediaNamespace:
+		label := name
+		if len(parts) > 1 && strings.TrimSpace(parts[len(parts)-1]) != "" {
+			label = p.inline(strings.TrimSpace(parts[len(parts)-1]), table)
+		}
+		return p.protect(fmt.Sprintf("[%s](%s)", label+trail, escapeImportPath("/files/view/"+p.fileName(name))))
+	}
+
+	target, section, _ := strings.Cut(target, "#")
+	var label string
+	switch {
+	case len(parts) > 1 && strings.TrimSpace(parts[len(parts)-1]) != "":
+		label = strings.TrimSpace(parts[len(parts)-1])
+	case len(parts) > 1:
+		// The "pipe trick" hides the namespace and anything in brackets
+		label = strings.TrimSpace(strings.Split(name, " (")[0])
+	case strings.TrimSpace(target) == "":
+		label = section
+	default:
+		label = strings.TrimPrefix(strings.TrimSpace(link), ":")
+	}
+	label += trail
+	converted := p.inline(label, table)
+
+	var anchor string
+	if section != "" {
+		anchor = "#" + headingAnchor(section)
+	}
+	if strings.TrimSpace(target) == "" {
+		return p.protect(fmt.Sprintf("[%s](%s)", converted, anchor))
+	}
+
+	title := p.pageTitle(target)
+	switch {
+	case anchor != "" || (table && label != title) || converted != label || strings.ContainsAny(label, "|[]"):
+		return p.protect(fmt.Sprintf("[%s](%s%s)", converted, escapeImportPath("/view/"+title), anchor))
+	case label == title:
+		return p.protect(fmt.Sprintf("[[%s]]", title))
+	default:
+		return p.protect(fmt.Sprintf("[[%s|%s]]", title, label))
+	}
+}
+
+// convertImage converts a link that displays a file. Images, videos, audio and PDFs are embedded, with any caption
+// following them; other files are linked to.
+func (p *wikitextPage) convertImage(name string, options []string) string {
+	var caption string
+	for _, option := range options {
+		if option = strings.TrimSpace(option); !wikitextImageOption.MatchString(option) {
+			caption = p.inline(option, false)
+		}
+	}
+
+	if !markdown.CanEmbed(mime.TypeByExtension(path.Ext(name))) {
+		if caption == "" {
+			caption = path.Base(name)
+		}
+		return p.protect(fmt.Sprintf("[%s](%s)", caption, escapeImportPath("/files/view/"+name)))
+	}
+
+	if caption != "" {
+		return p.protect(fmt.Sprintf("![[%s]] *%s*", name, caption))
+	}
+	return p.protect(fmt.Sprintf("![[%s]]", name))
+}
+
+// headingAnchor returns the ID that a heading with the given text is given when rendered, for linking to sections.
+func headingAnchor(heading string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(normaliseWikitextTitle(heading)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_':
+			b.WriteRune(r)
+		case r == ' ':
+			b.WriteRune('-')
+		}
+	}
+	return b.String()
+}
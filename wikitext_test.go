@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestWikitextConverter_Convert(t *testing.T) {
+	converter := newWikitextConverter("", map[int]string{12: "Help", 6: "File"})
+
+	tests := []struct {
+		name     string
+		wikitext string
+		want     string
+	}{
+		{"headings", "== Intro ==\nText\n=== Sub ===", "## Intro\n\nText\n\n### Sub\n"},
+		{"formatting", "'''bold''', ''italic'' and '''''both'''''", "**bold**, *italic* and ***both***\n"},
+		{"page links", "[[Main Page]], [[foo]]s, [[Help:Contents|help]] and [[Foo#Some section|section]]", "[[main page|Main Page]], [[foo|foos]], [[help/contents|help]] and [section](/view/foo#some-section)\n"},
+		{"pipe trick", "[[Help:Tables (advanced)|]]", "[[help/tables (advanced)|Tables]]\n"},
+		{"external links", "[https://example.com Example] and [https://example.com]", "[Example](https://example.com) and <https://example.com>\n"},
+		{"images", "[[File:My pic.png|thumb|200px|A [[foo|caption]]]] [[Image:Doc.pdf]] [[File:Data.zip|the data]]", "![[my_pic.png]] *A [[foo|caption]]* ![[doc.pdf]] [the data](/files/view/data.zip)\n"},
+		{"file links", "[[Media:My pic.png|picture]] [[:File:My pic.png]]", "[picture](/files/view/my_pic.png) [My pic.png](/files/view/my_pic.png)\n"},
+		{"categories", "Text[[Category:Things]]", "Text\n"},
+		{"lists", "* one\n** two\n*# three\n# four\n#: more\nafter", "- one\n  - two\n  1. three\n1. four\n   more\n\nafter\n"},
+		{"indents", "Text\n:reply\n::again", "Text\n\n> reply\n> > again\n"},
+		{"definitions", ";term : definition", "**term**\n> definition\n"},
+		{"table", "{| class=\"wikitable\"\n|+ Caption\n! A !! B\n|-\n| style=\"x\" | [[foo|Foo]] || 2\n|-\n| 3\n|}", "**Caption**\n\n| A | B |\n| --- | --- |\n| [Foo](/view/foo) | 2 |\n| 3 |  |\n"},
+		{"code", "<pre>a &lt; b\n''c''</pre>\n <b>indented</b>\n<syntaxhighlight lang=\"go\">x := 1</syntaxhighlight>", "```\na < b\n''c''\n```\n\n```\n<b>indented</b>\n```\n\n```go\nx := 1\n```\n"},
+		{"nowiki", "<nowiki>[[not a link]]</nowiki>", "\\[\\[not a link\\]\\]\n"},
+		{"math", "<math>x^2</math>", "$x^2$\n"},
+		{"rules and escapes", "Text\n----\n- not a list\n1. not a list", "Text\n\n---\n\n\\- not a list\n1\\. not a list\n"},
+		{"comments and magic words", "__TOC__<!-- hidden -->Text", "Text\n"},
+		{"redirect", "#REDIRECT [[Help:Other_page#Section]]", "#REDIRECT [[help/other page]]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := converter.Convert(tt.wikitext); got != tt.want {
+				t.Errorf("Convert() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWikitextConverter_Names(t *testing.T) {
+	converter := newWikitextConverter("old", map[int]string{6: "Datei", 1: "Diskussion"})
+
+	if got := converter.pageTitle("Diskussion:Main_Page"); got != "old/diskussion/main page" {
+		t.Errorf("pageTitle() = %q", got)
+	}
+	if got := converter.pageTitle("Talk:Main Page"); got != "old/diskussion/main page" {
+		t.Errorf("pageTitle() = %q", got)
+	}
+	if got := converter.pageTitle("Foo: bar"); got != "old/foo: bar" {
+		t.Errorf("pageTitle() = %q", got)
+	}
+	if got := converter.fileName("Datei:My Picture.PNG"); got != "old/my_picture.png" {
+		t.Errorf("fileName() = %q", got)
+	}
+}